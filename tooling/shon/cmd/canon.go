@@ -0,0 +1,110 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	canonHash    bool
+	canonResolve bool
+)
+
+// canonCmd represents the canon command
+var canonCmd = &cobra.Command{
+	Use:   "canon [inputs...]",
+	Short: "Write SHON in canonical form or print its content hash",
+	Long: `Writes a document in canonical form: sorted keys and namespaces,
+normalized numbers, decimals and timestamps, no comments and fixed
+whitespace, so documents that differ only in formatting have the same
+canonical form.
+
+With --hash, prints the SHA-256 digest of the canonical form of each input
+instead, so "shon canon --hash a.shon b.shon" shows whether two documents
+are semantically identical. With --resolve, includes, aliases and constants
+are resolved first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputs := args
+		if InputFile != "" {
+			inputs = append([]string{InputFile}, inputs...)
+		}
+		if len(inputs) == 0 {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		if canonHash {
+			for _, input := range inputs {
+				doc, err := loadCanonical(input)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Canonicalization failed: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("%s  %s\n", pkg.Hash(doc), input)
+			}
+			return
+		}
+		if len(inputs) > 1 {
+			fmt.Println("Only one input can be canonicalized without --hash. Cancelling.")
+			return
+		}
+
+		doc, err := loadCanonical(inputs[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Canonicalization failed: %v\n", err)
+			os.Exit(1)
+		}
+		out := pkg.Canonicalize(doc)
+		if OutputFile == "" {
+			os.Stdout.Write(out)
+			return
+		}
+		if err := os.WriteFile(OutputFile, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Canonicalization failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ Canonical SHON written to %s\n", OutputFile)
+	},
+}
+
+// loadCanonical reads the document canon works on: the file as written,
+// or with --resolve the file with its includes, aliases and constants
+// resolved.
+func loadCanonical(input string) (*pkg.Document, error) {
+	if canonResolve {
+		doc, _, err := pkg.LoadResolvedWithLimits(input, Project.IncludeRoot(input), Limits)
+		return doc, err
+	}
+	_, doc, err := readDocument(input)
+	return doc, err
+}
+
+func init() {
+	rootCmd.AddCommand(canonCmd)
+	canonCmd.Flags().BoolVar(&canonHash, "hash", false, "Print the SHA-256 digest of each input's canonical form")
+	canonCmd.Flags().BoolVar(&canonResolve, "resolve", false, "Resolve includes, aliases and constants first")
+}
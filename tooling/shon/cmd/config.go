@@ -0,0 +1,86 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the project configuration",
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Prints the settings in effect as a .shonrc.shon document: the nearest
+.shonrc.shon above the working directory, or --rc, with command line flags
+applied over it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if Project.Path == "" {
+			fmt.Printf("// No %s found; using defaults\n", pkg.ProjectConfigFile)
+		} else {
+			fmt.Printf("// From %s\n", Project.Path)
+		}
+		fmt.Print(pkg.Encode(Project.Document(), pkg.EncodeOptions{Indent: Indentation}))
+	},
+}
+
+// schemaPathFor returns the schema given with --schema, or else the one the
+// document declares with $schema, looked up in the project's schema paths
+// if it is not where the document says. It returns "" if there is none.
+func schemaPathFor(inputPath string, doc *pkg.Document) string {
+	schemaPath := SchemaFile
+	if schemaPath == "" {
+		schemaPath = schema.PathFor(inputPath, doc)
+	}
+	if schemaPath == "" {
+		return ""
+	}
+	return Project.FindSchema(schemaPath)
+}
+
+// defaultOutputFile names the output of a conversion without -o: the input
+// with its extension replaced by the project's output format for SHON, and
+// by .shon for everything else.
+func defaultOutputFile(input string) string {
+	ext := filepath.Ext(input)
+	format := "shon"
+	if strings.EqualFold(ext, ".shon") {
+		format = Project.OutputFormat
+	}
+	return strings.TrimSuffix(input, ext) + "." + format
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
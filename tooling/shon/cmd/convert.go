@@ -23,23 +23,177 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
 	"github.com/spf13/cobra"
 )
 
+var (
+	keepMeta     bool
+	fillDefaults bool
+	tupleObjects bool
+	convertJobs  int
+	revealSecret bool
+	noInterp     bool
+	overwrite    bool
+)
+
 // convertCmd represents the convert command
 var convertCmd = &cobra.Command{
-	Use:   "convert",
+	Use:   "convert [inputs...]",
 	Short: "Convert to and from SHON format",
+	Long: `Converts between SHON, JSON and CSV, choosing the conversion from the
+file extensions. Without -o, SHON is written next to the input in the
+project's output format (json unless .shonrc.shon says otherwise) and other
+formats are converted to SHON.
+
+Inputs may also be directories and glob patterns, in which ** matches any
+number of directories, such as "data/**/*.shon". Every convertible file they
+name is converted on --jobs workers, with -o naming the directory the
+directory layout is mirrored into. A summary is printed at the end and the
+command fails if any conversion did. Without -o, a batch refuses to write
+over a file it could itself convert, such as a.shon next to a.json, unless
+--overwrite is given, and it never writes over one of its inputs.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		err := pkg.ConvertFile(InputFile, OutputFile, SortKeys)
-		if err != nil {
-			fmt.Println("Conversion failed:", err)
+		inputs := args
+		if InputFile != "" {
+			inputs = append([]string{InputFile}, inputs...)
+		}
+		if len(inputs) == 0 {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if len(inputs) > 1 || pkg.IsBatchInput(inputs[0]) {
+			convertBatch(inputs)
+			return
+		}
+
+		if OutputFile == "" {
+			OutputFile = defaultOutputFile(inputs[0])
+		}
+		if err := convertFile(inputs[0], OutputFile, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Conversion failed: %v\n", err)
+			os.Exit(1)
 		}
 	},
 }
 
+// convertFile converts one file with the options the flags select.
+func convertFile(input, output string, quiet bool) error {
+	opts := pkg.ConvertOptions{SortKeys: SortKeys, KeepMeta: keepMeta, IncludeRoot: Project.IncludeRoot(input), Quiet: quiet, NoInterpolation: noInterp, Limits: Limits}
+	if revealSecret {
+		opts.RevealSecrets = true
+		opts.SecretKey = loadSecretKey()
+	}
+	if fillDefaults || tupleObjects {
+		doc, _, err := pkg.LoadResolvedWithLimits(input, opts.IncludeRoot, opts.Limits)
+		if err != nil {
+			return err
+		}
+		s, err := findSchema(input, doc)
+		if err != nil {
+			return err
+		}
+		if fillDefaults {
+			opts.Defaults = s
+		}
+		if tupleObjects {
+			opts.TupleObjects = s
+		}
+	}
+	if strings.EqualFold(filepath.Ext(input), ".json") {
+		if err := setJSONSchema(&opts, output); err != nil {
+			return err
+		}
+	}
+	return pkg.ConvertFileWithOptions(input, output, opts)
+}
+
+// convertBatch converts every file the inputs name, reporting failures as
+// it goes and exiting non-zero if there were any.
+func convertBatch(inputs []string) {
+	jobs, err := pkg.PlanBatch(inputs, OutputFile, overwrite, batchFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Conversion failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No convertible files found. Cancelling.")
+		return
+	}
+
+	results := pkg.RunBatch(jobs, convertJobs, func(job pkg.ConvertJob) error {
+		return convertFile(job.Input, job.Output, true)
+	})
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "✘ %s: %v\n", r.Input, r.Err)
+			continue
+		}
+		pkg.DebugPrint(r.Input+" → "+r.Output, Verbose)
+	}
+
+	fmt.Printf("Converted %d of %d files", len(results)-failed, len(results))
+	if failed > 0 {
+		fmt.Printf(", %d failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+// batchFormat is the format a file found in a directory or pattern is
+// converted to: SHON to the project's output format, JSON and CSV to SHON.
+// SHON is skipped when the project's output format is SHON itself.
+func batchFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".shon":
+		if Project.OutputFormat != "shon" {
+			return Project.OutputFormat
+		}
+	case ".json", ".csv":
+		return "shon"
+	}
+	return ""
+}
+
+// setJSONSchema picks the schema that types JSON input: --schema, or else
+// the .shos file the SHON output will name in $schema, if it exists.
+func setJSONSchema(opts *pkg.ConvertOptions, output string) error {
+	schemaPath := SchemaFile
+	if schemaPath == "" {
+		schemaPath = filepath.Join(filepath.Dir(output), pkg.DefaultSchemaRef(output))
+		if _, err := os.Stat(schemaPath); err != nil {
+			return nil
+		}
+	}
+	pkg.DebugPrint("Typing JSON with schema "+schemaPath, Verbose)
+
+	s, err := schema.LoadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+	opts.Schema = s
+	if rel, err := filepath.Rel(filepath.Dir(output), schemaPath); err == nil {
+		opts.SchemaRef = filepath.ToSlash(rel)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(convertCmd)
+	convertCmd.Flags().BoolVar(&fillDefaults, "fill-defaults", false, "Fill missing optional fields with schema defaults in JSON output")
+	convertCmd.Flags().BoolVar(&tupleObjects, "tuple-objects", false, "Write named tuples as JSON objects keyed by the position names in the schema")
+	convertCmd.Flags().StringVar(&SchemaFile, "schema", "", "File path of the .shos schema used to type JSON input or fill defaults (default: the document's $schema)")
+	convertCmd.Flags().IntVarP(&convertJobs, "jobs", "j", 0, "Number of files converted at once when converting directories and patterns (default: one per CPU)")
+	convertCmd.Flags().BoolVar(&revealSecret, "reveal-secrets", false, "Write $secret values into JSON decrypted instead of redacted")
+	convertCmd.Flags().StringVar(&secretKeyFile, "key-file", "", "File holding the base64 secret key for --reveal-secrets (default: $"+pkg.SecretKeyEnv+")")
+	convertCmd.Flags().BoolVar(&noInterp, "no-interpolate", false, "Reject $env and $expr values instead of interpolating them, for untrusted input")
+	convertCmd.Flags().BoolVar(&keepMeta, "keep-meta", false, "Keep $tags, $type and other metadata in JSON output")
+	convertCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Let a batch without -o write over existing files it could convert")
 }
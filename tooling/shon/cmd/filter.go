@@ -0,0 +1,66 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filterTags string
+)
+
+// filterCmd represents the filter command
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Keep only the objects whose $tags match an expression",
+	Long: `Keep only the objects whose $tags match an expression.
+
+Comma separated terms must all match, '|' separates alternatives and a
+leading '!' negates a term:
+
+  shon filter -i config.shon --tags "public|beta,!internal"
+
+Objects without $tags are always kept. A namespace whose own $tags do not
+match is dropped, and included files are filtered and written inline.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		opts := pkg.EncodeOptions{Indent: Indentation, SortKeys: SortKeys}
+		if err := pkg.FilterFile(InputFile, Project.IncludeRoot(InputFile), OutputFile, filterTags, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Filter failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(filterCmd)
+	filterCmd.Flags().StringVarP(&filterTags, "tags", "t", "", "Tag expression, e.g. public,!internal")
+}
@@ -25,14 +25,14 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	"github.com/sottey/shon/tooling/shon/pkg"
 	"github.com/spf13/cobra"
 )
 
 var (
-	minify bool
+	minify        bool
+	expandAliases bool
 )
 
 // formatCmd represents the format command
@@ -53,60 +53,29 @@ var formatCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		lines := strings.Split(string(data), "\n")
-		var out strings.Builder
-		level := 0
-		inMultilineComment := false
-
-		for _, line := range lines {
-			pkg.DebugPrint("Trimming Space...", Verbose)
-			trimmed := strings.TrimSpace(line)
-
-			pkg.DebugPrint("Removing comments...", Verbose)
-			if strings.HasPrefix(trimmed, "/*") {
-				inMultilineComment = true
-			}
-
-			if inMultilineComment {
-				if !minify {
-					out.WriteString(pkg.IndentLine(level, Indentation, trimmed) + "\n")
-				}
-				if strings.Contains(trimmed, "*/") {
-					inMultilineComment = false
-				}
-				continue
+		if expandAliases {
+			pkg.DebugPrint("Expanding aliases...", Verbose)
+			expanded, diags, err := pkg.ExpandAliasesSource(data)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse SHON file: %v\n", err)
+				os.Exit(1)
 			}
-
-			if trimmed == "" {
-				if !minify {
-					out.WriteString("\n")
+			if pkg.HasErrors(diags) {
+				for _, d := range diags {
+					fmt.Fprintln(os.Stderr, d.String())
 				}
-				continue
-			}
-
-			pkg.DebugPrint("Cleaning up brackets", Verbose)
-			openBraces := strings.Count(trimmed, "{") + strings.Count(trimmed, "[")
-			closeBraces := strings.Count(trimmed, "}") + strings.Count(trimmed, "]")
-
-			if closeBraces > openBraces {
-				level--
-			}
-
-			if minify {
-				out.WriteString(strings.TrimSpace(trimmed))
-			} else {
-				out.WriteString(pkg.IndentLine(level, Indentation, trimmed) + "\n")
-			}
-
-			if openBraces > closeBraces {
-				level++
+				os.Exit(1)
 			}
+			data = expanded
 		}
 
+		pkg.DebugPrint("Formatting...", Verbose)
+		formatted := pkg.FormatSource(data, Indentation, minify)
+
 		if OutputFile == "" {
-			fmt.Println(out.String())
+			fmt.Println(formatted)
 		} else {
-			err := os.WriteFile(OutputFile, []byte(out.String()), 0644)
+			err := os.WriteFile(OutputFile, []byte(formatted), 0644)
 			if err != nil {
 				log.Fatalf("failed to write to file %s: %v", OutputFile, err)
 				return
@@ -120,4 +89,5 @@ var formatCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(formatCmd)
 	formatCmd.Flags().BoolVarP(&minify, "minify", "m", false, "Minify shon (remove whitespace)")
+	formatCmd.Flags().BoolVar(&expandAliases, "expand-aliases", false, "Rewrite aliased references to their namespaces and drop @alias")
 }
@@ -0,0 +1,117 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/lint"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var lintConfig string
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check a SHON file for likely mistakes and inconsistencies",
+	Long: `Reports problems that parse and validate but are probably mistakes:
+duplicate keys, lookup namespaces nothing references, inconsistent key
+naming, decimals written as number literals, timestamps without a zone,
+trailing commas, namespaces the schema does not cover and deep nesting.
+
+Rules are configured by the @lint namespace of the project's .shonrc.shon
+and then by the nearest .shonlint file, or --config, and can be
+silenced for one line with a // shonlint-disable-line or
+// shonlint-disable-next-line comment, optionally followed by rule names.
+Exits with status 1 if any finding is an error.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		src, err := os.ReadFile(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lint failed: %v\n", err)
+			os.Exit(1)
+		}
+		doc, err := pkg.ParseWithLimits(src, Limits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lint failed: %s: %v\n", InputFile, err)
+			os.Exit(1)
+		}
+
+		configPath := lintConfig
+		if configPath == "" {
+			configPath = lint.FindConfig(filepath.Dir(InputFile))
+		}
+		config := lint.DefaultConfig()
+		if Project.Lint != nil {
+			if err := config.Apply(Project.Lint); err != nil {
+				fmt.Fprintf(os.Stderr, "Lint failed: %s: %v\n", Project.Path, err)
+				os.Exit(1)
+			}
+		}
+		if configPath != "" {
+			pkg.DebugPrint("Using lint config "+configPath, Verbose)
+			if err := config.ApplyFile(configPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Lint failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var s *schema.Schema
+		if schemaPath := schemaPathFor(InputFile, doc); schemaPath != "" {
+			pkg.DebugPrint("Using schema "+schemaPath, Verbose)
+			if s, err = schema.LoadFile(schemaPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Lint failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		findings, err := lint.Lint(src, config, s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lint failed: %s: %v\n", InputFile, err)
+			os.Exit(1)
+		}
+		for _, f := range findings {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", InputFile, f)
+		}
+		if lint.HasErrors(findings) {
+			os.Exit(1)
+		}
+		if len(findings) == 0 {
+			fmt.Printf("✔ %s has no lint findings\n", InputFile)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringVar(&lintConfig, "config", "", "File path of the .shonlint config (default: the nearest .shonlint)")
+	lintCmd.Flags().StringVar(&SchemaFile, "schema", "", "File path of the .shos schema (default: the document's $schema)")
+}
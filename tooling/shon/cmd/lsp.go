@@ -0,0 +1,59 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg/lsp"
+	"github.com/spf13/cobra"
+)
+
+// lspCmd represents the lsp command
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run the SHON language server over stdio",
+	Long: `Starts a Language Server Protocol server on stdin and stdout for editors.
+It reports parse, include and schema validation diagnostics as you type,
+shows schema types and descriptions on hover, completes field names from
+the document's .shos schema, jumps from &refs and $schema to their
+definitions, outlines namespaces as document symbols and formats documents.
+Includes may reach up to the includeRoots of the project config, as they
+may on the command line.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		server := lsp.NewServer(os.Stdin, os.Stdout)
+		server.Project = Project
+		server.Limits = Limits
+		if err := server.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Language server failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lspCmd)
+	// Editors commonly pass --stdio; it is the only transport, so it is
+	// accepted and ignored.
+	lspCmd.Flags().Bool("stdio", true, "Communicate over stdin and stdout")
+}
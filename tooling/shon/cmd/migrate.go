@@ -0,0 +1,105 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom string
+	migrateTo   string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade a SHON file to a newer schema version",
+	Long: `Applies the @migrations steps of the document's schema, from the
+version the document declares in $version up to the schema's $version (or
+--to). Only the changed fields are rewritten, so comments and formatting
+are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		src, err := os.ReadFile(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migrate failed: %v\n", err)
+			os.Exit(1)
+		}
+		doc, err := pkg.ParseWithLimits(src, Limits)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migrate failed: %v\n", err)
+			os.Exit(1)
+		}
+		s := loadSchema(InputFile, doc)
+
+		from := migrateFrom
+		if from == "" {
+			from = pkg.DocumentVersion(doc)
+		}
+		if from == "" {
+			fmt.Fprintln(os.Stderr, "The document has no $version; pass --from. Cancelling.")
+			os.Exit(1)
+		}
+		to := migrateTo
+		if to == "" {
+			to = s.SchemaVersion()
+		}
+		steps, err := pkg.MigrationPath(s.Migrations(), from, to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migrate failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, step := range steps {
+			pkg.DebugPrint(fmt.Sprintf("Migrating %s -> %s (%d ops)", step.From, step.To, len(step.Ops)), Verbose)
+		}
+
+		out, err := pkg.MigrateSource(src, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Migrate failed: %v\n", err)
+			os.Exit(1)
+		}
+		if OutputFile == "" {
+			fmt.Print(string(out))
+			return
+		}
+		if err := os.WriteFile(OutputFile, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Migrate failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().StringVar(&SchemaFile, "schema", "", "File path of the .shos schema (default: the document's $schema)")
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "Version the document is at (default: its $version)")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "Version to migrate to (default: the schema's $version)")
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	patchFile string
+)
+
+// patchCmd represents the patch command
+var patchCmd = &cobra.Command{
+	Use:   "patch",
+	Short: "Apply a SHON patch document to a SHON file",
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" || patchFile == "" {
+			fmt.Println("Both an input file and a patch file are required. Cancelling.")
+			return
+		}
+
+		opts := pkg.EncodeOptions{Indent: Indentation, SortKeys: SortKeys}
+		if err := pkg.PatchFile(InputFile, patchFile, OutputFile, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Patch failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(patchCmd)
+	patchCmd.Flags().StringVarP(&patchFile, "patch", "p", "", "File path of the SHON patch document")
+}
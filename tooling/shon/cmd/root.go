@@ -22,8 +22,10 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/sottey/shon/tooling/shon/pkg"
 	"github.com/spf13/cobra"
 )
 
@@ -33,12 +35,22 @@ var (
 	SortKeys    bool
 	Verbose     bool
 	Indentation int
+
+	// Project is the .shonrc.shon configuration in effect.
+	Project    *pkg.ProjectConfig
+	configFile string
+
+	// Limits bounds every document the commands read.
+	Limits = pkg.DefaultLimits
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "shon",
 	Short: "Conversion and formatting for SHON files",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		loadProjectConfig(cmd)
+	},
 }
 
 func Execute() {
@@ -54,4 +66,36 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&SortKeys, "sort", "s", false, "If present, keys will be sorted alphabetically")
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "If present, additional information will be displayed")
 	rootCmd.PersistentFlags().IntVarP(&Indentation, "indentation-size", "n", 4, "Number of spaces to use for each level of indentation (Default: 4)")
+	rootCmd.PersistentFlags().StringVar(&configFile, "rc", "", "File path of the project config (default: the nearest "+pkg.ProjectConfigFile+")")
+}
+
+// loadProjectConfig reads --rc or the nearest .shonrc.shon above the
+// working directory and uses its settings for the flags not given on the
+// command line.
+func loadProjectConfig(cmd *cobra.Command) {
+	Project = pkg.DefaultProjectConfig()
+	path := configFile
+	if path == "" {
+		path = pkg.FindProjectConfig(".")
+	}
+	if path != "" {
+		pkg.DebugPrint("Using project config "+path, Verbose)
+		var err error
+		if Project, err = pkg.LoadProjectConfig(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read project config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("indentation-size") {
+		Project.Indentation = Indentation
+	} else {
+		Indentation = Project.Indentation
+	}
+	if flags.Changed("sort") {
+		Project.SortKeys = SortKeys
+	} else {
+		SortKeys = Project.SortKeys
+	}
 }
@@ -0,0 +1,126 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	schemaFormat    string
+	importNamespace string
+)
+
+// schemaCmd groups the commands that work on .shos schema files
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Convert .shos schemas to and from other schema languages",
+}
+
+// schemaExportCmd represents the schema export command
+var schemaExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a .shos schema, e.g. as JSON Schema",
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if schemaFormat != "jsonschema" {
+			fmt.Fprintf(os.Stderr, "Unsupported export format %q. Supported: jsonschema\n", schemaFormat)
+			os.Exit(1)
+		}
+
+		s, err := schema.LoadFile(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load schema: %v\n", err)
+			os.Exit(1)
+		}
+		out, err := pkg.MarshalJSON(s.JSONSchema(), "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if OutputFile == "" {
+			fmt.Println(string(out))
+			return
+		}
+		if err := os.WriteFile(OutputFile, append(out, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ JSON Schema written to %s\n", OutputFile)
+	},
+}
+
+// schemaImportCmd represents the schema import command
+var schemaImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import a schema, e.g. JSON Schema, as .shos",
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if schemaFormat != "jsonschema" {
+			fmt.Fprintf(os.Stderr, "Unsupported import format %q. Supported: jsonschema\n", schemaFormat)
+			os.Exit(1)
+		}
+
+		data, err := os.ReadFile(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read schema: %v\n", err)
+			os.Exit(1)
+		}
+		doc, warnings, err := schema.ImportJSONSchema(data, importNamespace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
+		}
+		for _, d := range warnings {
+			fmt.Fprintln(os.Stderr, d.String())
+		}
+
+		if err := pkg.WriteFile(doc, OutputFile, pkg.EncodeOptions{Indent: Indentation, SortKeys: SortKeys}); err != nil {
+			fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+			os.Exit(1)
+		}
+		if OutputFile != "" {
+			fmt.Printf("✔ Schema written to %s\n", OutputFile)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	schemaCmd.AddCommand(schemaExportCmd)
+	schemaCmd.AddCommand(schemaImportCmd)
+	schemaExportCmd.Flags().StringVar(&schemaFormat, "to", "jsonschema", "Format to export to (jsonschema)")
+	schemaImportCmd.Flags().StringVar(&schemaFormat, "from", "jsonschema", "Format to import from (jsonschema)")
+	schemaImportCmd.Flags().StringVar(&importNamespace, "namespace", "data", "Namespace for schemas not exported from .shos")
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretKeyFile string
+	secretValue   string
+)
+
+// secretCmd represents the secret command
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Encrypt and decrypt $secret values",
+	Long: `Manages $secret("...") values: strings encrypted with AES-GCM, so
+credentials can live in SHON files. The key is base64, 16, 24 or 32 bytes,
+read from --key-file or else the SHON_SECRET_KEY environment variable.
+
+JSON conversion redacts secrets as "[secret]" unless shon convert is given
+--reveal-secrets and a key.
+
+A secret is tied to the path of its field and only decrypts there, so it
+cannot be copied into another field. To move or rename a field holding a
+secret, decrypt it first and encrypt it again at its new path.`,
+}
+
+// secretKeygenCmd represents the secret keygen command
+var secretKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create a random 256-bit secret key",
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := pkg.GenerateSecretKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		if OutputFile == "" {
+			fmt.Println(key)
+			return
+		}
+		if err := os.WriteFile(OutputFile, []byte(key+"\n"), 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ Secret key written to %s\n", OutputFile)
+	},
+}
+
+// secretEncryptCmd represents the secret encrypt command
+var secretEncryptCmd = &cobra.Command{
+	Use:   "encrypt [paths...]",
+	Short: "Encrypt string fields of a SHON file, or a single --value",
+	Long: `Replaces the strings at the given paths, such as db.password, with
+$secret values, keeping comments and layout. The file is rewritten in
+place unless -o is given. With --value and a single path, prints the
+$secret literal of that text for the field at the path instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		key := loadSecretKey()
+		if cmd.Flags().Changed("value") {
+			if len(args) != 1 {
+				fmt.Println("--value needs the path of the field it is for. Cancelling.")
+				return
+			}
+			secret, err := pkg.EncryptSecret(secretValue, args[0], key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(pkg.EncodeValue(secret, 0, pkg.EncodeOptions{}))
+			return
+		}
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if len(args) == 0 {
+			fmt.Println("No paths to encrypt specified. Cancelling.")
+			return
+		}
+		editSecrets(args, key, pkg.EncryptSource, "Encryption")
+	},
+}
+
+// secretDecryptCmd represents the secret decrypt command
+var secretDecryptCmd = &cobra.Command{
+	Use:   "decrypt [paths...]",
+	Short: "Decrypt $secret values of a SHON file back to strings",
+	Long: `Replaces the secrets at the given paths, or every secret when no path
+is given, with their plaintext strings. The file is rewritten in place
+unless -o is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		editSecrets(args, loadSecretKey(), pkg.DecryptSource, "Decryption")
+	},
+}
+
+// editSecrets applies edit to the input file and writes the result to -o
+// or back to the input.
+func editSecrets(paths []string, key []byte, edit func(src []byte, paths []string, key []byte) ([]byte, error), action string) {
+	src, err := os.ReadFile(InputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", action, err)
+		os.Exit(1)
+	}
+	out, err := edit(src, paths, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s: %v\n", action, InputFile, err)
+		os.Exit(1)
+	}
+	target := OutputFile
+	if target == "" {
+		target = InputFile
+	}
+	if err := os.WriteFile(target, out, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", action, err)
+		os.Exit(1)
+	}
+	fmt.Printf("✔ SHON file written to %s\n", target)
+}
+
+// loadSecretKey reads the key from --key-file or SHON_SECRET_KEY, exiting
+// if there is none.
+func loadSecretKey() []byte {
+	key, err := pkg.LoadSecretKey(secretKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load secret key: %v\n", err)
+		os.Exit(1)
+	}
+	if key == nil {
+		fmt.Fprintf(os.Stderr, "No secret key: use --key-file or set %s. Cancelling.\n", pkg.SecretKeyEnv)
+		os.Exit(1)
+	}
+	return key
+}
+
+func init() {
+	rootCmd.AddCommand(secretCmd)
+	secretCmd.AddCommand(secretKeygenCmd)
+	secretCmd.AddCommand(secretEncryptCmd)
+	secretCmd.AddCommand(secretDecryptCmd)
+	secretCmd.PersistentFlags().StringVar(&secretKeyFile, "key-file", "", "File holding the base64 secret key (default: $"+pkg.SecretKeyEnv+")")
+	secretEncryptCmd.Flags().StringVar(&secretValue, "value", "", "Text to encrypt into a $secret literal for the one path given")
+}
@@ -0,0 +1,227 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	signKey       string
+	signEmbed     bool
+	signSignature string
+)
+
+// signCmd represents the sign command
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign a SHON document with an ed25519 key",
+	Long: `Signs the canonical form of a document (see shon canon), so the
+signature survives reformatting and comment changes but not changes to the
+data. Includes are resolved first and the signature covers the merged
+document, so changing an included file breaks it too, while moving data
+between the document and its includes does not. The signature is written
+to a sidecar file, the input's name with .sig appended or -o, or with
+--embed to a $signature field at the top of the document itself, which the
+signature does not cover.
+
+Create a key pair with shon keygen.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if signKey == "" {
+			fmt.Println("No signing key specified (use --key). Cancelling.")
+			return
+		}
+
+		keyData, err := os.ReadFile(signKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+			os.Exit(1)
+		}
+		key, err := pkg.ParsePrivateKey(keyData)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signing failed: %s: %v\n", signKey, err)
+			os.Exit(1)
+		}
+		src, doc, err := readDocument(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+			os.Exit(1)
+		}
+		signature := pkg.Sign(doc, key)
+
+		if signEmbed {
+			signed, err := pkg.SetMetaSource(src, pkg.SignatureMeta, signature)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+				os.Exit(1)
+			}
+			out := OutputFile
+			if out == "" {
+				out = InputFile
+			}
+			if err := os.WriteFile(out, signed, 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("✔ Signed SHON written to %s\n", out)
+			return
+		}
+
+		out := OutputFile
+		if out == "" {
+			out = InputFile + pkg.SignatureExt
+		}
+		if err := os.WriteFile(out, []byte(signature+"\n"), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Signing failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ Signature written to %s\n", out)
+	},
+}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the ed25519 signature of a SHON document",
+	Long: `Checks a signature made by shon sign against a public key: the file
+given with --signature, or else the document's $signature field, or else
+the sidecar file with .sig appended to the input's name. As when signing,
+includes are resolved first, so the files the document includes are
+checked too. Exits with status 1 if the document is unsigned or the
+signature does not match.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+		if signKey == "" {
+			fmt.Println("No public key specified (use --key). Cancelling.")
+			return
+		}
+
+		keyData, err := os.ReadFile(signKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+			os.Exit(1)
+		}
+		key, err := pkg.ParsePublicKey(keyData)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed: %s: %v\n", signKey, err)
+			os.Exit(1)
+		}
+		_, doc, err := readDocument(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		signature, from := "", signSignature
+		if from == "" {
+			if signature = pkg.EmbeddedSignature(doc); signature != "" {
+				from = "$" + pkg.SignatureMeta
+			} else {
+				from = InputFile + pkg.SignatureExt
+			}
+		}
+		if signature == "" {
+			data, err := os.ReadFile(from)
+			if errors.Is(err, os.ErrNotExist) && signSignature == "" {
+				fmt.Fprintf(os.Stderr, "✘ %s is not signed: no $%s and no %s\n", InputFile, pkg.SignatureMeta, from)
+				os.Exit(1)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+				os.Exit(1)
+			}
+			signature = string(data)
+		}
+		pkg.DebugPrint("Using signature from "+from, Verbose)
+
+		if err := pkg.Verify(doc, key, signature); err != nil {
+			fmt.Fprintf(os.Stderr, "✘ %s: %v\n", InputFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ %s has a valid signature\n", InputFile)
+	},
+}
+
+// keygenCmd represents the keygen command
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create an ed25519 key pair for shon sign and shon verify",
+	Long: `Writes a PEM encoded private key to <name>.key, readable only by its
+owner, and the matching public key to <name>.pub, where <name> is -o or
+"shon-signing".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := OutputFile
+		if name == "" {
+			name = "shon-signing"
+		}
+		private, public, err := pkg.GenerateSigningKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(name+".key", private, 0600); err != nil {
+			fmt.Fprintf(os.Stderr, "Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(name+".pub", public, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Key generation failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✔ Keys written to %s.key and %s.pub\n", name, name)
+	},
+}
+
+// readDocument reads a document's source and loads it with its includes
+// merged in, which is what a signature covers.
+func readDocument(path string) ([]byte, *pkg.Document, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, err := pkg.LoadFileWithLimits(path, Project.IncludeRoot(path), Limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, doc, nil
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(keygenCmd)
+	signCmd.Flags().StringVar(&signKey, "key", "", "File path of the PEM encoded ed25519 private key")
+	signCmd.Flags().BoolVar(&signEmbed, "embed", false, "Write the signature to a $signature field in the document instead of a sidecar file")
+	verifyCmd.Flags().StringVar(&signKey, "key", "", "File path of the PEM encoded ed25519 public key")
+	verifyCmd.Flags().StringVar(&signSignature, "signature", "", "File path of the signature (default: $signature, or the input with .sig appended)")
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	detectOnly bool
+)
+
+// upgradeCmd represents the upgrade command
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Rewrite a SHON 0.2-0.5 document or schema in current syntax",
+	Long: `Detects the SHON version a file was written against and rewrites it in
+0.6 syntax: triple-quoted strings, namespaced keys, _comment keys and
+@schema.<name> schemas are translated, and anything that cannot be is
+reported. Comments and formatting are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		src, err := os.ReadFile(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Upgrade failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		info := pkg.DetectVersion(src)
+		how := "from its syntax"
+		if info.Declared {
+			how = "from $schema"
+		}
+		fmt.Fprintf(os.Stderr, "Detected SHON %s (%s)\n", info.Version, how)
+		if len(info.Features) > 0 {
+			fmt.Fprintf(os.Stderr, "Features: %s\n", strings.Join(info.Features, ", "))
+		}
+		if detectOnly {
+			return
+		}
+
+		out, report, err := pkg.Upgrade(src)
+		if report != nil {
+			if Verbose {
+				for _, c := range report.Changes {
+					fmt.Fprintln(os.Stderr, c)
+				}
+			}
+			for _, d := range report.Diagnostics {
+				fmt.Fprintln(os.Stderr, d.String())
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Upgrade failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		if OutputFile == "" {
+			fmt.Print(string(out))
+			return
+		}
+		if err := os.WriteFile(OutputFile, out, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Upgrade failed: %v\n", err)
+			os.Exit(1)
+		}
+		kind := "Document"
+		if report.Schema {
+			kind = "Schema"
+		}
+		fmt.Printf("✔ %s upgraded to SHON %s with %d changes: %s\n", kind, pkg.CurrentVersion, len(report.Changes), OutputFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().BoolVar(&detectOnly, "detect", false, "Only report the detected version and features")
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	SchemaFile string
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate a SHON file against its .shos schema",
+	Run: func(cmd *cobra.Command, args []string) {
+		if InputFile == "" {
+			fmt.Println("No input file specified. Cancelling.")
+			return
+		}
+
+		doc, warnings, err := loadInterpolated(InputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		s := loadSchema(InputFile, doc)
+
+		diags := append(warnings, s.Validate(doc)...)
+		for _, d := range diags {
+			fmt.Fprintln(os.Stderr, d.String())
+		}
+		if pkg.HasErrors(diags) {
+			os.Exit(1)
+		}
+		fmt.Printf("✔ %s is valid\n", InputFile)
+	},
+}
+
+// loadInterpolated loads a document as it is validated: with its includes,
+// aliases and constants resolved and its $env and $expr values
+// interpolated.
+func loadInterpolated(input string) (*pkg.Document, []pkg.Diagnostic, error) {
+	doc, warnings, err := pkg.LoadResolvedWithLimits(input, Project.IncludeRoot(input), Limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc, err = pkg.Interpolate(doc, pkg.InterpolateOptions{Limits: Limits}); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", input, err)
+	}
+	return doc, warnings, nil
+}
+
+// loadSchema compiles the schema given with --schema, or else the one the
+// document declares with $schema, exiting if there is none.
+func loadSchema(inputPath string, doc *pkg.Document) *schema.Schema {
+	s, err := findSchema(inputPath, doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v. Cancelling.\n", err)
+		os.Exit(1)
+	}
+	return s
+}
+
+// findSchema is loadSchema returning an error instead of exiting.
+func findSchema(inputPath string, doc *pkg.Document) (*schema.Schema, error) {
+	schemaPath := schemaPathFor(inputPath, doc)
+	if schemaPath == "" {
+		return nil, fmt.Errorf("no schema given and %s has no $schema", inputPath)
+	}
+	pkg.DebugPrint("Using schema "+schemaPath, Verbose)
+
+	s, err := schema.LoadFile(schemaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+	return s, nil
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVar(&SchemaFile, "schema", "", "File path of the .shos schema (default: the document's $schema)")
+}
@@ -0,0 +1,278 @@
+/*
+Copyright © 2025 sottey
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchInterval time.Duration
+	watchDebounce time.Duration
+	watchNoJSON   bool
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [inputs...]",
+	Short: "Re-validate and re-convert SHON files whenever they change",
+	Long: `Watches .shon and .shos files, given as files, directories or glob
+patterns as for convert (default: the working directory). Every document is
+checked at the start: it is loaded, validated against its schema if it has
+one and written to JSON, mirrored into -o if given or next to the document
+otherwise. Documents with errors keep their last JSON. After that, a change
+re-checks the changed files and the documents that include them or use
+them as their schema.
+
+Files are polled every --interval and a change is handled once files have
+been left alone for --debounce, so an editor saving in several writes is
+handled once. Diagnostics are printed for each file as it is checked. Stop
+with Ctrl-C.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputs := args
+		if InputFile != "" {
+			inputs = append([]string{InputFile}, inputs...)
+		}
+		if len(inputs) == 0 {
+			inputs = []string{"."}
+		}
+
+		w := &pkg.Watcher{Inputs: inputs, Match: isWatched, Interval: watchInterval, Debounce: watchDebounce}
+		files, err := w.Files()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Watch failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Watching %d files. Press Ctrl-C to stop.\n", len(files))
+		deps := map[string]map[string]bool{}
+		checkWatched(inputs, files, deps)
+
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+
+		err = w.Run(stop, func(changed []string) {
+			fmt.Printf("\n[%s] %s changed\n", time.Now().Format("15:04:05"), strings.Join(changed, ", "))
+			files, err := w.Files()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Watch failed: %v\n", err)
+				return
+			}
+			checkWatched(inputs, affectedFiles(files, changed, deps), deps)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Watch failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func isWatched(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".shon", ".shos":
+		return true
+	}
+	return false
+}
+
+// checkWatched compiles the given schemas and checks the given documents,
+// recording in deps the files each of them reads.
+func checkWatched(inputs, files []string, deps map[string]map[string]bool) {
+	for _, f := range files {
+		deps[pkg.AbsPath(f)] = dependencies(f)
+		if strings.EqualFold(filepath.Ext(f), ".shos") {
+			if _, err := schema.LoadFile(f); err != nil {
+				fmt.Fprintf(os.Stderr, "✘ %s: %v\n", f, err)
+			}
+		}
+	}
+
+	var jobs []pkg.ConvertJob
+	if !watchNoJSON {
+		var err error
+		jobs, err = pkg.PlanBatch(inputs, OutputFile, false, func(file string) string {
+			if strings.EqualFold(filepath.Ext(file), ".shon") {
+				return "json"
+			}
+			return ""
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✘ %v\n", err)
+			return
+		}
+	}
+	outputs := map[string]string{}
+	for _, job := range jobs {
+		outputs[filepath.Clean(job.Input)] = job.Output
+	}
+
+	for _, f := range files {
+		if strings.EqualFold(filepath.Ext(f), ".shon") {
+			checkDocument(f, outputs[f])
+		}
+	}
+}
+
+// affectedFiles returns the watched files a change to changed may affect:
+// the changed files themselves and those that read one of them, according
+// to deps. Removed files are forgotten.
+func affectedFiles(files, changed []string, deps map[string]map[string]bool) []string {
+	watched := map[string]bool{}
+	for _, f := range files {
+		watched[pkg.AbsPath(f)] = true
+	}
+	changedSet := map[string]bool{}
+	for _, f := range changed {
+		changedSet[pkg.AbsPath(f)] = true
+		if !watched[pkg.AbsPath(f)] {
+			delete(deps, pkg.AbsPath(f))
+		}
+	}
+
+	var affected []string
+	for _, f := range files {
+		abs := pkg.AbsPath(f)
+		if changedSet[abs] {
+			affected = append(affected, f)
+			continue
+		}
+		for dep := range deps[abs] {
+			if changedSet[dep] {
+				affected = append(affected, f)
+				break
+			}
+		}
+	}
+	return affected
+}
+
+// dependencies returns the absolute paths of the files reading file also
+// reads: its includes and, for a document, its schema along with the
+// schema's includes and the schema files its $refs name. Files that do not
+// parse contribute nothing, and are re-checked when they change.
+func dependencies(file string) map[string]bool {
+	deps := map[string]bool{}
+	var visit func(f string, isSchema bool) *pkg.Document
+	visit = func(f string, isSchema bool) *pkg.Document {
+		doc, err := pkg.ParseFile(f)
+		if err != nil {
+			return nil
+		}
+		var next []string
+		for _, inc := range doc.Includes {
+			next = append(next, filepath.Join(filepath.Dir(f), filepath.FromSlash(inc)))
+		}
+		if isSchema {
+			pkg.WalkDocument(doc, func(v interface{}, _ string) error {
+				obj, ok := v.(*pkg.Object)
+				if !ok {
+					return nil
+				}
+				ref, _ := obj.GetMeta("ref")
+				if s, ok := ref.(string); ok {
+					if other, _, external := strings.Cut(s, "#"); external && other != "" {
+						next = append(next, filepath.Join(filepath.Dir(f), filepath.FromSlash(other)))
+					}
+				}
+				return nil
+			})
+		}
+		for _, n := range next {
+			if abs := pkg.AbsPath(n); !deps[abs] {
+				deps[abs] = true
+				visit(n, isSchema)
+			}
+		}
+		return doc
+	}
+
+	isSchema := strings.EqualFold(filepath.Ext(file), ".shos")
+	doc := visit(file, isSchema)
+	if doc == nil || isSchema {
+		return deps
+	}
+	if schemaPath := schemaPathFor(file, doc); schemaPath != "" {
+		deps[pkg.AbsPath(schemaPath)] = true
+		visit(schemaPath, true)
+	}
+	return deps
+}
+
+// checkDocument loads and validates one document, printing its diagnostics,
+// and writes it to output if it has no errors and output is not "".
+func checkDocument(input, output string) {
+	doc, diags, err := loadInterpolated(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✘ %s: %v\n", input, err)
+		return
+	}
+	if schemaPath := schemaPathFor(input, doc); schemaPath != "" {
+		s, err := schema.LoadFile(schemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✘ %s: failed to load schema: %v\n", input, err)
+			return
+		}
+		diags = append(diags, s.Validate(doc)...)
+	}
+	for _, d := range diags {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", input, d)
+	}
+	if pkg.HasErrors(diags) {
+		fmt.Fprintf(os.Stderr, "✘ %s is invalid\n", input)
+		return
+	}
+
+	if output == "" {
+		fmt.Printf("✔ %s is valid\n", input)
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(output), 0755)
+	if err == nil {
+		err = convertFile(input, output, true)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✘ %s: %v\n", input, err)
+		return
+	}
+	fmt.Printf("✔ %s is valid, JSON written to %s\n", input, output)
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 250*time.Millisecond, "How often files are checked for changes")
+	watchCmd.Flags().DurationVar(&watchDebounce, "debounce", 300*time.Millisecond, "How long files must stay unchanged before they are checked")
+	watchCmd.Flags().BoolVar(&watchNoJSON, "no-json", false, "Only validate; do not write JSON")
+	watchCmd.Flags().StringVar(&SchemaFile, "schema", "", "File path of the .shos schema (default: each document's $schema)")
+}
@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// AliasNamespace is the reserved namespace declaring namespace aliases:
+//
+//	@alias {
+//	    addr: address
+//	}
+//
+// after which &addr.hq resolves to &address.hq.
+const AliasNamespace = "alias"
+
+// Aliases returns the alias → namespace mapping declared in @alias, along
+// with diagnostics for aliases that collide with a namespace, point at
+// another alias, or name a namespace that does not exist. Invalid aliases are
+// left out of the returned map.
+func (d *Document) Aliases() (map[string]string, []Diagnostic) {
+	aliases := map[string]string{}
+	ns := d.Namespace(AliasNamespace)
+	if ns == nil {
+		return aliases, nil
+	}
+
+	var diags []Diagnostic
+	errorf := func(name, format string, args ...interface{}) {
+		diags = append(diags, Diagnostic{
+			Severity: SeverityError,
+			Path:     JoinPath(AliasNamespace, name),
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	for _, name := range ns.Body.Keys {
+		target, ok := ns.Body.Values[name].(string)
+		switch {
+		case !ok || target == "":
+			errorf(name, "alias %s must name a namespace", name)
+		case d.Namespace(name) != nil:
+			errorf(name, "alias %s collides with namespace @%s", name, name)
+		case isAlias(ns.Body, target):
+			errorf(name, "alias %s points at alias %s; aliases must name a namespace directly", name, target)
+		case d.Namespace(target) == nil && target != ConstNamespace:
+			errorf(name, "alias %s points at unknown namespace @%s", name, target)
+		default:
+			aliases[name] = target
+		}
+	}
+	return aliases, diags
+}
+
+// CanonicalRef rewrites a reference whose first segment is an alias so that
+// it names the real namespace.
+func CanonicalRef(ref Ref, aliases map[string]string) Ref {
+	s := string(ref)
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+	if target, ok := aliases[s[:end]]; ok {
+		return Ref(target + s[end:])
+	}
+	return ref
+}
+
+// Deref returns the value a reference points at, following @alias.
+func (d *Document) Deref(ref Ref) (interface{}, error) {
+	aliases, _ := d.Aliases()
+	v, err := d.Lookup(string(CanonicalRef(ref, aliases)))
+	if err != nil {
+		return nil, fmt.Errorf("unresolved reference &%s: %w", ref, err)
+	}
+	return v, nil
+}
+
+// ResolveAliases returns a copy of doc with every aliased reference rewritten
+// to its real namespace and the @alias namespace removed.
+func ResolveAliases(doc *Document) (*Document, []Diagnostic) {
+	aliases, diags := doc.Aliases()
+	out := doc.Clone()
+	out.removeNamespace(AliasNamespace)
+	rewrite := func(v interface{}, path string) interface{} {
+		if ref, ok := v.(Ref); ok {
+			return CanonicalRef(ref, aliases)
+		}
+		return v
+	}
+	out.Meta = Rewrite(out.Meta, "", rewrite).(*Object)
+	for _, ns := range out.Namespaces {
+		ns.Body = Rewrite(ns.Body, ns.Name, rewrite).(*Object)
+	}
+	return out, diags
+}
+
+// ExpandAliasesSource rewrites aliased references in SHON source text and
+// deletes the @alias block, leaving comments and layout untouched.
+func ExpandAliasesSource(src []byte) ([]byte, []Diagnostic, error) {
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	aliases, diags := doc.Aliases()
+	if HasErrors(diags) {
+		return nil, diags, nil
+	}
+
+	var out bytes.Buffer
+	depth := 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"':
+			end := skipString(src, i)
+			out.Write(src[i:end])
+			i = end
+		case c == '/' && i+1 < len(src) && (src[i+1] == '/' || src[i+1] == '*'):
+			end := skipComment(src, i)
+			out.Write(src[i:end])
+			i = end
+		case c == '&':
+			j := i + 1
+			for j < len(src) && isIdentChar(src[j]) {
+				j++
+			}
+			name := string(src[i+1 : j])
+			if target, ok := aliases[name]; ok {
+				name = target
+			}
+			out.WriteString("&" + name)
+			i = j
+		case c == '@' && depth == 0 && bytes.HasPrefix(src[i+1:], []byte(AliasNamespace)) &&
+			(i+1+len(AliasNamespace) == len(src) || !isIdentChar(src[i+1+len(AliasNamespace)])):
+			i = skipBlock(src, i)
+			for i < len(src) && (src[i] == '\n' || src[i] == '\r') {
+				i++
+			}
+		default:
+			if c == '{' || c == '[' {
+				depth++
+			} else if c == '}' || c == ']' {
+				depth--
+			}
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes(), nil, nil
+}
+
+// skipString returns the offset just past the string starting at i.
+func skipString(src []byte, i int) int {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '"', '\n':
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// skipComment returns the offset just past the comment starting at i.
+func skipComment(src []byte, i int) int {
+	if src[i+1] == '/' {
+		end := bytes.IndexByte(src[i:], '\n')
+		if end < 0 {
+			return len(src)
+		}
+		return i + end
+	}
+	end := bytes.Index(src[i+2:], []byte("*/"))
+	if end < 0 {
+		return len(src)
+	}
+	return i + 2 + end + 2
+}
+
+// skipBlock returns the offset just past the brace-delimited block that
+// follows position i.
+func skipBlock(src []byte, i int) int {
+	depth := 0
+	for i < len(src) {
+		switch c := src[i]; {
+		case c == '"':
+			i = skipString(src, i)
+			continue
+		case c == '/' && i+1 < len(src) && (src[i+1] == '/' || src[i+1] == '*'):
+			i = skipComment(src, i)
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+		i++
+	}
+	return len(src)
+}
+
+func isAlias(aliases *Object, name string) bool {
+	_, ok := aliases.Get(name)
+	return ok
+}
@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ConvertJob is one conversion of a batch.
+type ConvertJob struct {
+	Input  string
+	Output string
+}
+
+// ConvertResult is the outcome of a ConvertJob; Err is nil on success.
+type ConvertResult struct {
+	ConvertJob
+	Err error
+}
+
+// IsBatchInput reports whether an input names several files: a directory
+// or a glob pattern.
+func IsBatchInput(input string) bool {
+	if hasMeta(input) {
+		return true
+	}
+	info, err := os.Stat(input)
+	return err == nil && info.IsDir()
+}
+
+// PlanBatch expands inputs, which may be files, directories and glob
+// patterns in which ** matches any number of directories, into conversion
+// jobs. format returns the extension, without the dot, to convert a file
+// to, or "" for files directories and patterns should skip. Each output
+// mirrors the file's path below its input's base directory, the directory
+// itself or the part of a pattern before the first wildcard, under outDir,
+// or sits next to the file if outDir is "".
+//
+// No output may be one of the inputs, so a directory holding both a.json
+// and a.shon is refused rather than converted in both directions. Without
+// outDir, an output that already exists and that format would convert,
+// which makes it a source file rather than an earlier output, is refused
+// too unless overwrite is set.
+func PlanBatch(inputs []string, outDir string, overwrite bool, format func(file string) string) ([]ConvertJob, error) {
+	var jobs []ConvertJob
+	outputs := map[string]string{}
+	add := func(file, base string) error {
+		ext := format(file)
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		out := strings.TrimSuffix(rel, filepath.Ext(rel)) + "." + ext
+		if outDir != "" {
+			out = filepath.Join(outDir, out)
+		} else {
+			out = filepath.Join(base, out)
+		}
+		if prev, ok := outputs[out]; ok {
+			if prev == file {
+				return nil
+			}
+			return fmt.Errorf("%s and %s would both be written to %s", prev, file, out)
+		}
+		outputs[out] = file
+		jobs = append(jobs, ConvertJob{Input: file, Output: out})
+		return nil
+	}
+
+	for _, input := range inputs {
+		files, base, err := expandInput(input)
+		if err != nil {
+			return nil, err
+		}
+		if files == nil {
+			// A single file: convert it even if format has no target, so
+			// the conversion reports why it is unsupported.
+			if err := add(input, filepath.Dir(input)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, file := range files {
+			if format(file) == "" {
+				continue
+			}
+			if err := add(file, base); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Input < jobs[j].Input })
+
+	sources := map[string]bool{}
+	for _, job := range jobs {
+		sources[AbsPath(job.Input)] = true
+	}
+	for _, job := range jobs {
+		if sources[AbsPath(job.Output)] {
+			return nil, fmt.Errorf("%s is an input, so converting %s cannot write to it", job.Output, job.Input)
+		}
+		if outDir != "" || overwrite || format(job.Output) == "" {
+			continue
+		}
+		if _, err := os.Stat(job.Output); err == nil {
+			return nil, fmt.Errorf("converting %s would overwrite the source file %s; use -o or --overwrite", job.Input, job.Output)
+		}
+	}
+	return jobs, nil
+}
+
+// AbsPath returns path made absolute, or path itself if that fails.
+func AbsPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// expandInput lists the files a directory or pattern names along with the
+// directory outputs mirror from. It returns nil files for a plain file.
+func expandInput(input string) ([]string, string, error) {
+	if !hasMeta(input) {
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, "", err
+		}
+		if !info.IsDir() {
+			return nil, "", nil
+		}
+		files, err := walkFiles(input, func(string) bool { return true })
+		return files, input, err
+	}
+
+	pattern := filepath.ToSlash(filepath.Clean(input))
+	segs := strings.Split(pattern, "/")
+	n := 0
+	for n < len(segs) && !hasMeta(segs[n]) {
+		n++
+	}
+	base := strings.Join(segs[:n], "/")
+	if base == "" {
+		base = "."
+		if strings.HasPrefix(pattern, "/") {
+			base = "/"
+		}
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, "", fmt.Errorf("invalid pattern %q: %w", input, err)
+	}
+	files, err := walkFiles(filepath.FromSlash(base), func(rel string) bool {
+		return matchGlob(segs[n:], strings.Split(rel, "/"))
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return files, filepath.FromSlash(base), nil
+}
+
+// walkFiles lists the regular files below dir whose slash-separated path
+// relative to dir match accepts. Hidden directories are skipped.
+func walkFiles(dir string, match func(rel string) bool) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && match(filepath.ToSlash(rel)) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// matchGlob matches path segments against pattern segments, where a **
+// segment matches any number of segments.
+func matchGlob(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchGlob(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segs[0])
+	return ok && matchGlob(pattern[1:], segs[1:])
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// RunBatch runs convert for every job on at most workers goroutines, or one
+// per CPU if workers is less than one, creating output directories first,
+// and returns the results in job order.
+func RunBatch(jobs []ConvertJob, workers int, convert func(ConvertJob) error) []ConvertResult {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]ConvertResult, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				job := jobs[i]
+				err := os.MkdirAll(filepath.Dir(job.Output), 0755)
+				if err == nil {
+					err = convert(job)
+				}
+				results[i] = ConvertResult{ConvertJob: job, Err: err}
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HashPrefix starts every digest Hash returns, naming its algorithm.
+const HashPrefix = "sha256:"
+
+// Canonicalize writes a document in canonical form, which is the same for
+// any two documents that differ only in formatting, comments, key order,
+// namespace order or how numbers, decimals and timestamps are spelled:
+//
+//   - top-level metadata comes first, one "$key:value" line per key in
+//     sorted order, then one "@include" line per include in source order,
+//     then one "@name{...}" line per namespace in sorted order
+//   - objects list their $metadata and then their keys, each sorted, as
+//     {key:value,...}; arrays and tuples are written as [a,b] and T(a,b)
+//   - data keys are quoted unless they are identifiers, so a data key
+//     "$tags" never reads back as $tags metadata
+//   - there is no other whitespace and every line ends in "\n"
+//   - numbers and decimals are written with no sign for positive values, no
+//     leading or trailing zeros and no exponent unless they are very large
+//     or very small, so 1.50, +1.5 and 15e-1 are all 1.5
+//   - timestamps with a zone are converted to UTC with Z and the fewest
+//     fractional digits; those without keep their zone-less form with the
+//     fewest fractional digits
+//
+// Canonical form is valid SHON that parses to an equal document.
+func Canonicalize(doc *Document) []byte {
+	var sb strings.Builder
+	if doc.Meta != nil {
+		for _, k := range sortedKeys(doc.Meta) {
+			sb.WriteString("$" + k + ":")
+			writeCanonical(&sb, doc.Meta.Values[k])
+			sb.WriteString("\n")
+		}
+	}
+	for _, inc := range doc.Includes {
+		sb.WriteString("@include " + quote(inc) + "\n")
+	}
+	namespaces := append([]*Namespace(nil), doc.Namespaces...)
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	for _, ns := range namespaces {
+		sb.WriteString("@" + ns.Name)
+		writeCanonical(&sb, ns.Body)
+		sb.WriteString("\n")
+	}
+	return []byte(sb.String())
+}
+
+// Hash returns the SHA-256 digest of a document's canonical form, written
+// as HashPrefix followed by lowercase hex.
+func Hash(doc *Document) string {
+	sum := sha256.Sum256(Canonicalize(doc))
+	return HashPrefix + hex.EncodeToString(sum[:])
+}
+
+func writeCanonical(sb *strings.Builder, v interface{}) {
+	switch val := v.(type) {
+	case *Object:
+		sb.WriteString("{")
+		first := true
+		if val.Meta != nil {
+			for _, k := range sortedKeys(val.Meta) {
+				if !first {
+					sb.WriteString(",")
+				}
+				first = false
+				sb.WriteString("$" + k + ":")
+				writeCanonical(sb, val.Meta.Values[k])
+			}
+		}
+		for _, k := range sortedKeys(val) {
+			if !first {
+				sb.WriteString(",")
+			}
+			first = false
+			sb.WriteString(encodeKey(k) + ":")
+			writeCanonical(sb, val.Values[k])
+		}
+		sb.WriteString("}")
+	case []interface{}:
+		sb.WriteString("[")
+		writeCanonicalList(sb, val)
+		sb.WriteString("]")
+	case *Tuple:
+		name := val.Name
+		if name == "" {
+			name = "$tuple"
+		}
+		sb.WriteString(name + "(")
+		writeCanonicalList(sb, val.Items)
+		sb.WriteString(")")
+	case json.Number:
+		sb.WriteString(canonicalNumber(string(val)))
+	case Decimal:
+		sb.WriteString("$decimal(" + quote(canonicalNumber(string(val))) + ")")
+	case Timestamp:
+		sb.WriteString("$timestamp(" + quote(canonicalTimestamp(string(val))) + ")")
+	case *Env:
+		sb.WriteString("$env(" + quote(val.Name))
+		if val.HasDefault {
+			sb.WriteString(",")
+			writeCanonical(sb, val.Default)
+		}
+		sb.WriteString(")")
+	default:
+		sb.WriteString(EncodeValue(val, 0, EncodeOptions{}))
+	}
+}
+
+func writeCanonicalList(sb *strings.Builder, items []interface{}) {
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		writeCanonical(sb, item)
+	}
+}
+
+func sortedKeys(o *Object) []string {
+	keys := append([]string(nil), o.Keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+var numberPattern = regexp.MustCompile(`^([+-]?)([0-9]*)(?:\.([0-9]*))?(?:[eE]([+-]?[0-9]+))?$`)
+
+// canonicalNumber rewrites a number's text in canonical form, leaving text
+// that is not a number unchanged.
+func canonicalNumber(text string) string {
+	m := numberPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil || m[2]+m[3] == "" {
+		return text
+	}
+	exp := 0
+	if m[4] != "" {
+		var err error
+		if exp, err = strconv.Atoi(m[4]); err != nil || exp > 1e9 || exp < -1e9 {
+			return text
+		}
+	}
+	// The value is 0.digits × 10^point.
+	digits := strings.TrimLeft(m[2]+m[3], "0")
+	point := len(m[2]) + exp - (len(m[2]+m[3]) - len(digits))
+	digits = strings.TrimRight(digits, "0")
+	if digits == "" {
+		return "0"
+	}
+	sign := ""
+	if m[1] == "-" {
+		sign = "-"
+	}
+
+	switch {
+	case point > 21 || point < -6:
+		mantissa := digits[:1]
+		if len(digits) > 1 {
+			mantissa += "." + digits[1:]
+		}
+		return sign + mantissa + "e" + strconv.Itoa(point-1)
+	case point <= 0:
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		return sign + digits + strings.Repeat("0", point-len(digits))
+	default:
+		return sign + digits[:point] + "." + digits[point:]
+	}
+}
+
+// zonelessLayout is the layout of timestamps without a zone.
+const zonelessLayout = "2006-01-02T15:04:05.999999999"
+
+// canonicalTimestamp rewrites a timestamp's text in canonical form, leaving
+// text it cannot parse unchanged.
+func canonicalTimestamp(text string) string {
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	if t, err := time.Parse(zonelessLayout, text); err == nil {
+		return t.Format(zonelessLayout)
+	}
+	return text
+}
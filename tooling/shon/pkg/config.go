@@ -0,0 +1,243 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProjectConfigFile is the name of the project configuration file, searched
+// for from the working directory upward.
+const ProjectConfigFile = ".shonrc.shon"
+
+// Namespaces of a project configuration file.
+const (
+	SettingsNamespace = "settings"
+	LintNamespace     = "lint"
+)
+
+// OutputFormats are the formats ProjectConfig.OutputFormat accepts.
+var OutputFormats = []string{"json", "shon"}
+
+// ProjectConfig holds the settings of a .shonrc.shon file:
+//
+//	@settings {
+//	    indentation: 2,
+//	    sort: true,
+//	    output: "json",
+//	    schemaPaths: ["schemas"],
+//	    includeRoots: ["."]
+//	}
+//
+//	@lint {
+//	    trailingComma: "off"
+//	}
+//
+// Paths are relative to the file's directory.
+type ProjectConfig struct {
+	// Path is the file the config was read from, or "" for the defaults.
+	Path string
+	// Indentation is the number of spaces per indentation level.
+	Indentation int
+	// SortKeys sorts keys alphabetically in output.
+	SortKeys bool
+	// OutputFormat is the format SHON is converted to when no output file
+	// is given.
+	OutputFormat string
+	// SchemaPaths are directories searched for schemas that are not found
+	// relative to the document.
+	SchemaPaths []string
+	// IncludeRoots are directories includes may reach up to; a file under
+	// one may include anything below it.
+	IncludeRoots []string
+	// Lint configures lint rules as the @rules namespace of a .shonlint
+	// file does.
+	Lint *Object
+}
+
+// DefaultProjectConfig returns the settings used without a .shonrc.shon.
+func DefaultProjectConfig() *ProjectConfig {
+	return &ProjectConfig{Indentation: 4, OutputFormat: "json"}
+}
+
+// FindProjectConfig returns the nearest .shonrc.shon in dir or one of its
+// parents, or "" if there is none.
+func FindProjectConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadProjectConfig reads the .shonrc.shon file at path.
+func LoadProjectConfig(path string) (*ProjectConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseProjectConfig(data, filepath.Dir(abs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.Path = abs
+	return c, nil
+}
+
+// ParseProjectConfig parses .shonrc.shon source. Relative paths in it are
+// resolved against dir. Settings it leaves out keep their defaults.
+func ParseProjectConfig(data []byte, dir string) (*ProjectConfig, error) {
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	c := DefaultProjectConfig()
+	for _, ns := range doc.Namespaces {
+		switch ns.Name {
+		case SettingsNamespace:
+			if err := c.applySettings(ns.Body, dir); err != nil {
+				return nil, err
+			}
+		case LintNamespace:
+			c.Lint = ns.Body
+		default:
+			return nil, fmt.Errorf("unknown namespace @%s, expected @%s or @%s", ns.Name, SettingsNamespace, LintNamespace)
+		}
+	}
+	return c, nil
+}
+
+func (c *ProjectConfig) applySettings(settings *Object, dir string) error {
+	for _, k := range settings.Keys {
+		v := settings.Values[k]
+		var err error
+		switch k {
+		case "indentation":
+			n, ok := v.(json.Number)
+			c.Indentation, err = strconv.Atoi(string(n))
+			if !ok || err != nil || c.Indentation < 0 {
+				err = fmt.Errorf("expected a non-negative integer, found %v", v)
+			}
+		case "sort":
+			var ok bool
+			if c.SortKeys, ok = v.(bool); !ok {
+				err = fmt.Errorf("expected a boolean, found %s", TypeName(v))
+			}
+		case "output":
+			s, _ := v.(string)
+			err = fmt.Errorf("expected one of %s, found %v", strings.Join(OutputFormats, ", "), v)
+			for _, f := range OutputFormats {
+				if s == f {
+					c.OutputFormat, err = s, nil
+				}
+			}
+		case "schemaPaths":
+			c.SchemaPaths, err = configPaths(v, dir)
+		case "includeRoots":
+			c.IncludeRoots, err = configPaths(v, dir)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", JoinPath(SettingsNamespace, k), err)
+		}
+	}
+	return nil
+}
+
+// configPaths reads a list of paths relative to dir.
+func configPaths(v interface{}, dir string) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of paths, found %s", TypeName(v))
+	}
+	var paths []string
+	for _, item := range list {
+		p, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a path, found %s", TypeName(item))
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, filepath.FromSlash(p))
+		}
+		paths = append(paths, filepath.Clean(p))
+	}
+	return paths, nil
+}
+
+// IncludeRoot returns the include root for a file: the innermost
+// configured root containing it, or "" for the file's own directory.
+func (c *ProjectConfig) IncludeRoot(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	best := ""
+	for _, root := range c.IncludeRoots {
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+// FindSchema returns path if it exists, or else the first file of that
+// name under one of the schema search paths. It returns path unchanged if
+// neither exists, so the caller reports it as missing.
+func (c *ProjectConfig) FindSchema(path string) string {
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	for _, dir := range c.SchemaPaths {
+		candidate := filepath.Join(dir, filepath.Base(path))
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return path
+}
+
+// Document renders the config as a .shonrc.shon document.
+func (c *ProjectConfig) Document() *Document {
+	doc := NewDocument()
+	settings := NewObject()
+	settings.Set("indentation", json.Number(strconv.Itoa(c.Indentation)))
+	settings.Set("sort", c.SortKeys)
+	settings.Set("output", c.OutputFormat)
+	settings.Set("schemaPaths", stringList(c.SchemaPaths))
+	settings.Set("includeRoots", stringList(c.IncludeRoots))
+	doc.Namespaces = append(doc.Namespaces, &Namespace{Name: SettingsNamespace, Body: settings})
+	if c.Lint != nil {
+		doc.Namespaces = append(doc.Namespaces, &Namespace{Name: LintNamespace, Body: c.Lint})
+	}
+	return doc
+}
+
+func stringList(items []string) []interface{} {
+	list := []interface{}{}
+	for _, s := range items {
+		list = append(list, s)
+	}
+	return list
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConstNamespace is the reserved namespace holding document constants:
+//
+//	@const {
+//	    US_PHONE_PATTERN: "^\d{3}-\d{3}-\d{4}$",
+//	    THEME_DEFAULT: "dark"
+//	}
+//
+// Values refer to a constant with an ordinary reference, &const.THEME_DEFAULT.
+const ConstNamespace = "const"
+
+// ResolveConstants returns a copy of doc in which every &const.NAME reference
+// has been replaced with the constant's value and the @const namespace has
+// been removed. References to undefined constants are reported as errors and
+// constants that are never referenced as warnings. Constants may refer to
+// other constants.
+func ResolveConstants(doc *Document) (*Document, []Diagnostic) {
+	out, diags, _ := resolveConstants(doc, 0)
+	return out, diags
+}
+
+// ResolveConstantsWithLimits resolves constants like ResolveConstants,
+// returning a LimitError if references expand to more than
+// limits.MaxExpansion values.
+func ResolveConstantsWithLimits(doc *Document, limits Limits) (*Document, []Diagnostic, error) {
+	return resolveConstants(doc, limits.MaxExpansion)
+}
+
+// resolveConstants resolves constants like ResolveConstants, giving up with
+// a LimitError once references have expanded to more than maxExpansion
+// values, if it is not zero.
+func resolveConstants(doc *Document, maxExpansion int) (*Document, []Diagnostic, error) {
+	out := doc.Clone()
+	ns := out.Namespace(ConstNamespace)
+	consts := NewObject()
+	if ns != nil {
+		consts = ns.Body
+		out.removeNamespace(ConstNamespace)
+	}
+
+	r := &constResolver{consts: consts, used: map[string]bool{}, resolving: map[string]bool{}, expanded: &expansion{max: maxExpansion}}
+	out.Meta = Rewrite(out.Meta, "", r.replace).(*Object)
+	for _, n := range out.Namespaces {
+		n.Body = Rewrite(n.Body, n.Name, r.replace).(*Object)
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	var unused []string
+	for _, name := range consts.Keys {
+		if !r.used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		r.diags = append(r.diags, Diagnostic{
+			Severity: SeverityWarning,
+			Path:     JoinPath(ConstNamespace, name),
+			Message:  fmt.Sprintf("constant %s is never used", name),
+		})
+	}
+	return out, r.diags, nil
+}
+
+type constResolver struct {
+	consts    *Object
+	used      map[string]bool
+	resolving map[string]bool
+	diags     []Diagnostic
+	// expanded counts the values references expand to; err is set, and
+	// resolving stops, once there are too many.
+	expanded *expansion
+	err      error
+}
+
+func (r *constResolver) replace(v interface{}, path string) interface{} {
+	ref, ok := v.(Ref)
+	if !ok || !isConstRef(ref) || r.err != nil {
+		return v
+	}
+	segs, err := ParsePath(string(ref))
+	if err != nil || len(segs) < 2 || segs[1].IsIndex {
+		r.errorf(path, "invalid constant reference &%s", ref)
+		return v
+	}
+	name := segs[1].Key
+	value, ok := r.consts.Get(name)
+	if !ok {
+		r.errorf(path, "undefined constant %s", name)
+		return v
+	}
+	r.used[name] = true
+	if r.resolving[name] {
+		r.errorf(path, "constant %s refers to itself", name)
+		return v
+	}
+	r.resolving[name] = true
+	value = Rewrite(Clone(value), JoinPath(ConstNamespace, name), r.replace)
+	delete(r.resolving, name)
+
+	target, err := lookup(value, segs[2:])
+	if err != nil {
+		r.errorf(path, "&%s: %v", ref, err)
+		return v
+	}
+	if err := r.expanded.add(countValues(target)); err != nil {
+		r.err = fmt.Errorf("%s: %w", pathOrRoot(path), err)
+		return v
+	}
+	return Clone(target)
+}
+
+func (r *constResolver) errorf(path, format string, args ...interface{}) {
+	r.diags = append(r.diags, Diagnostic{Severity: SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func isConstRef(ref Ref) bool {
+	return strings.HasPrefix(string(ref), ConstNamespace+".")
+}
+
+// removeNamespace drops the named namespace, if present.
+func (d *Document) removeNamespace(name string) {
+	for i, ns := range d.Namespaces {
+		if ns.Name == name {
+			d.Namespaces = append(d.Namespaces[:i:i], d.Namespaces[i+1:]...)
+			return
+		}
+	}
+}
@@ -1,14 +1,14 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -16,7 +16,66 @@ type Record struct {
 	Fields map[string]string
 }
 
+// ConvertOptions controls how files are converted.
+type ConvertOptions struct {
+	SortKeys bool
+	// KeepMeta writes object metadata such as $tags and $type into JSON
+	// output as "$tags" and "$type" keys. By default metadata is dropped.
+	KeepMeta bool
+	// Defaults, if set, fills missing optional fields before SHON is
+	// written out as JSON.
+	Defaults DefaultFiller
+	// Schema, if set, types JSON values when converting JSON to SHON in
+	// place of guessing decimals and timestamps from string contents.
+	Schema JSONTyper
+	// TupleObjects, if set, writes named tuples whose positions are all
+	// named as JSON objects, e.g. Vec3(1, 2, 3) as {"x": 1, "y": 2, "z": 3},
+	// instead of arrays.
+	TupleObjects TupleFielder
+	// SchemaRef is the $schema written into SHON converted from JSON. It
+	// defaults to the output file name with ".shos" appended.
+	SchemaRef string
+	// IncludeRoot is the directory includes of SHON input may not leave.
+	// It defaults to the input file's directory.
+	IncludeRoot string
+	// Quiet leaves out the message printed for each file written.
+	Quiet bool
+	// RevealSecrets writes secrets into JSON output decrypted with
+	// SecretKey. By default they are written as RedactedSecret.
+	RevealSecrets bool
+	SecretKey     []byte
+	// NoInterpolation rejects SHON input holding $env or $expr values
+	// instead of interpolating them; see Interpolate.
+	NoInterpolation bool
+	// Limits bounds SHON input; set it to DefaultLimits for untrusted
+	// input. The zero value limits nothing.
+	Limits Limits
+}
+
+// written reports a file written, unless opts.Quiet is set.
+func (opts ConvertOptions) written(kind, path string) {
+	if !opts.Quiet {
+		fmt.Printf("✔ %s written to %s\n", kind, path)
+	}
+}
+
+// TupleFielder names the positions of named tuples. A compiled schema is
+// one.
+type TupleFielder interface {
+	TupleFields(name string) ([]string, bool)
+}
+
+// JSONTyper builds a typed document from a JSON value, as read by
+// ParseJSON. A compiled schema is one.
+type JSONTyper interface {
+	TypeJSON(v interface{}) (*Document, []Diagnostic)
+}
+
 func ConvertFile(inputPath, outputPath string, sortKeys bool) error {
+	return ConvertFileWithOptions(inputPath, outputPath, ConvertOptions{SortKeys: sortKeys})
+}
+
+func ConvertFileWithOptions(inputPath, outputPath string, opts ConvertOptions) error {
 	inExt := strings.ToLower(filepath.Ext(inputPath))
 	outExt := strings.ToLower(filepath.Ext(outputPath))
 
@@ -24,108 +83,205 @@ func ConvertFile(inputPath, outputPath string, sortKeys bool) error {
 	case ".json":
 		switch outExt {
 		case ".shon":
-			return JsonToShon(inputPath, outputPath, sortKeys)
+			return JsonToShonWithOptions(inputPath, outputPath, opts)
 		}
 	case ".shon":
 		switch outExt {
 		case ".json":
-			return ShonToJson(inputPath, outputPath)
+			return ShonToJsonWithOptions(inputPath, outputPath, opts)
 		}
 	case ".csv":
 		switch outExt {
 		case ".shon":
-			return CSVToShon(inputPath, outputPath)
+			return csvToShon(inputPath, outputPath, opts)
 		}
 	}
 
 	return fmt.Errorf("unsupported conversion: %s → %s", inExt, outExt)
 }
 
-// ShonToJson converts a SHON file to a valid JSON file.
+// ShonToJson converts a SHON file to a valid JSON file. Includes are resolved
+// relative to the input file and may not leave its directory, aliased
+// references are rewritten to their namespaces, and constant references are
+// replaced with their values.
 func ShonToJson(inputPath, outputPath string) error {
-	// Read the SHON file
-	data, err := os.ReadFile(inputPath)
+	return ShonToJsonWithOptions(inputPath, outputPath, ConvertOptions{})
+}
+
+func ShonToJsonWithOptions(inputPath, outputPath string, opts ConvertOptions) error {
+	doc, warnings, err := LoadResolvedWithLimits(inputPath, opts.IncludeRoot, opts.Limits)
 	if err != nil {
-		return fmt.Errorf("failed to read SHON file: %w", err)
+		return err
 	}
-	content := string(data)
-	fmt.Println("🧾 Raw SHON input:\n-----------------\n" + content)
-
-	// Step 1: Remove the $schema line
-	content = regexp.MustCompile(`(?m)^\$schema:.*\n?`).ReplaceAllString(content, "")
-
-	// Step 2: Extract content inside the first @namespace { ... } block
-	nsMatch := regexp.MustCompile(`@[\w]+\s*{([\s\S]*)}`).FindStringSubmatch(content)
-	if len(nsMatch) < 2 {
-		return fmt.Errorf("failed to extract SHON body from namespace block")
+	printDiagnostics(warnings)
+	if opts.NoInterpolation {
+		err = rejectInterpolation(doc)
+	} else {
+		doc, err = Interpolate(doc, InterpolateOptions{Limits: opts.Limits})
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Defaults != nil {
+		doc = opts.Defaults.FillDefaults(doc)
+	}
+	if opts.RevealSecrets {
+		if opts.SecretKey == nil {
+			return fmt.Errorf("revealing secrets needs a key")
+		}
+		if doc, err = DecryptSecrets(doc, opts.SecretKey); err != nil {
+			return err
+		}
 	}
-	body := strings.TrimSpace(nsMatch[1])
-
-	// Step 3: Wrap the extracted content in braces to form a JSON-like structure
-	wrapped := fmt.Sprintf("{%s}", body)
-	fmt.Println("\n🧪 Cleaned & wrapped SHON:\n-----------------\n" + wrapped)
-
-	// Step 4: Flatten the string (remove newlines and tabs)
-	flat := strings.ReplaceAll(wrapped, "\n", "")
-	flat = strings.ReplaceAll(flat, "\t", "")
-	flat = strings.ReplaceAll(flat, "  ", "")
-	wrapped = flat
-
-	// Step 5: Replace $timestamp("...") with the inner string value.
-	// reTimestamp := regexp.MustCompile(`\$timestamp$begin:math:text$"([^"]+)"$end:math:text$`)
-	// wrapped = reTimestamp.ReplaceAllString(wrapped, `"$1"`)
 
-	// Replace $decimal("...") with the inner string value.
-	// reDecimal := regexp.MustCompile(`\$decimal$begin:math:text$"([^"]+)"$end:math:text$`)
-	// wrapped = reDecimal.ReplaceAllString(wrapped, `"$1"`)
+	out, err := MarshalJSON(DocumentToJSON(doc, opts), "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
 
-	wrapped = strings.ReplaceAll(wrapped, "$timestamp(", "")
-	wrapped = strings.ReplaceAll(wrapped, "$decimal(", "")
-	wrapped = strings.ReplaceAll(wrapped, ")", "")
+	if err := os.WriteFile(outputPath, out, 0644); err != nil {
+		return fmt.Errorf("failed to write JSON file: %w", err)
+	}
 
-	reTypes := regexp.MustCompile(`\$(?:timestamp|decimal)$begin:math:text$"((?:[^"\\\\]|\\\\.)*)"$end:math:text$`)
-	wrapped = reTypes.ReplaceAllString(wrapped, `"$1"`)
-	/*
-		wrapped = strings.ReplaceAll(wrapped, "$timestamp(", "")
-		wrapped = strings.ReplaceAll(wrapped, "$decimal(", "")
-		wrapped = strings.ReplaceAll(wrapped, ")", "")
-	*/
-	fmt.Println("\n🔍 After $type cleanup:\n-----------------\n" + wrapped)
+	opts.written("JSON file", filepath.Base(outputPath))
+	return nil
+}
 
-	// Fail fast if any $timestamp or $decimal expressions remain.
-	if strings.Contains(wrapped, "$timestamp") || strings.Contains(wrapped, "$decimal") {
-		return fmt.Errorf("🔥 $type expressions still present after cleanup")
+// MarshalJSON encodes v as indented JSON without HTML escaping.
+func MarshalJSON(v interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
 	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
 
-	// Step 6: Quote unquoted keys using a multiline regex.
-	// This regex matches any '{' or ',' followed by optional whitespace and a key, then a colon.
-	reKey := regexp.MustCompile(`([{,])\s*([a-zA-Z_][a-zA-Z0-9_]*):`)
-	wrapped = reKey.ReplaceAllString(wrapped, `$1"$2":`)
-	// Also, ensure the very first key is quoted.
-	wrapped = regexp.MustCompile(`^{\s*([a-zA-Z_][a-zA-Z0-9_]*):`).ReplaceAllString(wrapped, `{"$1":`)
-	fmt.Println("\n🔧 Final JSON-like string:\n-----------------\n" + wrapped)
-
-	// Step 7: Parse the final string as JSON.
-	var parsed interface{}
-	if err := json.Unmarshal([]byte(wrapped), &parsed); err != nil {
-		return fmt.Errorf("invalid SHON structure after conversion: %w", err)
+// ParseJSON decodes JSON into SHON values, keeping object key order: objects
+// become *Object and numbers json.Number. Keys starting with '$' are kept as
+// ordinary keys rather than metadata.
+func ParseJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, err
 	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
 
-	// Step 8: Marshal the parsed object as pretty JSON and write it to the output file.
-	out, err := json.MarshalIndent(parsed, "", "  ")
+func parseJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
 	if err != nil {
-		return fmt.Errorf("failed to encode JSON: %w", err)
+		return nil, err
 	}
+	switch tok {
+	case json.Delim('{'):
+		obj := NewObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(key.(string), v)
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for dec.More() {
+			v, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		_, err := dec.Token()
+		return items, err
+	}
+	return tok, nil
+}
 
-	if err := os.WriteFile(outputPath, out, 0644); err != nil {
-		return fmt.Errorf("failed to write JSON file: %w", err)
+// DocumentToJSON converts a document into values encoding/json can marshal.
+// A single namespace becomes the top-level object; several namespaces are
+// keyed by name.
+func DocumentToJSON(doc *Document, opts ConvertOptions) interface{} {
+	if len(doc.Namespaces) == 1 {
+		return ToJSON(doc.Namespaces[0].Body, opts)
+	}
+	out := NewObject()
+	for _, ns := range doc.Namespaces {
+		out.Set(ns.Name, ToJSON(ns.Body, opts))
 	}
+	return out
+}
 
-	fmt.Printf("✔ JSON file written to %s\n", filepath.Base(outputPath))
-	return nil
+// ToJSON converts a SHON value into its JSON form. Decimals and timestamps
+// become strings, tuples become arrays (or objects, see TupleObjects),
+// references become "&path" strings and secrets are redacted. Objects keep
+// their key order when marshalled.
+func ToJSON(v interface{}, opts ConvertOptions) interface{} {
+	switch val := v.(type) {
+	case *Object:
+		out := NewObject()
+		if opts.KeepMeta && val.Meta != nil {
+			for _, k := range val.Meta.Keys {
+				out.Set("$"+k, ToJSON(val.Meta.Values[k], opts))
+			}
+		}
+		for _, k := range val.Keys {
+			out.Set(k, ToJSON(val.Values[k], opts))
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = ToJSON(item, opts)
+		}
+		return out
+	case *Tuple:
+		if opts.TupleObjects != nil && val.Name != "" {
+			if fields, ok := opts.TupleObjects.TupleFields(val.Name); ok && len(fields) == len(val.Items) {
+				out := NewObject()
+				for i, f := range fields {
+					out.Set(f, ToJSON(val.Items[i], opts))
+				}
+				return out
+			}
+		}
+		return ToJSON(val.Items, opts)
+	case Decimal:
+		return string(val)
+	case Timestamp:
+		return string(val)
+	case Secret:
+		return RedactedSecret
+	case *Env, *Expr:
+		return EncodeValue(val, 0, EncodeOptions{})
+	case Ref:
+		return "&" + string(val)
+	default:
+		return v
+	}
 }
 
+// CSVToShon converts a CSV file with a header row to SHON. The rows become
+// the records array of a namespace named after the file, and each column
+// that repeats a value becomes a lookup namespace the records reference.
 func CSVToShon(inputFile, outputFile string) error {
+	return csvToShon(inputFile, outputFile, ConvertOptions{})
+}
+
+func csvToShon(inputFile, outputFile string, opts ConvertOptions) error {
 	if inputFile == "" {
 		return fmt.Errorf("no input file specified")
 	}
@@ -146,87 +302,143 @@ func CSVToShon(inputFile, outputFile string) error {
 		return fmt.Errorf("CSV must have a header and at least one data row")
 	}
 
+	// Columns that repeat a value become lookup namespaces the records
+	// reference, with IDs numbered in order of first appearance.
 	header := rows[0]
-	var records []Record
-	occurrence := make(map[string]map[string]int)
 	refs := make(map[string]map[string]string)
-	refColumns := make(map[string]bool)
-
-	// Count occurrences per column
-	for _, field := range header {
-		occurrence[field] = make(map[string]int)
-	}
-	for _, row := range rows[1:] {
-		for i, val := range row {
-			key := header[i]
-			occurrence[key][val]++
-		}
-	}
-
-	// Decide if the column should use refs
-	for key, values := range occurrence {
-		if len(values) < len(rows)-1 {
-			refColumns[key] = true
-			refs[key] = make(map[string]string)
-			for val := range values {
-				refID := fmt.Sprintf("%s_%d", strings.ToLower(key), len(refs[key])+1)
-				refs[key][val] = refID
+	var lookups []*Namespace
+	for i, key := range header {
+		seen := make(map[string]bool)
+		var values []string
+		for _, row := range rows[1:] {
+			if !seen[row[i]] {
+				seen[row[i]] = true
+				values = append(values, row[i])
 			}
 		}
+		if len(values) == len(rows)-1 {
+			continue
+		}
+		name := identifier(strings.ToLower(key))
+		table := NewObject()
+		refs[key] = make(map[string]string)
+		for _, val := range values {
+			refID := fmt.Sprintf("%s_%d", name, table.Len()+1)
+			refs[key][val] = refID
+			table.Set(refID, val)
+		}
+		lookups = append(lookups, &Namespace{Name: name, Body: table})
 	}
 
-	// Build SHON records
+	var records []interface{}
 	for _, row := range rows[1:] {
-		rec := Record{Fields: make(map[string]string)}
+		rec := NewObject()
 		for i, val := range row {
 			key := header[i]
-			if refColumns[key] {
-				refID := refs[key][val]
-				rec.Fields[key] = "&" + strings.ToLower(key) + "." + refID
+			if ids, ok := refs[key]; ok {
+				rec.Set(key, Ref(identifier(strings.ToLower(key))+"."+ids[val]))
 			} else {
-				rec.Fields[key] = fmt.Sprintf("\"%s\"", val)
+				rec.Set(key, val)
 			}
 		}
 		records = append(records, rec)
 	}
 
-	// Generate SHON
-	var builder strings.Builder
-	builder.WriteString(fmt.Sprintf("$schema: \"%s.shos\"\n\n", outputFile))
-	// builder.WriteString(fmt.Sprintf("@%s {\n", namespace))
-	builder.WriteString("    records: [\n")
-	for _, rec := range records {
-		builder.WriteString("        {\n")
-		for k, v := range rec.Fields {
-			builder.WriteString(fmt.Sprintf("            %s: %s,\n", k, v))
+	// The records live in a namespace named after the CSV file.
+	name := identifier(strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)))
+	for _, ns := range lookups {
+		if ns.Name == name {
+			name += "_records"
 		}
-		builder.WriteString("        },\n")
 	}
-	builder.WriteString("    ],\n")
+	body := NewObject()
+	body.Set("records", records)
+	doc := NewDocument()
+	doc.Meta.Set("schema", DefaultSchemaRef(outputFile))
+	doc.Namespaces = append([]*Namespace{{Name: name, Body: body}}, lookups...)
+
+	if err := WriteFile(doc, outputFile, EncodeOptions{SortKeys: opts.SortKeys}); err != nil {
+		return err
+	}
+	if outputFile != "" {
+		opts.written("SHON", outputFile)
+	}
+	return nil
+}
 
-	// Add @refs blocks
-	for key, values := range refs {
-		refName := strings.ToLower(key)
-		builder.WriteString(fmt.Sprintf("    @%s {\n", refName))
-		for val, refID := range values {
-			builder.WriteString(fmt.Sprintf("        %s: \"%s\",\n", refID, val))
+// identifier turns text such as a CSV column name into a namespace name,
+// replacing the characters a name may not contain with underscores.
+func identifier(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !isIdentChar(c) {
+			b[i] = '_'
 		}
-		builder.WriteString("    },\n")
 	}
+	if len(b) == 0 || isDigit(b[0]) {
+		return "_" + string(b)
+	}
+	return string(b)
+}
 
-	builder.WriteString("}\n")
+func JsonToShon(inputFile, outputFile string, sortKeys bool) error {
+	return JsonToShonWithOptions(inputFile, outputFile, ConvertOptions{SortKeys: sortKeys})
+}
 
-	// Write output
-	shonPath := outputFile
-	err = os.WriteFile(shonPath, []byte(builder.String()), 0644)
+// DefaultSchemaRef is the $schema JsonToShon writes when none is given:
+// the output file name with ".shos" appended.
+func DefaultSchemaRef(outputFile string) string {
+	return filepath.Base(outputFile) + ".shos"
+}
+
+// JsonToShonWithOptions converts a JSON file to SHON. With opts.Schema set,
+// every value is typed from the schema and values that do not fit it are
+// reported as errors; otherwise decimals and timestamps are guessed from
+// the contents of strings.
+func JsonToShonWithOptions(inputFile, outputFile string, opts ConvertOptions) error {
+	if opts.Schema == nil {
+		return jsonToShonGuessed(inputFile, outputFile, opts)
+	}
+	if inputFile == "" {
+		return fmt.Errorf("no input file specified")
+	}
+	data, err := os.ReadFile(inputFile)
 	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	input, err := ParseJSON(data)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	doc, diags := opts.Schema.TypeJSON(input)
+	if err := DiagnosticsError(diags); err != nil {
+		return err
+	}
+	printDiagnostics(diags)
+	doc.Meta.Set("schema", schemaRef(outputFile, opts))
+
+	out := Encode(doc, EncodeOptions{SortKeys: opts.SortKeys})
+	if outputFile == "" {
+		fmt.Print(out)
+		return nil
+	}
+	if err := os.WriteFile(outputFile, []byte(out), 0644); err != nil {
 		return fmt.Errorf("failed to write SHON file: %v", err)
 	}
-	fmt.Printf("✔ SHON written to %s\n", shonPath)
+	opts.written("SHON file", outputFile)
 	return nil
 }
 
-func JsonToShon(inputFile, outputFile string, sortKeys bool) error {
+func schemaRef(outputFile string, opts ConvertOptions) string {
+	if opts.SchemaRef != "" {
+		return opts.SchemaRef
+	}
+	return DefaultSchemaRef(outputFile)
+}
+
+func jsonToShonGuessed(inputFile, outputFile string, opts ConvertOptions) error {
+	sortKeys := opts.SortKeys
 	if inputFile == "" {
 		return fmt.Errorf("no input file specified")
 	}
@@ -238,12 +450,14 @@ func JsonToShon(inputFile, outputFile string, sortKeys bool) error {
 
 	var input interface{}
 	if err := json.Unmarshal(data, &input); err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid JSON: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, ok := input.(map[string]interface{}); !ok {
+		return fmt.Errorf("JSON input must be an object to become a SHON namespace")
 	}
 
 	shonBody := convertToShon(input, 1, sortKeys)
-	shon := fmt.Sprintf("$schema: \"%s.shos\"\n\n@data %s", filepath.Base(outputFile), shonBody)
+	shon := fmt.Sprintf("$schema: %s\n\n@data %s", quote(schemaRef(outputFile, opts)), shonBody)
 
 	if outputFile == "" {
 		fmt.Println(shon)
@@ -251,7 +465,7 @@ func JsonToShon(inputFile, outputFile string, sortKeys bool) error {
 		if err := os.WriteFile(outputFile, []byte(shon), 0644); err != nil {
 			return fmt.Errorf("failed to write SHON file: %v", err)
 		}
-		fmt.Printf("✔ SHON file written to %s\n", outputFile)
+		opts.written("SHON file", outputFile)
 	}
 
 	return nil
@@ -273,7 +487,7 @@ func convertToShon(v interface{}, indent int, sortKeys bool) string {
 		var sb strings.Builder
 		sb.WriteString("{")
 		for i, k := range keys {
-			sb.WriteString(fmt.Sprintf("\n%s%s: %s", ind, k, convertToShon(val[k], indent+1, sortKeys)))
+			sb.WriteString(fmt.Sprintf("\n%s%s: %s", ind, encodeKey(k), convertToShon(val[k], indent+1, sortKeys)))
 			if i < len(keys)-1 {
 				sb.WriteString(",")
 			}
@@ -294,13 +508,13 @@ func convertToShon(v interface{}, indent int, sortKeys bool) string {
 		return sb.String()
 
 	case string:
-		if _, err := strconv.ParseFloat(val, 64); err == nil && strings.Contains(val, ".") {
-			return fmt.Sprintf("$decimal(\"%s\")", val)
+		if decimalPattern.MatchString(val) && strings.Contains(val, ".") {
+			return fmt.Sprintf("$decimal(%s)", quote(val))
 		}
 		if strings.Contains(val, "T") && strings.Contains(val, ":") {
-			return fmt.Sprintf("$timestamp(\"%s\")", val)
+			return fmt.Sprintf("$timestamp(%s)", quote(val))
 		}
-		return fmt.Sprintf("\"%s\"", val)
+		return quote(val)
 
 	case float64:
 		if float64(int(val)) == val {
@@ -0,0 +1,380 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFiller fills missing optional fields of a document with default
+// values. A compiled schema is one.
+type DefaultFiller interface {
+	FillDefaults(doc *Document) *Document
+}
+
+// DecodeOptions controls how documents are decoded into Go values.
+type DecodeOptions struct {
+	// Defaults, if set, fills missing optional fields before decoding.
+	Defaults DefaultFiller
+	// SecretKey, if set, decrypts secrets so they decode like strings.
+	// Without it, secrets only decode into Secret.
+	SecretKey []byte
+	// NoInterpolation rejects documents holding $env or $expr values
+	// instead of interpolating them. Set it for untrusted input.
+	NoInterpolation bool
+	// LookupEnv looks up the variables $env values name. It defaults to
+	// os.LookupEnv.
+	LookupEnv func(name string) (string, bool)
+	// Limits bounds the source UnmarshalWithOptions parses and the work
+	// resolving and interpolating it does. Set it to DefaultLimits for
+	// untrusted input; the zero value limits nothing.
+	Limits Limits
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	decimalType   = reflect.TypeOf(Decimal(""))
+	timestampType = reflect.TypeOf(Timestamp(""))
+	secretType    = reflect.TypeOf(Secret(""))
+	refType       = reflect.TypeOf(Ref(""))
+	numberType    = reflect.TypeOf(json.Number(""))
+)
+
+// Unmarshal parses SHON source, resolves its aliases and constants and
+// decodes it into v, which must be a non-nil pointer. Includes are not
+// resolved; use LoadResolved and Decode for files that include others.
+func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalWithOptions(data, v, DecodeOptions{})
+}
+
+func UnmarshalWithOptions(data []byte, v interface{}, opts DecodeOptions) error {
+	doc, err := ParseWithLimits(data, opts.Limits)
+	if err != nil {
+		return err
+	}
+	doc, _, err = ResolveWithLimits(doc, opts.Limits)
+	if err != nil {
+		return err
+	}
+	return DecodeWithOptions(doc, v, opts)
+}
+
+// Decode stores a document in v, which must be a non-nil pointer. As with
+// JSON conversion, a single namespace decodes as its body and several
+// namespaces decode as an object keyed by name.
+//
+// Struct fields are matched by a `shon` tag, then a `json` tag, then by
+// name ignoring case. Decimals decode into strings, numbers or Decimal;
+// timestamps into strings, time.Time or Timestamp; secrets into Secret, or
+// with DecodeOptions.SecretKey into strings; tuples into slices, arrays
+// and, by position, structs. $env and $expr values are interpolated first.
+//
+// An empty interface receives what encoding/json would give for the
+// value's JSON form, as from ToJSON: objects are map[string]interface{},
+// arrays and tuples []interface{} and numbers float64, while decimals,
+// timestamps and "&path" references are strings and secrets not decrypted
+// with a key are redacted.
+func Decode(doc *Document, v interface{}) error {
+	return DecodeWithOptions(doc, v, DecodeOptions{})
+}
+
+func DecodeWithOptions(doc *Document, v interface{}, opts DecodeOptions) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", v)
+	}
+	var err error
+	if opts.NoInterpolation {
+		err = rejectInterpolation(doc)
+	} else {
+		doc, err = Interpolate(doc, InterpolateOptions{LookupEnv: opts.LookupEnv, Limits: opts.Limits})
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Defaults != nil {
+		doc = opts.Defaults.FillDefaults(doc)
+	}
+	if opts.SecretKey != nil {
+		if doc, err = DecryptSecrets(doc, opts.SecretKey); err != nil {
+			return err
+		}
+	}
+	var root interface{} = doc.root()
+	if len(doc.Namespaces) == 1 {
+		root = doc.Namespaces[0].Body
+	}
+	return decodeValue(root, rv.Elem(), "")
+}
+
+// plainValue converts a value from ToJSON to the types encoding/json
+// decodes JSON into.
+func plainValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case *Object:
+		out := make(map[string]interface{}, val.Len())
+		for _, k := range val.Keys {
+			out[k] = plainValue(val.Values[k])
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = plainValue(item)
+		}
+		return out
+	case json.Number:
+		f, _ := strconv.ParseFloat(string(val), 64)
+		return f
+	}
+	return v
+}
+
+func decodeValue(v interface{}, rv reflect.Value, path string) error {
+	if v == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(v, rv.Elem(), path)
+	}
+	if rv.Kind() == reflect.Interface && rv.NumMethod() == 0 {
+		if plain := plainValue(ToJSON(v, ConvertOptions{})); plain != nil {
+			rv.Set(reflect.ValueOf(plain))
+		} else {
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+
+	if s, ok := v.(Secret); ok {
+		if rv.Type() != secretType {
+			return fmt.Errorf("%s: cannot decode a secret without a key into %s", pathOrRoot(path), rv.Type())
+		}
+		rv.SetString(string(s))
+		return nil
+	}
+
+	switch rv.Type() {
+	case timeType:
+		s, ok := scalarText(v)
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("%s: invalid timestamp %q", pathOrRoot(path), s)
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	case decimalType, timestampType, refType, numberType:
+		s, ok := scalarText(v)
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		rv.SetString(s)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		s, ok := scalarText(v)
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		rv.SetString(s)
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(numberText(v), 10, rv.Type().Bits())
+		if err != nil {
+			return decodeError(v, rv, path)
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(numberText(v), 10, rv.Type().Bits())
+		if err != nil {
+			return decodeError(v, rv, path)
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		r, ok := new(big.Rat).SetString(numberText(v))
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		f, _ := r.Float64()
+		rv.SetFloat(f)
+	case reflect.Slice:
+		items, ok := listItems(v)
+		if !ok {
+			return decodeError(v, rv, path)
+		}
+		out := reflect.MakeSlice(rv.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, out.Index(i), IndexPath(path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(out)
+	case reflect.Array:
+		items, ok := listItems(v)
+		if !ok || len(items) != rv.Len() {
+			return decodeError(v, rv, path)
+		}
+		for i, item := range items {
+			if err := decodeValue(item, rv.Index(i), IndexPath(path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		obj, ok := v.(*Object)
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			return decodeError(v, rv, path)
+		}
+		out := reflect.MakeMapWithSize(rv.Type(), obj.Len())
+		for _, k := range obj.Keys {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err := decodeValue(obj.Values[k], elem, JoinPath(path, k)); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), elem)
+		}
+		rv.Set(out)
+	case reflect.Struct:
+		switch val := v.(type) {
+		case *Object:
+			return decodeStruct(val, rv, path)
+		case *Tuple:
+			return decodeTuple(val, rv, path)
+		}
+		return decodeError(v, rv, path)
+	default:
+		return decodeError(v, rv, path)
+	}
+	return nil
+}
+
+func decodeStruct(obj *Object, rv reflect.Value, path string) error {
+	t := rv.Type()
+	for _, k := range obj.Keys {
+		i, ok := fieldFor(t, k)
+		if !ok {
+			continue
+		}
+		if err := decodeValue(obj.Values[k], rv.Field(i), JoinPath(path, k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTuple stores tuple items in the exported fields of a struct, in
+// field order.
+func decodeTuple(t *Tuple, rv reflect.Value, path string) error {
+	var fields []int
+	for i := 0; i < rv.NumField(); i++ {
+		if f := rv.Type().Field(i); f.PkgPath == "" && tagName(f) != "-" {
+			fields = append(fields, i)
+		}
+	}
+	if len(fields) != len(t.Items) {
+		return fmt.Errorf("%s: cannot decode %d tuple items into %s with %d fields", pathOrRoot(path), len(t.Items), rv.Type(), len(fields))
+	}
+	for i, item := range t.Items {
+		if err := decodeValue(item, rv.Field(fields[i]), IndexPath(path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldFor finds the exported struct field a key decodes into.
+func fieldFor(t reflect.Type, key string) (int, bool) {
+	fallback := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := tagName(f)
+		if name == "-" {
+			continue
+		}
+		if name == key {
+			return i, true
+		}
+		if name == "" && fallback < 0 && strings.EqualFold(f.Name, key) {
+			fallback = i
+		}
+	}
+	return fallback, fallback >= 0
+}
+
+func tagName(f reflect.StructField) string {
+	for _, tag := range []string{"shon", "json"} {
+		if v, ok := f.Tag.Lookup(tag); ok {
+			name, _, _ := strings.Cut(v, ",")
+			return name
+		}
+	}
+	return ""
+}
+
+// scalarText returns the text of a string-like value.
+func scalarText(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case string:
+		return val, true
+	case Decimal:
+		return string(val), true
+	case Timestamp:
+		return string(val), true
+	case Ref:
+		return string(val), true
+	case json.Number:
+		return string(val), true
+	}
+	return "", false
+}
+
+func numberText(v interface{}) string {
+	switch val := v.(type) {
+	case json.Number:
+		return string(val)
+	case Decimal:
+		return string(val)
+	}
+	return ""
+}
+
+func listItems(v interface{}) ([]interface{}, bool) {
+	switch val := v.(type) {
+	case []interface{}:
+		return val, true
+	case *Tuple:
+		return val.Items, true
+	}
+	return nil, false
+}
+
+func decodeError(v interface{}, rv reflect.Value, path string) error {
+	return fmt.Errorf("%s: cannot decode %s into %s", pathOrRoot(path), TypeName(v), rv.Type())
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Severity classifies a Diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic is a problem found in a document. Path is the SHON path of the
+// offending value, e.g. "user.location.city".
+type Diagnostic struct {
+	Severity Severity
+	Path     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Path == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Path, d.Message)
+}
+
+// HasErrors reports whether any diagnostic is an error.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// DiagnosticsError combines the error diagnostics into a single error, or
+// returns nil if there are none.
+func DiagnosticsError(diags []Diagnostic) error {
+	var errs []string
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			errs = append(errs, d.String())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// printDiagnostics writes diagnostics to stderr.
+func printDiagnostics(diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d.String())
+	}
+}
@@ -0,0 +1,294 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+)
+
+// Document is a parsed SHON file: top-level metadata such as $schema, any
+// @include directives, and one or more @namespace blocks.
+type Document struct {
+	Meta       *Object
+	Includes   []string
+	Namespaces []*Namespace
+}
+
+// Namespace is a named top-level block, e.g. @user { ... }.
+type Namespace struct {
+	Name string
+	Body *Object
+}
+
+// Object is an ordered set of key/value pairs. Values are one of *Object,
+// []interface{}, string, json.Number, bool, nil, Decimal, Timestamp, Secret,
+// Ref, *Tuple, *Env or *Expr. Keys written with a leading '$', such as $tags and $type, are
+// metadata rather than data and live in Meta, stored without the '$'.
+type Object struct {
+	Keys   []string
+	Values map[string]interface{}
+	Meta   *Object
+}
+
+// Decimal is a $decimal("...") value, kept as its literal text.
+type Decimal string
+
+// Timestamp is a $timestamp("...") value, kept as its literal text.
+type Timestamp string
+
+// Secret is a $secret("...") value: base64 AES-GCM ciphertext, kept as
+// written. See EncryptSecret.
+type Secret string
+
+// Ref is a &namespace.path reference, stored without the leading '&'.
+type Ref string
+
+// Env is a $env("NAME") or $env("NAME", default) value, replaced by the
+// environment variable NAME when the document is interpolated.
+type Env struct {
+	Name       string
+	Default    interface{}
+	HasDefault bool
+}
+
+// Expr is a $expr("...") value: an expression over numbers, strings,
+// booleans and references, evaluated when the document is interpolated.
+// It is kept parsed, so references inside it are resolved like any other.
+// Op is "+", "-", "*", "/", "neg" or, for a single operand, "".
+type Expr struct {
+	Op   string
+	Args []interface{}
+}
+
+// Tuple is an anonymous $tuple(...) or a named tuple such as Vec3(...).
+// Name is empty for anonymous tuples.
+type Tuple struct {
+	Name  string
+	Items []interface{}
+}
+
+func NewDocument() *Document {
+	return &Document{Meta: NewObject()}
+}
+
+func NewObject() *Object {
+	return &Object{Values: make(map[string]interface{})}
+}
+
+// Schema returns the value of the top-level $schema field, if any.
+func (d *Document) Schema() string {
+	if s, ok := d.Meta.Values["schema"].(string); ok {
+		return s
+	}
+	return ""
+}
+
+// Namespace returns the namespace with the given name, or nil.
+func (d *Document) Namespace(name string) *Namespace {
+	for _, ns := range d.Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored under key.
+func (o *Object) Get(key string) (interface{}, bool) {
+	v, ok := o.Values[key]
+	return v, ok
+}
+
+// Set stores value under key, appending the key if it is new.
+func (o *Object) Set(key string, value interface{}) {
+	if _, ok := o.Values[key]; !ok {
+		o.Keys = append(o.Keys, key)
+	}
+	o.Values[key] = value
+}
+
+// Delete removes key, reporting whether it was present.
+func (o *Object) Delete(key string) bool {
+	if _, ok := o.Values[key]; !ok {
+		return false
+	}
+	delete(o.Values, key)
+	for i, k := range o.Keys {
+		if k == key {
+			o.Keys = append(o.Keys[:i:i], o.Keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// GetMeta returns the metadata value stored under key, e.g. "tags".
+func (o *Object) GetMeta(key string) (interface{}, bool) {
+	if o.Meta == nil {
+		return nil, false
+	}
+	return o.Meta.Get(key)
+}
+
+// SetMeta stores a metadata value under key, e.g. "tags".
+func (o *Object) SetMeta(key string, value interface{}) {
+	if o.Meta == nil {
+		o.Meta = NewObject()
+	}
+	o.Meta.Set(key, value)
+}
+
+// Tags returns the object's $tags metadata.
+func (o *Object) Tags() []string {
+	raw, _ := o.GetMeta("tags")
+	items, _ := raw.([]interface{})
+	var tags []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			tags = append(tags, s)
+		}
+	}
+	return tags
+}
+
+// Len returns the number of keys in the object.
+func (o *Object) Len() int {
+	return len(o.Keys)
+}
+
+// MarshalJSON writes the object as a JSON object, preserving key order.
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSON(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := marshalJSON(o.Values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshalJSON is json.Marshal without HTML escaping, so references such as
+// "&people.sean" stay readable.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Clone returns a deep copy of the document.
+func (d *Document) Clone() *Document {
+	out := &Document{Meta: Clone(d.Meta).(*Object), Includes: append([]string(nil), d.Includes...)}
+	for _, ns := range d.Namespaces {
+		out.Namespaces = append(out.Namespaces, &Namespace{Name: ns.Name, Body: Clone(ns.Body).(*Object)})
+	}
+	return out
+}
+
+// Clone returns a deep copy of a SHON value.
+func Clone(v interface{}) interface{} {
+	switch val := v.(type) {
+	case *Object:
+		out := NewObject()
+		for _, k := range val.Keys {
+			out.Set(k, Clone(val.Values[k]))
+		}
+		if val.Meta != nil {
+			out.Meta = Clone(val.Meta).(*Object)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = Clone(item)
+		}
+		return out
+	case *Tuple:
+		return &Tuple{Name: val.Name, Items: Clone(val.Items).([]interface{})}
+	case *Env:
+		return &Env{Name: val.Name, Default: Clone(val.Default), HasDefault: val.HasDefault}
+	case *Expr:
+		return &Expr{Op: val.Op, Args: Clone(val.Args).([]interface{})}
+	default:
+		return v
+	}
+}
+
+// Equal reports whether two SHON values are semantically equal. Key order is
+// ignored and numbers and decimals are compared by value.
+func Equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case *Object:
+		bv, ok := b.(*Object)
+		if !ok || av.Len() != bv.Len() || !metaEqual(av.Meta, bv.Meta) {
+			return false
+		}
+		for _, k := range av.Keys {
+			other, ok := bv.Values[k]
+			if !ok || !Equal(av.Values[k], other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !Equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case *Tuple:
+		bv, ok := b.(*Tuple)
+		return ok && av.Name == bv.Name && Equal(av.Items, bv.Items)
+	case *Env:
+		bv, ok := b.(*Env)
+		return ok && av.Name == bv.Name && av.HasDefault == bv.HasDefault && Equal(av.Default, bv.Default)
+	case *Expr:
+		bv, ok := b.(*Expr)
+		return ok && av.Op == bv.Op && Equal(av.Args, bv.Args)
+	case json.Number:
+		bv, ok := b.(json.Number)
+		return ok && numericEqual(string(av), string(bv))
+	case Decimal:
+		bv, ok := b.(Decimal)
+		return ok && numericEqual(string(av), string(bv))
+	default:
+		return a == b
+	}
+}
+
+func metaEqual(a, b *Object) bool {
+	if a == nil || b == nil {
+		return (a == nil || a.Len() == 0) && (b == nil || b.Len() == 0)
+	}
+	return Equal(a, b)
+}
+
+func numericEqual(a, b string) bool {
+	ar, aok := new(big.Rat).SetString(a)
+	br, bok := new(big.Rat).SetString(b)
+	if !aok || !bok {
+		return a == b
+	}
+	return ar.Cmp(br) == 0
+}
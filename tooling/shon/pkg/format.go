@@ -0,0 +1,56 @@
+package pkg
+
+import "strings"
+
+// FormatSource re-indents SHON source line by line, indent spaces per
+// level of brackets, keeping comments and blank lines. With minify set,
+// lines are joined and blank lines and block comments dropped.
+func FormatSource(data []byte, indent int, minify bool) string {
+	lines := strings.Split(string(data), "\n")
+	var out strings.Builder
+	level := 0
+	inMultilineComment := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "/*") {
+			inMultilineComment = true
+		}
+
+		if inMultilineComment {
+			if !minify {
+				out.WriteString(IndentLine(level, indent, trimmed) + "\n")
+			}
+			if strings.Contains(trimmed, "*/") {
+				inMultilineComment = false
+			}
+			continue
+		}
+
+		if trimmed == "" {
+			if !minify {
+				out.WriteString("\n")
+			}
+			continue
+		}
+
+		openBraces := strings.Count(trimmed, "{") + strings.Count(trimmed, "[")
+		closeBraces := strings.Count(trimmed, "}") + strings.Count(trimmed, "]")
+
+		if closeBraces > openBraces && level > 0 {
+			level--
+		}
+
+		if minify {
+			out.WriteString(strings.TrimSpace(trimmed))
+		} else {
+			out.WriteString(IndentLine(level, indent, trimmed) + "\n")
+		}
+
+		if openBraces > closeBraces {
+			level++
+		}
+	}
+	return out.String()
+}
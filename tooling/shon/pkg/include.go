@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrIncludeCycle is returned when a file includes itself, directly or
+// through other files.
+var ErrIncludeCycle = errors.New("include cycle")
+
+// Loader parses SHON files and resolves their @include directives. Files are
+// read from FS, which acts as the allow-listed root: include paths are
+// resolved relative to the including file and may not leave FS. FS should
+// not follow symbolic links out of the root either, as the FS of an
+// os.Root does not. Limits applies to every file loaded and to the chain
+// of includes.
+type Loader struct {
+	FS     fs.FS
+	Limits Limits
+}
+
+func NewLoader(fsys fs.FS) *Loader {
+	return &Loader{FS: fsys}
+}
+
+// LoadFile loads the SHON file at filePath, resolving includes within root.
+// An empty root means the directory containing filePath. Files are read
+// through an os.Root, so symbolic links may not lead out of root.
+func LoadFile(filePath, root string) (*Document, error) {
+	return LoadFileWithLimits(filePath, root, Limits{})
+}
+
+// LoadFileWithLimits loads a SHON file like LoadFile, returning a
+// LimitError if it or its includes exceed limits.
+func LoadFileWithLimits(filePath, root string, limits Limits) (*Document, error) {
+	if root == "" {
+		root = filepath.Dir(filePath)
+	} else if abs, err := filepath.Abs(filePath); err == nil {
+		// An explicit root may be given differently from filePath, e.g.
+		// absolute where filePath is relative.
+		filePath = abs
+		if absRoot, err := filepath.Abs(root); err == nil {
+			root = absRoot
+		}
+	}
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil || !fs.ValidPath(filepath.ToSlash(rel)) {
+		return nil, fmt.Errorf("%s is outside the include root %s", filePath, root)
+	}
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	l := NewLoader(r.FS())
+	l.Limits = limits
+	return l.Load(filepath.ToSlash(rel))
+}
+
+// LoadResolved loads a SHON file like LoadFile and then resolves its aliases
+// and constants, leaving a document ready for conversion or validation.
+// Error diagnostics are combined into the returned error; warnings are
+// returned for the caller to report.
+func LoadResolved(filePath, root string) (*Document, []Diagnostic, error) {
+	return LoadResolvedWithLimits(filePath, root, Limits{})
+}
+
+// LoadResolvedWithLimits loads and resolves a SHON file like LoadResolved,
+// returning a LimitError if it or its includes exceed limits.
+func LoadResolvedWithLimits(filePath, root string, limits Limits) (*Document, []Diagnostic, error) {
+	doc, err := LoadFileWithLimits(filePath, root, limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ResolveWithLimits(doc, limits)
+}
+
+// Resolve applies ResolveAliases and ResolveConstants to an already loaded
+// document. Error diagnostics are combined into the returned error.
+func Resolve(doc *Document) (*Document, []Diagnostic, error) {
+	return ResolveWithLimits(doc, Limits{})
+}
+
+// ResolveWithLimits resolves a document like Resolve, returning a
+// LimitError if constant references expand to more than
+// limits.MaxExpansion values.
+func ResolveWithLimits(doc *Document, limits Limits) (*Document, []Diagnostic, error) {
+	doc, diags := ResolveAliases(doc)
+	doc, constDiags, err := resolveConstants(doc, limits.MaxExpansion)
+	if err != nil {
+		return nil, nil, err
+	}
+	diags = append(diags, constDiags...)
+	if err := DiagnosticsError(diags); err != nil {
+		return nil, nil, err
+	}
+	return doc, diags, nil
+}
+
+// Load parses the named file and merges in everything it includes.
+// Included files are merged in order and the including file is applied last,
+// so a namespace that appears in several files is combined key by key with
+// later definitions winning. Only the named file's metadata, such as
+// $schema, is kept; included files contribute namespaces.
+func (l *Loader) Load(name string) (*Document, error) {
+	return l.load(name, nil)
+}
+
+func (l *Loader) load(name string, chain []string) (*Document, error) {
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(chain, name), " -> "))
+		}
+	}
+	if exceeds(len(chain), l.Limits.MaxIncludeDepth) {
+		return nil, &LimitError{Limit: "MaxIncludeDepth", Max: l.Limits.MaxIncludeDepth}
+	}
+	chain = append(chain, name)
+
+	data, err := fs.ReadFile(l.FS, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SHON file: %w", err)
+	}
+	doc, err := ParseWithLimits(data, l.Limits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	merged := NewDocument()
+	for _, inc := range doc.Includes {
+		target, err := resolveInclude(name, inc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sub, err := l.load(target, chain)
+		if err != nil {
+			if errors.Is(err, ErrIncludeCycle) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		mergeDocument(merged, sub)
+	}
+	doc.Includes = nil
+	mergeDocument(merged, doc)
+	merged.Meta = doc.Meta
+	return merged, nil
+}
+
+// resolveInclude resolves an include path relative to the including file.
+func resolveInclude(from, inc string) (string, error) {
+	if path.IsAbs(inc) || filepath.IsAbs(inc) {
+		return "", fmt.Errorf("absolute include path %q is not allowed", inc)
+	}
+	target := path.Clean(path.Join(path.Dir(from), filepath.ToSlash(inc)))
+	if !fs.ValidPath(target) {
+		return "", fmt.Errorf("include %q escapes the include root", inc)
+	}
+	return target, nil
+}
+
+// mergeDocument merges the namespaces of src into dst, with keys and
+// namespace metadata such as $tags from src replacing those already in
+// dst. Document metadata is not merged.
+func mergeDocument(dst, src *Document) {
+	for _, ns := range src.Namespaces {
+		existing := dst.Namespace(ns.Name)
+		if existing == nil {
+			dst.Namespaces = append(dst.Namespaces, ns)
+			continue
+		}
+		if ns.Body.Meta != nil {
+			for _, k := range ns.Body.Meta.Keys {
+				existing.Body.SetMeta(k, ns.Body.Meta.Values[k])
+			}
+		}
+		for _, k := range ns.Body.Keys {
+			existing.Body.Set(k, ns.Body.Values[k])
+		}
+	}
+}
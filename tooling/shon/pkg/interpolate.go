@@ -0,0 +1,502 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrInterpolationDisabled is returned when a document holds $env or $expr
+// values but interpolation has been turned off, as it should be for
+// untrusted input.
+var ErrInterpolationDisabled = errors.New("$env and $expr are not allowed: interpolation is disabled")
+
+// maxRefChain bounds how many references an expression operand may pass
+// through, so self-referencing fields fail instead of looping.
+const maxRefChain = 64
+
+// maxExprTerms bounds the operators and parentheses in an expression, so
+// parsing and evaluating it cannot recurse without end.
+const maxExprTerms = 1000
+
+// ParseExpr parses the text of a $expr value. Expressions combine numbers,
+// 'single' or "double" quoted strings, true, false and &references with +,
+// -, * and / and parentheses. + concatenates when either side is a string.
+func ParseExpr(text string) (*Expr, error) {
+	p := &exprParser{src: text}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+	}
+	if ex, ok := e.(*Expr); ok {
+		return ex, nil
+	}
+	return &Expr{Args: []interface{}{e}}, nil
+}
+
+type exprParser struct {
+	src   string
+	pos   int
+	terms int
+}
+
+// term counts an operator or parenthesis against maxExprTerms.
+func (p *exprParser) term() error {
+	if p.terms++; p.terms > maxExprTerms {
+		return fmt.Errorf("expression has more than %d operators and parentheses", maxExprTerms)
+	}
+	return nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) sum() (interface{}, error) {
+	return p.binary("+-", p.product)
+}
+
+func (p *exprParser) product() (interface{}, error) {
+	return p.binary("*/", p.unary)
+}
+
+func (p *exprParser) binary(ops string, operand func() (interface{}, error)) (interface{}, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || !strings.ContainsRune(ops, rune(p.src[p.pos])) {
+			return left, nil
+		}
+		op := string(p.src[p.pos])
+		p.pos++
+		if err := p.term(); err != nil {
+			return nil, err
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: op, Args: []interface{}{left, right}}
+	}
+}
+
+func (p *exprParser) unary() (interface{}, error) {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '-' {
+		p.pos++
+		if err := p.term(); err != nil {
+			return nil, err
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "neg", Args: []interface{}{operand}}, nil
+	}
+	return p.operand()
+}
+
+func (p *exprParser) operand() (interface{}, error) {
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		if err := p.term(); err != nil {
+			return nil, err
+		}
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return nil, fmt.Errorf("missing ')' for '(' at offset %d", start)
+		}
+		p.pos++
+		return e, nil
+	case c == '\'' || c == '"':
+		return p.string(c)
+	case c == '&':
+		p.pos++
+		for p.pos < len(p.src) && (isIdentChar(p.src[p.pos]) || strings.IndexByte(".[]-", p.src[p.pos]) >= 0) {
+			p.pos++
+		}
+		ref := p.src[start+1 : p.pos]
+		if _, err := ParsePath(ref); err != nil || ref == "" {
+			return nil, fmt.Errorf("invalid reference &%s", ref)
+		}
+		return Ref(ref), nil
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE", p.src[p.pos]) >= 0 {
+			if (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '+' || p.src[p.pos+1] == '-') {
+				p.pos++
+			}
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		if _, ok := new(big.Rat).SetString(text); !ok {
+			return nil, fmt.Errorf("invalid number %q", text)
+		}
+		return json.Number(text), nil
+	case isIdentChar(c):
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+			p.pos++
+		}
+		switch word := p.src[start:p.pos]; word {
+		case "true", "false":
+			return word == "true", nil
+		default:
+			return nil, fmt.Errorf("unknown name %q; refer to fields and constants with &", word)
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", p.src[p.pos], p.pos)
+}
+
+func (p *exprParser) string(quote byte) (interface{}, error) {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return sb.String(), nil
+		case c == '\\' && p.pos < len(p.src):
+			sb.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return nil, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+// FormatExpr writes an expression back as the text of a $expr value, with
+// only the parentheses its operators need.
+func FormatExpr(e *Expr) string {
+	switch e.Op {
+	case "":
+		return formatOperand(e.Args[0], 0, false)
+	case "neg":
+		return "-" + formatOperand(e.Args[0], 3, false)
+	}
+	prec := precedence(e.Op)
+	return formatOperand(e.Args[0], prec, false) + " " + e.Op + " " + formatOperand(e.Args[1], prec, true)
+}
+
+func precedence(op string) int {
+	switch op {
+	case "+", "-":
+		return 1
+	case "*", "/":
+		return 2
+	}
+	return 3
+}
+
+// formatOperand writes an operand of an operator of precedence prec.
+// Operators are left-associative, so a right operand of the same
+// precedence needs parentheses.
+func formatOperand(v interface{}, prec int, right bool) string {
+	switch val := v.(type) {
+	case *Expr:
+		if p := precedence(val.Op); p < prec || p == prec && right {
+			return "(" + FormatExpr(val) + ")"
+		}
+		return FormatExpr(val)
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(val) + "'"
+	default:
+		return EncodeValue(v, 0, EncodeOptions{})
+	}
+}
+
+// InterpolateOptions controls how $env and $expr values are interpolated.
+type InterpolateOptions struct {
+	// LookupEnv looks up environment variables. It defaults to
+	// os.LookupEnv.
+	LookupEnv func(name string) (string, bool)
+	// Limits bounds the work interpolation does: MaxExpansion counts the
+	// references and operators evaluated and MaxStringLength bounds the
+	// strings and numbers expressions compute.
+	Limits Limits
+}
+
+// Interpolate returns a copy of doc with every $env value replaced by its
+// environment variable and every $expr value by its result. It expects a
+// document whose aliases and constants are resolved, so references are to
+// fields.
+//
+// An environment variable takes the type of the default: with a number,
+// decimal, boolean or timestamp default it must parse as one. Without a
+// default the variable must be set and is a string.
+func Interpolate(doc *Document, opts InterpolateOptions) (*Document, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	in := &interpolator{
+		doc:       doc.Clone(),
+		lookupEnv: opts.LookupEnv,
+		expanded:  &expansion{max: opts.Limits.MaxExpansion},
+		maxString: opts.Limits.MaxStringLength,
+		results:   map[string]interface{}{},
+	}
+	out := in.doc
+	replace := func(v interface{}, path string) interface{} {
+		switch v.(type) {
+		case *Env, *Expr:
+		default:
+			return v
+		}
+		if in.err != nil {
+			return v
+		}
+		result, err := in.value(v, 0)
+		if err != nil {
+			in.err = fmt.Errorf("%s: %w", pathOrRoot(path), err)
+			return v
+		}
+		return result
+	}
+	out.Meta = Rewrite(out.Meta, "", replace).(*Object)
+	for _, ns := range out.Namespaces {
+		ns.Body = Rewrite(ns.Body, ns.Name, replace).(*Object)
+	}
+	if in.err != nil {
+		return nil, in.err
+	}
+	return out, nil
+}
+
+// rejectInterpolation returns ErrInterpolationDisabled, with the path of
+// the value, if doc holds $env or $expr values.
+func rejectInterpolation(doc *Document) error {
+	return WalkDocument(doc, func(v interface{}, path string) error {
+		switch v.(type) {
+		case *Env, *Expr:
+			return fmt.Errorf("%s: %w", pathOrRoot(path), ErrInterpolationDisabled)
+		}
+		return nil
+	})
+}
+
+type interpolator struct {
+	doc       *Document
+	lookupEnv func(string) (string, bool)
+	expanded  *expansion
+	maxString int
+	// results holds the value of each referenced $env and $expr field by
+	// path, so a field referenced many times is evaluated once.
+	results map[string]interface{}
+	err     error
+}
+
+// value evaluates v, following references; depth counts the references
+// followed so far.
+func (in *interpolator) value(v interface{}, depth int) (interface{}, error) {
+	switch val := v.(type) {
+	case *Env:
+		text, ok := in.lookupEnv(val.Name)
+		if !ok {
+			if !val.HasDefault {
+				return nil, fmt.Errorf("environment variable %s is not set and has no default", val.Name)
+			}
+			return in.value(val.Default, depth)
+		}
+		return envValue(val, text)
+	case *Expr:
+		return in.eval(val, depth)
+	}
+	return v, nil
+}
+
+// envValue converts an environment variable to the type of its default.
+func envValue(env *Env, text string) (interface{}, error) {
+	switch env.Default.(type) {
+	case json.Number:
+		if !jsonNumberPattern.MatchString(text) {
+			return nil, fmt.Errorf("environment variable %s is %q, expected a number", env.Name, text)
+		}
+		return json.Number(text), nil
+	case Decimal:
+		if !decimalPattern.MatchString(text) {
+			return nil, fmt.Errorf("environment variable %s is %q, expected a decimal", env.Name, text)
+		}
+		return Decimal(text), nil
+	case bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s is %q, expected a boolean", env.Name, text)
+		}
+		return b, nil
+	case Timestamp:
+		return Timestamp(text), nil
+	}
+	return text, nil
+}
+
+func (in *interpolator) eval(e *Expr, depth int) (interface{}, error) {
+	if err := in.expanded.add(1); err != nil {
+		return nil, err
+	}
+	result, err := in.apply(e, depth)
+	if err != nil {
+		return nil, err
+	}
+	if text, _ := scalarText(result); exceeds(len(text), in.maxString) {
+		return nil, &LimitError{Limit: "MaxStringLength", Max: in.maxString}
+	}
+	return result, nil
+}
+
+// apply evaluates the operands of e and applies its operator.
+func (in *interpolator) apply(e *Expr, depth int) (interface{}, error) {
+	args := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		v, err := in.operand(arg, depth)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch e.Op {
+	case "":
+		return args[0], nil
+	case "neg":
+		r, isDecimal, ok := exprNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", TypeName(args[0]))
+		}
+		return exprResult(r.Neg(r), isDecimal), nil
+	}
+
+	if e.Op == "+" {
+		_, leftString := args[0].(string)
+		_, rightString := args[1].(string)
+		if leftString || rightString {
+			left, lok := exprText(args[0])
+			right, rok := exprText(args[1])
+			if !lok || !rok {
+				return nil, fmt.Errorf("cannot concatenate %s and %s", TypeName(args[0]), TypeName(args[1]))
+			}
+			return left + right, nil
+		}
+	}
+	a, aDecimal, aok := exprNumber(args[0])
+	b, bDecimal, bok := exprNumber(args[1])
+	if !aok || !bok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", e.Op, TypeName(args[0]), TypeName(args[1]))
+	}
+	// The result has at most the operands' digits; refuse to compute it
+	// if that could be over the limit.
+	if exceeds(ratDigits(a)+ratDigits(b), in.maxString) {
+		return nil, &LimitError{Limit: "MaxStringLength", Max: in.maxString}
+	}
+	r := new(big.Rat)
+	switch e.Op {
+	case "+":
+		r.Add(a, b)
+	case "-":
+		r.Sub(a, b)
+	case "*":
+		r.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		r.Quo(a, b)
+	}
+	return exprResult(r, aDecimal || bDecimal), nil
+}
+
+// operand evaluates an expression operand, dereferencing references.
+func (in *interpolator) operand(v interface{}, depth int) (interface{}, error) {
+	path := ""
+	for {
+		ref, ok := v.(Ref)
+		if !ok {
+			break
+		}
+		if depth++; depth > maxRefChain {
+			return nil, fmt.Errorf("&%s: too many references; is a field defined in terms of itself?", ref)
+		}
+		if err := in.expanded.add(1); err != nil {
+			return nil, err
+		}
+		target, err := in.doc.Deref(ref)
+		if err != nil {
+			return nil, err
+		}
+		v, path = target, string(ref)
+	}
+	if result, ok := in.results[path]; ok {
+		return result, nil
+	}
+	v, err := in.value(v, depth)
+	if err != nil {
+		return nil, err
+	}
+	switch v.(type) {
+	case *Object, []interface{}, *Tuple, nil:
+		return nil, fmt.Errorf("expressions work on scalars, found %s", TypeName(v))
+	}
+	if path != "" {
+		in.results[path] = v
+	}
+	return v, nil
+}
+
+func exprNumber(v interface{}) (*big.Rat, bool, bool) {
+	switch val := v.(type) {
+	case json.Number:
+		r, ok := new(big.Rat).SetString(string(val))
+		return r, false, ok
+	case Decimal:
+		r, ok := new(big.Rat).SetString(string(val))
+		return r, true, ok
+	}
+	return nil, false, false
+}
+
+// ratDigits estimates the decimal digits in a rational's numerator and
+// denominator.
+func ratDigits(r *big.Rat) int {
+	return (r.Num().BitLen() + r.Denom().BitLen()) * 30103 / 100000
+}
+
+func exprText(v interface{}) (string, bool) {
+	if b, ok := v.(bool); ok {
+		return strconv.FormatBool(b), true
+	}
+	return scalarText(v)
+}
+
+// exprResult writes a computed number exactly, or to 20 decimal places if
+// it does not terminate.
+func exprResult(r *big.Rat, isDecimal bool) interface{} {
+	text := canonicalNumber(r.FloatString(20))
+	if r.IsInt() {
+		text = r.Num().String()
+	}
+	if isDecimal {
+		return Decimal(text)
+	}
+	return json.Number(text)
+}
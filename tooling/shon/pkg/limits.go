@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits bounds the resources parsing and loading a document may use, so
+// documents from untrusted sources cannot exhaust memory or the stack. A
+// zero field means no limit; the zero Limits, which Parse and LoadFile use,
+// limits nothing.
+type Limits struct {
+	// MaxSize is the largest source, in bytes, of a single file.
+	MaxSize int
+	// MaxDepth is the deepest nesting of objects, arrays and tuples.
+	MaxDepth int
+	// MaxStringLength is the longest string, key or reference in bytes,
+	// and the longest string or number an expression may compute.
+	MaxStringLength int
+	// MaxKeys is the most object, metadata and namespace keys a file may
+	// hold.
+	MaxKeys int
+	// MaxIncludeDepth is the deepest chain of includes.
+	MaxIncludeDepth int
+	// MaxExpansion is the most values constant references may expand to,
+	// and the most references and operators interpolation may evaluate.
+	// It stops documents whose references multiply at every level, which
+	// are small to write but enormous once resolved.
+	MaxExpansion int
+}
+
+// DefaultLimits are limits suited to documents from untrusted sources,
+// such as uploads. They are well above what configuration files need.
+var DefaultLimits = Limits{
+	MaxSize:         10 << 20,
+	MaxDepth:        100,
+	MaxStringLength: 1 << 20,
+	MaxKeys:         100000,
+	MaxIncludeDepth: 16,
+	MaxExpansion:    1000000,
+}
+
+// ErrLimitExceeded matches every LimitError with errors.Is.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when a document exceeds one of its Limits. Limit
+// names the Limits field, e.g. "MaxDepth", and Max is its value.
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case "MaxSize":
+		return fmt.Sprintf("document is larger than %d bytes", e.Max)
+	case "MaxDepth":
+		return fmt.Sprintf("values are nested deeper than %d levels", e.Max)
+	case "MaxStringLength":
+		return fmt.Sprintf("value is longer than %d bytes", e.Max)
+	case "MaxKeys":
+		return fmt.Sprintf("document has more than %d keys", e.Max)
+	case "MaxIncludeDepth":
+		return fmt.Sprintf("includes are nested deeper than %d files", e.Max)
+	case "MaxExpansion":
+		return fmt.Sprintf("references expand to more than %d values", e.Max)
+	}
+	return fmt.Sprintf("%s of %d exceeded", e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// exceeds reports whether n is over the limit max, which is unlimited if
+// zero.
+func exceeds(n, max int) bool {
+	return max > 0 && n > max
+}
+
+// expansion counts values against Limits.MaxExpansion.
+type expansion struct {
+	max   int
+	count int
+}
+
+// add counts n more values, returning a LimitError once over the limit.
+func (e *expansion) add(n int) error {
+	e.count += n
+	if exceeds(e.count, e.max) {
+		return &LimitError{Limit: "MaxExpansion", Max: e.max}
+	}
+	return nil
+}
+
+// countValues returns the number of values in v, counting v itself.
+func countValues(v interface{}) int {
+	n := 0
+	Walk(v, "", func(interface{}, string) error {
+		n++
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,201 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// ConfigFile is the name of the lint configuration file, searched for from
+// the linted file's directory upward.
+const ConfigFile = ".shonlint"
+
+// RulesNamespace is the namespace of a .shonlint file that configures rules.
+const RulesNamespace = "rules"
+
+// RuleConfig is the configuration of one rule.
+type RuleConfig struct {
+	Off      bool
+	Severity pkg.Severity
+	// Style is the key naming style keyNaming enforces, or "" for the style
+	// most keys of the document use.
+	Style string
+	// MaxDepth is how deep deepNesting lets containers nest.
+	MaxDepth int
+}
+
+// Config maps rule names to their configuration.
+type Config struct {
+	Rules map[string]RuleConfig
+}
+
+// DefaultConfig enables every rule with its default severity.
+func DefaultConfig() *Config {
+	c := &Config{Rules: map[string]RuleConfig{}}
+	for _, r := range rules {
+		c.Rules[r.name] = RuleConfig{Severity: r.severity, MaxDepth: defaultMaxDepth}
+	}
+	return c
+}
+
+// ParseConfig reads a .shonlint file. Each field of its @rules namespace
+// names a rule and sets its severity, "error", "warning" or "off", or is an
+// object with a severity and the rule's options:
+//
+//	@rules {
+//	    trailingComma: "off",
+//	    keyNaming: { severity: "error", style: "camelCase" },
+//	    deepNesting: { maxDepth: 5 }
+//	}
+//
+// Rules it does not mention keep their defaults.
+func ParseConfig(data []byte) (*Config, error) {
+	c := DefaultConfig()
+	if err := c.applySource(data); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) applySource(data []byte) error {
+	doc, err := pkg.Parse(data)
+	if err != nil {
+		return err
+	}
+	if ns := doc.Namespace(RulesNamespace); ns != nil {
+		return c.Apply(ns.Body)
+	}
+	return nil
+}
+
+// Apply overrides rule configuration with the fields of settings, written
+// as in the @rules namespace of a .shonlint file.
+func (c *Config) Apply(settings *pkg.Object) error {
+	for _, name := range settings.Keys {
+		rc, ok := c.Rules[name]
+		if !ok {
+			return fmt.Errorf("unknown lint rule %q (known rules: %s)", name, ruleNames())
+		}
+		switch v := settings.Values[name].(type) {
+		case string:
+			if err := rc.setSeverity(v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		case *pkg.Object:
+			for _, opt := range v.Keys {
+				if err := rc.setOption(opt, v.Values[opt]); err != nil {
+					return fmt.Errorf("%s.%s: %w", name, opt, err)
+				}
+			}
+		default:
+			return fmt.Errorf("%s: expected a severity or an object, found %s", name, pkg.TypeName(v))
+		}
+		c.Rules[name] = rc
+	}
+	return nil
+}
+
+func (rc *RuleConfig) setSeverity(s string) error {
+	switch s {
+	case "off":
+		rc.Off = true
+	case "error":
+		rc.Off, rc.Severity = false, pkg.SeverityError
+	case "warning":
+		rc.Off, rc.Severity = false, pkg.SeverityWarning
+	default:
+		return fmt.Errorf("unknown severity %q, expected \"error\", \"warning\" or \"off\"", s)
+	}
+	return nil
+}
+
+func (rc *RuleConfig) setOption(name string, v interface{}) error {
+	switch name {
+	case "severity":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, found %s", pkg.TypeName(v))
+		}
+		return rc.setSeverity(s)
+	case "style":
+		s, ok := v.(string)
+		if !ok || namingStyles[s] == nil {
+			return fmt.Errorf("unknown style %v, expected one of %s", v, styleNames())
+		}
+		rc.Style = s
+	case "maxDepth":
+		n, ok := v.(json.Number)
+		depth, err := strconv.Atoi(string(n))
+		if !ok || err != nil || depth < 1 {
+			return fmt.Errorf("expected a positive integer, found %v", v)
+		}
+		rc.MaxDepth = depth
+	default:
+		return fmt.Errorf("unknown option")
+	}
+	return nil
+}
+
+// FindConfig returns the nearest .shonlint in dir or one of its parents,
+// or "" if there is none.
+func FindConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ConfigFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads the .shonlint file at path.
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if err := c.ApplyFile(path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ApplyFile overrides rule configuration with the .shonlint file at path,
+// leaving the rules it does not mention as they are.
+func (c *Config) ApplyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := c.applySource(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func ruleNames() string {
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.name)
+	}
+	return fmt.Sprint(names)
+}
+
+func styleNames() string {
+	var names []string
+	for name := range namingStyles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprint(names)
+}
@@ -0,0 +1,450 @@
+// Package lint checks SHON documents for problems that are valid syntax
+// but likely mistakes or inconsistencies, such as duplicate keys or
+// timestamps without a zone.
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+// Finding is one problem lint found. Line and Col are 1-based.
+type Finding struct {
+	Rule      string
+	Severity  pkg.Severity
+	Path      string
+	Line, Col int
+	Message   string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s (%s)", f.Line, f.Col, f.Severity, f.Message, f.Rule)
+}
+
+// HasErrors reports whether any finding is an error.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == pkg.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+type rule struct {
+	name     string
+	severity pkg.Severity
+	check    func(l *linter)
+}
+
+// rules lists every rule with its default severity.
+var rules = []rule{
+	{"duplicateKeys", pkg.SeverityError, (*linter).duplicateKeys},
+	{"unusedRefs", pkg.SeverityWarning, (*linter).unusedRefs},
+	{"keyNaming", pkg.SeverityWarning, (*linter).keyNaming},
+	{"floatDecimal", pkg.SeverityWarning, (*linter).floatDecimal},
+	{"zonelessTimestamp", pkg.SeverityWarning, (*linter).zonelessTimestamp},
+	{"trailingComma", pkg.SeverityWarning, (*linter).trailingComma},
+	{"uncoveredNamespace", pkg.SeverityWarning, (*linter).uncoveredNamespace},
+	{"deepNesting", pkg.SeverityWarning, (*linter).deepNesting},
+}
+
+const defaultMaxDepth = 8
+
+type linter struct {
+	src      []byte
+	doc      *pkg.Document
+	source   *pkg.SourceMap
+	schema   *schema.Schema
+	rule     string
+	config   RuleConfig
+	disabled map[int][]string
+	findings []Finding
+}
+
+// Lint checks SHON source with the rules config enables. A nil config
+// means DefaultConfig. The schema, which may be nil, lets the
+// uncoveredNamespace rule run and keeps keyNaming from checking the keys of
+// maps. Lint returns an error only if src does not parse.
+func Lint(src []byte, config *Config, sch *schema.Schema) ([]Finding, error) {
+	doc, source, err := pkg.ParseWithSourceMap(src)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	l := &linter{src: src, doc: doc, source: source, schema: sch, disabled: suppressions(src, source)}
+	for _, r := range rules {
+		rc := config.Rules[r.name]
+		if rc.Off {
+			continue
+		}
+		l.rule, l.config = r.name, rc
+		r.check(l)
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i], l.findings[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+	return l.findings, nil
+}
+
+// suppressionPattern matches the comments that silence rules on a line:
+// shonlint-disable-line on the line itself, or shonlint-disable-next-line
+// on the line above, each followed by the rules to silence or by nothing
+// to silence them all.
+var suppressionPattern = regexp.MustCompile(`^(?://|/\*)\s*shonlint-disable-(next-line|line)\b([\w ,]*)`)
+
+// suppressions maps 1-based line numbers to the rules disabled on them; an
+// empty list disables every rule. Only comments count, so text such as
+// "// shonlint-disable-line" inside a string silences nothing.
+func suppressions(src []byte, source *pkg.SourceMap) map[int][]string {
+	disabled := map[int][]string{}
+	for _, c := range source.Comments {
+		m := suppressionPattern.FindSubmatch(src[c.Start:c.End])
+		if m == nil {
+			continue
+		}
+		target, _ := source.Position(c.Start)
+		if string(m[1]) == "next-line" {
+			target++
+		}
+		disabled[target] = strings.FieldsFunc(string(m[2]), func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return disabled
+}
+
+func (l *linter) suppressed(line int) bool {
+	names, ok := l.disabled[line]
+	if !ok {
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == l.rule {
+			return true
+		}
+	}
+	return false
+}
+
+// report adds a finding at offset unless a comment suppresses it.
+func (l *linter) report(offset int, path, format string, args ...interface{}) {
+	line, col := l.source.Position(offset)
+	if l.suppressed(line) {
+		return
+	}
+	l.findings = append(l.findings, Finding{
+		Rule:     l.rule,
+		Severity: l.config.Severity,
+		Path:     path,
+		Line:     line,
+		Col:      col,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// reportAt adds a finding at the key path is written under, or at its
+// value for array items.
+func (l *linter) reportAt(path, format string, args ...interface{}) {
+	e := l.source.Entries[path]
+	offset := e.Key.Start
+	if e.Key.Start == e.Key.End {
+		offset = e.Value.Start
+	}
+	l.report(offset, path, format, args...)
+}
+
+// skipNamespace reports whether a namespace holds tooling data rather than
+// document data.
+func skipNamespace(name string) bool {
+	return name == pkg.ConstNamespace || name == pkg.AliasNamespace || name == pkg.MigrationsNamespace
+}
+
+// duplicateKeys reports keys set more than once in the same object, where
+// every value but the last is silently dropped.
+func (l *linter) duplicateKeys() {
+	for path, earlier := range l.source.Duplicates {
+		if l.underDuplicate(path) {
+			continue
+		}
+		first, _ := l.source.Position(earlier[0].Key.Start)
+		key := strings.Trim(string(l.src[earlier[0].Key.Start:earlier[0].Key.End]), `"`)
+		for _, e := range append(earlier[1:], l.source.Entries[path]) {
+			l.report(e.Key.Start, path, "key %q is already set on line %d; only the last value is kept", key, first)
+		}
+	}
+}
+
+// underDuplicate reports whether path lies inside a value that is itself
+// duplicated, which is reported once for the whole value.
+func (l *linter) underDuplicate(path string) bool {
+	for other := range l.source.Duplicates {
+		if strings.HasPrefix(path, other+".") || strings.HasPrefix(path, other+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// unusedRefs reports lookup namespaces, flat namespaces of scalars like
+// those CSVToShon writes, that nothing references. Documents without any
+// references are left alone.
+func (l *linter) unusedRefs() {
+	aliases, _ := l.doc.Aliases()
+	referenced := map[string]bool{}
+	pkg.WalkDocument(l.doc, func(v interface{}, path string) error {
+		if ref, ok := v.(pkg.Ref); ok {
+			target := string(pkg.CanonicalRef(ref, aliases))
+			if end := strings.IndexAny(target, ".["); end >= 0 {
+				target = target[:end]
+			}
+			referenced[target] = true
+		}
+		return nil
+	})
+	if len(referenced) == 0 {
+		return
+	}
+	for _, ns := range l.doc.Namespaces {
+		if skipNamespace(ns.Name) || referenced[ns.Name] || !isLookupTable(ns.Body) {
+			continue
+		}
+		l.reportAt(ns.Name, "namespace @%s is never referenced", ns.Name)
+	}
+}
+
+func isLookupTable(o *pkg.Object) bool {
+	if o.Len() == 0 {
+		return false
+	}
+	for _, k := range o.Keys {
+		switch o.Values[k].(type) {
+		case *pkg.Object, []interface{}, *pkg.Tuple:
+			return false
+		}
+	}
+	return true
+}
+
+// namingStyles are the key naming styles keyNaming knows. A single
+// lowercase word fits camelCase, snake_case and kebab-case alike.
+var namingStyles = map[string]*regexp.Regexp{
+	"camelCase":  regexp.MustCompile(`^[a-z][a-z0-9]*([A-Z][a-z0-9]*)*$`),
+	"snake_case": regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`),
+	"kebab-case": regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`),
+	"PascalCase": regexp.MustCompile(`^[A-Z][a-z0-9]*([A-Z][a-z0-9]*)*$`),
+}
+
+// styleOrder breaks ties between equally common styles.
+var styleOrder = []string{"camelCase", "snake_case", "kebab-case", "PascalCase"}
+
+func stylesOf(key string) []string {
+	var styles []string
+	for _, name := range styleOrder {
+		if namingStyles[name].MatchString(key) {
+			styles = append(styles, name)
+		}
+	}
+	return styles
+}
+
+// keyNaming reports keys written in a different style from the configured
+// one, or from the style most keys of the document use. Keys of maps,
+// constants and aliases, and keys that fit no known style, are not checked.
+func (l *linter) keyNaming() {
+	type field struct{ key, path string }
+	var fields []field
+	for _, ns := range l.doc.Namespaces {
+		if skipNamespace(ns.Name) {
+			continue
+		}
+		pkg.Walk(ns.Body, ns.Name, func(v interface{}, path string) error {
+			o, ok := v.(*pkg.Object)
+			if !ok || l.isMap(path) {
+				return nil
+			}
+			for _, k := range o.Keys {
+				fields = append(fields, field{k, pkg.JoinPath(path, k)})
+			}
+			return nil
+		})
+	}
+
+	style := l.config.Style
+	if style == "" {
+		counts := map[string]int{}
+		for _, f := range fields {
+			if styles := stylesOf(f.key); len(styles) == 1 {
+				counts[styles[0]]++
+			}
+		}
+		for _, name := range styleOrder {
+			if counts[name] > counts[style] {
+				style = name
+			}
+		}
+		if style == "" {
+			return
+		}
+	}
+
+	for _, f := range fields {
+		styles := stylesOf(f.key)
+		if len(styles) == 0 || namingStyles[style].MatchString(f.key) {
+			continue
+		}
+		if l.config.Style != "" {
+			l.reportAt(f.path, "key %q is %s, not %s", f.key, styles[0], style)
+		} else {
+			l.reportAt(f.path, "key %q is %s, but most keys are %s", f.key, styles[0], style)
+		}
+	}
+}
+
+// isMap reports whether the schema declares the value at path as a map,
+// whose keys are data rather than field names.
+func (l *linter) isMap(path string) bool {
+	if l.schema == nil {
+		return false
+	}
+	f, ok := l.schema.Describe(path)
+	return ok && strings.TrimSuffix(f.Type, "?") == "map"
+}
+
+// floatDecimal reports $decimal(1.10) written with a number literal, which
+// reads like a float; $decimal("1.10") makes the exact value explicit.
+func (l *linter) floatDecimal() {
+	pkg.WalkDocument(l.doc, func(v interface{}, path string) error {
+		d, ok := v.(pkg.Decimal)
+		if !ok {
+			return nil
+		}
+		e, ok := l.source.Entries[path]
+		if !ok {
+			return nil
+		}
+		text := string(l.src[e.Value.Start:e.Value.End])
+		arg := strings.TrimLeft(strings.TrimPrefix(text, "$decimal"), " \t(")
+		if !strings.HasPrefix(arg, `"`) {
+			l.report(e.Value.Start, path, "decimal written as a number literal; quote it as $decimal(%q)", string(d))
+		}
+		return nil
+	})
+}
+
+var zonePattern = regexp.MustCompile(`([Zz]|[+-]\d{2}(:?\d{2})?)$`)
+
+// zonelessTimestamp reports timestamps with a time of day but no zone,
+// whose instant depends on where they are read.
+func (l *linter) zonelessTimestamp() {
+	pkg.WalkDocument(l.doc, func(v interface{}, path string) error {
+		ts, ok := v.(pkg.Timestamp)
+		if !ok {
+			return nil
+		}
+		s := string(ts)
+		if strings.Contains(s, ":") && !zonePattern.MatchString(s) {
+			l.reportAt(path, "timestamp %q has no zone; add Z or an offset such as +02:00", s)
+		}
+		return nil
+	})
+}
+
+// trailingComma reports commas directly before a closing bracket.
+func (l *linter) trailingComma() {
+	src := l.src
+	comma := -1
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(string(src[i+2:]), "*/")
+			if end < 0 {
+				return
+			}
+			i += end + 3
+		case c == '"':
+			for i++; i < len(src) && src[i] != '"' && src[i] != '\n'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			comma = -1
+		case c == ',':
+			comma = i
+		case c == '}' || c == ']' || c == ')':
+			if comma >= 0 {
+				l.report(comma, "", "trailing comma before %q", c)
+			}
+			comma = -1
+		default:
+			comma = -1
+		}
+	}
+}
+
+// uncoveredNamespace reports namespaces the schema says nothing about.
+func (l *linter) uncoveredNamespace() {
+	if l.schema == nil {
+		return
+	}
+	covered := map[string]bool{}
+	for _, name := range l.schema.Namespaces() {
+		covered[name] = true
+	}
+	for _, ns := range l.doc.Namespaces {
+		if !skipNamespace(ns.Name) && !covered[ns.Name] {
+			l.reportAt(ns.Name, "namespace @%s is not covered by the schema", ns.Name)
+		}
+	}
+}
+
+// deepNesting reports objects and arrays nested more than maxDepth levels
+// deep, counting the namespace body as the first. Only the outermost
+// container past the limit is reported.
+func (l *linter) deepNesting() {
+	max := l.config.MaxDepth
+	if max <= 0 {
+		max = defaultMaxDepth
+	}
+	for _, ns := range l.doc.Namespaces {
+		var walk func(v interface{}, path string, depth int)
+		walk = func(v interface{}, path string, depth int) {
+			switch val := v.(type) {
+			case *pkg.Object:
+				if depth > max {
+					l.reportAt(path, "nested %d levels deep, more than %d", depth, max)
+					return
+				}
+				for _, k := range val.Keys {
+					walk(val.Values[k], pkg.JoinPath(path, k), depth+1)
+				}
+			case []interface{}:
+				if depth > max {
+					l.reportAt(path, "nested %d levels deep, more than %d", depth, max)
+					return
+				}
+				for i, item := range val {
+					walk(item, pkg.IndexPath(path, i), depth+1)
+				}
+			}
+		}
+		walk(ns.Body, ns.Name, 1)
+	}
+}
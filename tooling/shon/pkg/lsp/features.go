@@ -0,0 +1,410 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+// maxIncludeDepth bounds how deep definition lookups follow includes.
+const maxIncludeDepth = 16
+
+// entryAt returns the path of the innermost key or value written at
+// offset.
+func (d *document) entryAt(offset int) (string, pkg.Span, bool) {
+	var best string
+	var span pkg.Span
+	found := false
+	for path, e := range d.source.Entries {
+		for _, sp := range []pkg.Span{e.Key, e.Value} {
+			if sp.Start <= offset && offset < sp.End && (!found || sp.End-sp.Start < span.End-span.Start) {
+				best, span, found = path, sp, true
+			}
+		}
+	}
+	return best, span, found
+}
+
+// hover shows what the schema says about the value under the cursor and,
+// for references, the value they point at.
+func (s *Server) hover(d *document, offset int) *Hover {
+	if d.doc == nil {
+		return nil
+	}
+	path, span, ok := d.entryAt(offset)
+	if !ok {
+		return nil
+	}
+
+	var parts []string
+	if path == "$schema" {
+		if p := schema.PathFor(d.path, d.doc); p != "" {
+			parts = append(parts, fmt.Sprintf("Schema: `%s`", p))
+		}
+	} else if !strings.HasPrefix(path, "$") {
+		if resolved, _, err := s.resolve(d); err == nil {
+			if sch, err := s.schemaFor(d, resolved); err == nil && sch != nil {
+				if f, ok := sch.Describe(path); ok {
+					parts = append(parts, describeField(f))
+				}
+			}
+		}
+		if v, err := d.doc.Lookup(path); err == nil {
+			if ref, ok := v.(pkg.Ref); ok {
+				if target, err := d.doc.Deref(ref); err == nil {
+					parts = append(parts, "```shon\n"+pkg.EncodeValue(target, 0, pkg.EncodeOptions{})+"\n```")
+				}
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	r := d.ix.span(span.Start, span.End)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: strings.Join(parts, "\n\n")}, Range: &r}
+}
+
+func describeField(f schema.Field) string {
+	text := fmt.Sprintf("**%s**: `%s`", f.Name, f.Type)
+	if f.Required {
+		text += " (required)"
+	}
+	if f.Description != "" {
+		text += "\n\n" + f.Description
+	}
+	return text
+}
+
+// complete suggests the schema's fields that the object around the cursor
+// does not have yet, or its namespaces at the top level. It works on the
+// text alone, so it keeps working while the document does not parse.
+func (s *Server) complete(d *document, offset int) []CompletionItem {
+	items := []CompletionItem{}
+	ctx := scanContext(d.text, offset)
+	if !ctx.keyPosition || ctx.array {
+		return items
+	}
+	path := s.schemaPath(d)
+	if path == "" {
+		return items
+	}
+	sch, err := s.loadSchema(path)
+	if err != nil {
+		return items
+	}
+
+	if ctx.path == "" {
+		at := offset > 0 && d.text[offset-1] == '@'
+		for _, ns := range sch.Namespaces() {
+			if ctx.keys[ns] {
+				continue
+			}
+			insert := "@" + ns + " {}"
+			if at {
+				insert = ns + " {}"
+			}
+			items = append(items, CompletionItem{Label: "@" + ns, Kind: CompletionModule, InsertText: insert})
+		}
+		return items
+	}
+	for _, f := range sch.Fields(ctx.path) {
+		if ctx.keys[f.Name] {
+			continue
+		}
+		detail := f.Type
+		if f.Required {
+			detail += " (required)"
+		}
+		items = append(items, CompletionItem{Label: f.Name, Kind: CompletionField, Detail: detail, Documentation: f.Description, InsertText: f.Name + ": "})
+	}
+	return items
+}
+
+var schemaMetaPattern = regexp.MustCompile(`(?m)^\s*\$schema\s*:\s*"([^"]*)"`)
+
+// schemaPath is the schema file a document declares, found by pattern when
+// the document does not parse.
+func (s *Server) schemaPath(d *document) string {
+	if d.doc != nil {
+		return schema.PathFor(d.path, d.doc)
+	}
+	m := schemaMetaPattern.FindSubmatch(d.text)
+	if m == nil {
+		return ""
+	}
+	doc := pkg.NewDocument()
+	doc.Meta.Set("schema", string(m[1]))
+	return schema.PathFor(d.path, doc)
+}
+
+// completionContext describes where in the document structure an offset
+// falls.
+type completionContext struct {
+	path        string
+	array       bool
+	keys        map[string]bool
+	keyPosition bool
+}
+
+type scanFrame struct {
+	path  string
+	array bool
+	index int
+	keys  map[string]bool
+}
+
+// scanContext tokenizes src up to offset, tracking the brackets that are
+// still open. Unlike the parser it accepts incomplete input.
+func scanContext(src []byte, offset int) completionContext {
+	if offset > len(src) {
+		offset = len(src)
+	}
+	stack := []*scanFrame{{keys: map[string]bool{}}}
+	var lastKey string
+
+	child := func() string {
+		top := stack[len(stack)-1]
+		switch {
+		case top.array:
+			return pkg.IndexPath(top.path, top.index)
+		case len(stack) == 1:
+			return lastKey
+		}
+		return pkg.JoinPath(top.path, lastKey)
+	}
+	// followedBy reports whether the next non-blank byte after i is c.
+	followedBy := func(i int, c byte) bool {
+		rest := bytes.TrimLeft(src[i:offset], " \t\r\n")
+		return len(rest) > 0 && rest[0] == c
+	}
+
+	for i := 0; i < offset; {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < offset && src[i+1] == '/':
+			for i < offset && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < offset && src[i+1] == '*':
+			end := bytes.Index(src[i+2:offset], []byte("*/"))
+			if end < 0 {
+				return completionContext{}
+			}
+			i += end + 4
+		case c == '"':
+			j := i + 1
+			for j < offset && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= offset {
+				return completionContext{}
+			}
+			if followedBy(j+1, ':') {
+				lastKey = string(src[i+1 : j])
+				stack[len(stack)-1].keys[lastKey] = true
+			}
+			i = j + 1
+		case c == '@' || c == '$' || c == '_' || isLetter(c):
+			j := i + 1
+			for j < offset && (src[j] == '_' || src[j] == '-' || isLetter(src[j]) || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+			word := string(src[i:j])
+			if c == '@' && len(stack) == 1 {
+				lastKey = word[1:]
+				stack[0].keys[lastKey] = true
+			} else if c != '@' && followedBy(j, ':') {
+				lastKey = word
+				stack[len(stack)-1].keys[word] = true
+			}
+			i = j
+		case c == '{':
+			stack = append(stack, &scanFrame{path: child(), keys: map[string]bool{}})
+			i++
+		case c == '[' || c == '(':
+			stack = append(stack, &scanFrame{path: child(), array: true, keys: map[string]bool{}})
+			i++
+		case c == '}' || c == ']' || c == ')':
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			i++
+		case c == ',':
+			if top := stack[len(stack)-1]; top.array {
+				top.index++
+			}
+			i++
+		default:
+			i++
+		}
+	}
+
+	top := stack[len(stack)-1]
+	// The cursor is at a key unless a colon follows the last separator on
+	// its line.
+	line := src[bytes.LastIndexByte(src[:offset], '\n')+1 : offset]
+	if sep := bytes.LastIndexAny(line, "{,"); sep >= 0 {
+		line = line[sep+1:]
+	}
+	return completionContext{path: top.path, array: top.array, keys: top.keys, keyPosition: !bytes.Contains(line, []byte(":"))}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// definition jumps from a reference to the value it names, in this file
+// or one it includes, and from $schema to the schema file.
+func (s *Server) definition(d *document, offset int) *Location {
+	if d.doc == nil {
+		return nil
+	}
+	path, _, ok := d.entryAt(offset)
+	if !ok {
+		return nil
+	}
+	if path == "$schema" {
+		if p := schema.PathFor(d.path, d.doc); p != "" {
+			return &Location{URI: pathToURI(p)}
+		}
+		return nil
+	}
+
+	v, err := d.doc.Lookup(path)
+	if err != nil {
+		return nil
+	}
+	ref, ok := v.(pkg.Ref)
+	if !ok {
+		return nil
+	}
+	aliases, _ := d.doc.Aliases()
+	segs, err := pkg.ParsePath(string(pkg.CanonicalRef(ref, aliases)))
+	if err != nil {
+		return nil
+	}
+	return s.findDefinition(d.path, d.text, pkg.FormatPath(segs), 0)
+}
+
+// findDefinition looks for target in the file at path, then in the files
+// it includes, later includes first since they win when merged.
+func (s *Server) findDefinition(path string, text []byte, target string, depth int) *Location {
+	doc, source, err := pkg.ParseWithSourceMap(text)
+	if err != nil {
+		return nil
+	}
+	if e, ok := source.Entries[target]; ok {
+		ix := newLineIndex(text)
+		return &Location{URI: pathToURI(path), Range: ix.span(e.Key.Start, e.Key.End)}
+	}
+	if depth >= maxIncludeDepth {
+		return nil
+	}
+	for i := len(doc.Includes) - 1; i >= 0; i-- {
+		inc := filepath.Join(filepath.Dir(path), filepath.FromSlash(doc.Includes[i]))
+		var data []byte
+		if open := s.open(inc); open != nil {
+			data = open.text
+		} else if data, err = os.ReadFile(inc); err != nil {
+			continue
+		}
+		if loc := s.findDefinition(inc, data, target, depth+1); loc != nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// symbols outlines the document: one symbol per namespace with its fields
+// nested below.
+func symbols(d *document) []DocumentSymbol {
+	out := []DocumentSymbol{}
+	for _, ns := range d.doc.Namespaces {
+		e, ok := d.source.Entries[ns.Name]
+		if !ok {
+			continue
+		}
+		out = append(out, DocumentSymbol{
+			Name:           "@" + ns.Name,
+			Kind:           SymbolNamespace,
+			Range:          d.ix.span(e.Key.Start, e.Value.End),
+			SelectionRange: d.ix.span(e.Key.Start, e.Key.End),
+			Children:       d.childSymbols(ns.Body, ns.Name),
+		})
+	}
+	return out
+}
+
+func (d *document) childSymbols(v interface{}, path string) []DocumentSymbol {
+	var out []DocumentSymbol
+	add := func(name, childPath string, child interface{}) {
+		e, ok := d.source.Entries[childPath]
+		if !ok {
+			return
+		}
+		sel := e.Key
+		if sel.Start == sel.End {
+			sel = e.Value
+		}
+		out = append(out, DocumentSymbol{
+			Name:           name,
+			Detail:         pkg.TypeName(child),
+			Kind:           symbolKind(child),
+			Range:          d.ix.span(sel.Start, e.Value.End),
+			SelectionRange: d.ix.span(sel.Start, sel.End),
+			Children:       d.childSymbols(child, childPath),
+		})
+	}
+	switch val := v.(type) {
+	case *pkg.Object:
+		for _, k := range val.Keys {
+			add(k, pkg.JoinPath(path, k), val.Values[k])
+		}
+	case []interface{}:
+		for i, item := range val {
+			add(fmt.Sprintf("[%d]", i), pkg.IndexPath(path, i), item)
+		}
+	}
+	return out
+}
+
+func symbolKind(v interface{}) int {
+	switch v.(type) {
+	case *pkg.Object:
+		return SymbolObject
+	case []interface{}:
+		return SymbolArray
+	case string, pkg.Timestamp, pkg.Secret:
+		return SymbolString
+	case json.Number, pkg.Decimal:
+		return SymbolNumber
+	case bool:
+		return SymbolBoolean
+	case nil:
+		return SymbolNull
+	}
+	return SymbolField
+}
+
+// format re-indents the whole document with the formatter shon format
+// uses.
+func format(d *document, tabSize int) []TextEdit {
+	if tabSize <= 0 {
+		tabSize = 4
+	}
+	formatted := strings.TrimRight(pkg.FormatSource(d.text, tabSize, false), "\n") + "\n"
+	if formatted == string(d.text) {
+		return []TextEdit{}
+	}
+	return []TextEdit{{Range: d.ix.span(0, len(d.text)), NewText: formatted}}
+}
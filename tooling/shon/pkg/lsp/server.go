@@ -0,0 +1,459 @@
+// Package lsp implements a Language Server Protocol server for SHON and
+// .shos schema files, speaking JSON-RPC over a pair of streams.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+// Server answers LSP requests for the documents a client has open. Open
+// documents take precedence over the files on disk, so includes and
+// schemas reflect unsaved edits.
+type Server struct {
+	// Project supplies the include roots documents are loaded with, as
+	// the command line uses them. Without one, a document's includes may
+	// not leave its directory.
+	Project *pkg.ProjectConfig
+	// Limits bounds the documents the server parses and resolves. The
+	// zero value limits nothing.
+	Limits pkg.Limits
+
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+// document is an open buffer and what was last learned from it.
+type document struct {
+	uri  string
+	path string
+	text []byte
+	ix   *lineIndex
+
+	doc    *pkg.Document // as written, nil if it does not parse
+	source *pkg.SourceMap
+	err    error
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+}
+
+// Run serves requests until the client sends exit or closes the input.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.readMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			var rpcErr *rpcError
+			if errors.As(err, &rpcErr) {
+				if err := s.reply(nil, nil, rpcErr); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		if msg.Method == "" {
+			// A response to a request we never send.
+			continue
+		}
+
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		var rpcErr *rpcError
+		if err != nil && !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		if err := s.reply(msg.ID, result, rpcErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) readMessage() (*message, error) {
+	return readMessage(s.in)
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rpcErr *rpcError) error {
+	msg := &message{ID: id, Error: rpcErr}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg.Result = data
+	}
+	return writeMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+// handle dispatches one request or notification. Unknown notifications are
+// ignored; unknown requests are an error.
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := decodeParams(msg, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := decodeParams(msg, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// Full sync: the last change holds the whole text.
+		return nil, s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := decodeParams(msg, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		d, err := s.positionParams(msg, &p)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return s.hover(d, d.ix.offset(p.Position)), nil
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		d, err := s.positionParams(msg, &p)
+		if err != nil || d == nil {
+			return []CompletionItem{}, err
+		}
+		return s.complete(d, d.ix.offset(p.Position)), nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		d, err := s.positionParams(msg, &p)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return s.definition(d, d.ix.offset(p.Position)), nil
+	case "textDocument/documentSymbol":
+		var p struct {
+			TextDocument TextDocumentIdentifier `json:"textDocument"`
+		}
+		if err := decodeParams(msg, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil || d.doc == nil {
+			return []DocumentSymbol{}, nil
+		}
+		return symbols(d), nil
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		if err := decodeParams(msg, &p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return []TextEdit{}, nil
+		}
+		return format(d, p.Options.TabSize), nil
+	}
+
+	if msg.ID != nil {
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s is not supported", msg.Method)}
+	}
+	return nil, nil
+}
+
+func (s *Server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":           1,
+			"hoverProvider":              true,
+			"completionProvider":         map[string]interface{}{"triggerCharacters": []string{"{", ",", "@"}},
+			"definitionProvider":         true,
+			"documentSymbolProvider":     true,
+			"documentFormattingProvider": true,
+		},
+		"serverInfo": map[string]string{"name": "shon", "version": pkg.CurrentVersion},
+	}
+}
+
+func decodeParams(msg *message, v interface{}) error {
+	if err := json.Unmarshal(msg.Params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) positionParams(msg *message, p *TextDocumentPositionParams) (*document, error) {
+	if err := decodeParams(msg, p); err != nil {
+		return nil, err
+	}
+	return s.docs[p.TextDocument.URI], nil
+}
+
+// update stores new text for a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	d := &document{uri: uri, path: uriToPath(uri), text: []byte(text)}
+	d.ix = newLineIndex(d.text)
+	d.doc, d.source, d.err = pkg.ParseWithSourceMapLimits(d.text, s.Limits)
+	s.docs[uri] = d
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: s.diagnose(d)})
+}
+
+// diagnose reports syntax errors, include, alias and constant problems and,
+// for documents with a $schema, validation failures. Schema files are
+// compiled instead.
+func (s *Server) diagnose(d *document) []Diagnostic {
+	diags := []Diagnostic{}
+	if d.err != nil {
+		return append(diags, d.errorDiagnostic(d.err))
+	}
+
+	resolved, warnings, err := s.resolve(d)
+	if err != nil {
+		return append(diags, d.errorDiagnostic(err))
+	}
+	for _, w := range warnings {
+		diags = append(diags, d.diagnostic(w))
+	}
+	if pkg.HasErrors(warnings) {
+		return diags
+	}
+
+	if strings.HasSuffix(d.path, ".shos") {
+		if _, err := schema.CompileFile(resolved, d.path); err != nil {
+			diags = append(diags, d.schemaErrorDiagnostic(err))
+		}
+		return diags
+	}
+
+	sch, err := s.schemaFor(d, resolved)
+	if err != nil {
+		return append(diags, Diagnostic{Range: d.pathRange("$schema"), Severity: SeverityError, Source: "shon", Message: err.Error()})
+	}
+	if sch != nil {
+		for _, diag := range sch.Validate(resolved) {
+			diags = append(diags, d.diagnostic(diag))
+		}
+	}
+	return diags
+}
+
+// resolve loads d with its includes, reading open documents from their
+// buffers, and resolves aliases and constants. Unlike pkg.Resolve it
+// returns every diagnostic instead of folding errors into one.
+func (s *Server) resolve(d *document) (*pkg.Document, []pkg.Diagnostic, error) {
+	doc, err := s.load(d.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc, diags := pkg.ResolveAliases(doc)
+	doc, constDiags, err := pkg.ResolveConstantsWithLimits(doc, s.Limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, append(diags, constDiags...), nil
+}
+
+// load reads the file at path with its includes, rooted at the project's
+// include root for it or else at its directory.
+func (s *Server) load(path string) (*pkg.Document, error) {
+	root := ""
+	if s.Project != nil {
+		root = s.Project.IncludeRoot(path)
+	}
+	if root == "" {
+		root = filepath.Dir(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := os.OpenRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	l := pkg.NewLoader(&overlayFS{FS: r.FS(), root: root, server: s})
+	l.Limits = s.Limits
+	return l.Load(filepath.ToSlash(rel))
+}
+
+// schemaFor loads the schema a document declares, or returns nil if it
+// declares none.
+func (s *Server) schemaFor(d *document, doc *pkg.Document) (*schema.Schema, error) {
+	path := schema.PathFor(d.path, doc)
+	if path == "" {
+		return nil, nil
+	}
+	return s.loadSchema(path)
+}
+
+// loadSchema compiles the schema file at path, from its buffer if it is
+// open.
+func (s *Server) loadSchema(path string) (*schema.Schema, error) {
+	if s.open(path) == nil {
+		return schema.LoadFile(path)
+	}
+	sdoc, err := s.load(path)
+	if err != nil {
+		return nil, err
+	}
+	sdoc, _, err = pkg.ResolveWithLimits(sdoc, s.Limits)
+	if err != nil {
+		return nil, err
+	}
+	return schema.CompileFile(sdoc, path)
+}
+
+// open returns the open document for a file, or nil.
+func (s *Server) open(path string) *document {
+	path = filepath.Clean(path)
+	for _, d := range s.docs {
+		if d.path == path {
+			return d
+		}
+	}
+	return nil
+}
+
+// overlayFS serves open documents from their buffers and everything else
+// from disk.
+type overlayFS struct {
+	fs.FS
+	root   string
+	server *Server
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	if d := o.server.open(filepath.Join(o.root, filepath.FromSlash(name))); d != nil {
+		return d.text, nil
+	}
+	return fs.ReadFile(o.FS, name)
+}
+
+// diagnostic converts a pkg.Diagnostic, placing it on the value its path
+// names.
+func (d *document) diagnostic(diag pkg.Diagnostic) Diagnostic {
+	severity := SeverityError
+	if diag.Severity == pkg.SeverityWarning {
+		severity = SeverityWarning
+	}
+	return Diagnostic{Range: d.pathRange(diag.Path), Severity: severity, Source: "shon", Message: diag.Message}
+}
+
+// errorDiagnostic places an error at its line and column when it is a
+// syntax error in this document, and at the top of the file otherwise.
+func (d *document) errorDiagnostic(err error) Diagnostic {
+	var syntax *pkg.SyntaxError
+	if err == d.err && errors.As(err, &syntax) {
+		// The parser counts columns in bytes, LSP in UTF-16 code units.
+		start := d.ix.byteOffset(syntax.Line-1, syntax.Col-1)
+		end := start
+		if end < len(d.text) && d.text[end] != '\n' {
+			_, size := utf8.DecodeRune(d.text[end:])
+			end += size
+		}
+		return Diagnostic{Range: d.ix.span(start, end), Severity: SeverityError, Source: "shon", Message: syntax.Msg}
+	}
+	return Diagnostic{Severity: SeverityError, Source: "shon", Message: err.Error()}
+}
+
+// schemaErrorDiagnostic places a schema compile error, which reads
+// "file.shos: path: message", on the path it names.
+func (d *document) schemaErrorDiagnostic(err error) Diagnostic {
+	msg := strings.TrimPrefix(err.Error(), filepath.Base(d.path)+": ")
+	r := Range{}
+	if i := strings.Index(msg, ": "); i > 0 {
+		if _, ok := d.source.Entries[msg[:i]]; ok {
+			r = d.pathRange(msg[:i])
+		}
+	}
+	return Diagnostic{Range: r, Severity: SeverityError, Source: "shon", Message: msg}
+}
+
+// pathRange is the range of the key a path is written under, or of the
+// value for array items. Unknown paths map to the top of the file.
+func (d *document) pathRange(path string) Range {
+	e, ok := d.source.Entries[path]
+	if !ok {
+		return Range{}
+	}
+	if e.Key.Start < e.Key.End {
+		return d.ix.span(e.Key.Start, e.Key.End)
+	}
+	return d.ix.span(e.Value.Start, e.Value.End)
+}
+
+// uriToPath converts a file:// URI into a local path. Other URIs are
+// returned unchanged.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	p := u.Path
+	if runtime.GOOS == "windows" {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return filepath.Clean(filepath.FromSlash(p))
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	p := filepath.ToSlash(path)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
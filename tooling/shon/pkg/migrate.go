@@ -0,0 +1,511 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// MigrationsNamespace is the reserved schema namespace listing the steps
+// that upgrade documents from one schema version to the next:
+//
+//	$version: "2"
+//
+//	@migrations {
+//	    steps: [
+//	        { from: "1", to: "2", ops: [
+//	            { op: "rename", path: "user.name", to: "fullName" },
+//	            { op: "convert", path: "user.balance", type: "decimal" }
+//	        ] }
+//	    ]
+//	}
+const MigrationsNamespace = "migrations"
+
+// Migration upgrades documents from schema version From to version To.
+type Migration struct {
+	From, To string
+	Ops      []MigrationOp
+}
+
+// MigrationOp is one step of a migration. Paths use SHON path syntax and
+// may contain "[*]" to apply the step to every item of an array, e.g.
+// "users[*].name".
+//
+//	rename   path, to          renames the field at path to the key to
+//	move     from, path        moves a field, keeping its comments
+//	convert  path, type        converts a value to string, integer, number,
+//	                           decimal, boolean, timestamp or ref
+//	default  path, value       adds the field if it is missing
+//	split    path, into, sep   splits a string field into sibling fields
+//	merge    from, path, sep   joins sibling fields into the field at path
+//	remove   path              deletes a field
+type MigrationOp struct {
+	Op        string
+	Path      string
+	From      string
+	To        string
+	Type      string
+	Value     interface{}
+	Fields    []string
+	Separator string
+}
+
+// MigrationsFromDocument reads the steps of a schema's @migrations
+// namespace. A document without one has no migrations.
+func MigrationsFromDocument(doc *Document) ([]*Migration, error) {
+	ns := doc.Namespace(MigrationsNamespace)
+	if ns == nil {
+		return nil, nil
+	}
+	raw, ok := ns.Body.Values["steps"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("@%s must contain a steps array", MigrationsNamespace)
+	}
+
+	var steps []*Migration
+	for i, item := range raw {
+		obj, ok := item.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("migration %d: expected object, found %s", i, TypeName(item))
+		}
+		m := &Migration{From: versionText(obj.Values["from"]), To: versionText(obj.Values["to"])}
+		if m.From == "" || m.To == "" {
+			return nil, fmt.Errorf("migration %d: from and to versions are required", i)
+		}
+		ops, _ := obj.Values["ops"].([]interface{})
+		for j, rawOp := range ops {
+			op, err := parseMigrationOp(rawOp)
+			if err != nil {
+				return nil, fmt.Errorf("migration %s -> %s, op %d: %w", m.From, m.To, j, err)
+			}
+			m.Ops = append(m.Ops, op)
+		}
+		steps = append(steps, m)
+	}
+	return steps, nil
+}
+
+func parseMigrationOp(v interface{}) (MigrationOp, error) {
+	obj, ok := v.(*Object)
+	if !ok {
+		return MigrationOp{}, fmt.Errorf("expected object, found %s", TypeName(v))
+	}
+	op := MigrationOp{}
+	op.Op, _ = obj.Values["op"].(string)
+	op.Path, _ = obj.Values["path"].(string)
+	op.To, _ = obj.Values["to"].(string)
+	op.Type, _ = obj.Values["type"].(string)
+	op.Separator, _ = obj.Values["separator"].(string)
+	value, hasValue := obj.Get("value")
+	op.Value = value
+
+	switch op.Op {
+	case "rename":
+		if op.To == "" {
+			return op, fmt.Errorf("\"rename\" requires to")
+		}
+	case "move":
+		op.From, _ = obj.Values["from"].(string)
+		if op.From == "" {
+			return op, fmt.Errorf("\"move\" requires from")
+		}
+		if strings.Contains(op.From+op.Path, "[*]") {
+			return op, fmt.Errorf("\"move\" does not support [*]")
+		}
+	case "convert":
+		if !convertible[op.Type] {
+			return op, fmt.Errorf("cannot convert to %q", op.Type)
+		}
+	case "default":
+		if !hasValue {
+			return op, fmt.Errorf("\"default\" requires a value")
+		}
+	case "split", "merge":
+		key := "into"
+		if op.Op == "merge" {
+			key = "from"
+		}
+		fields, ok := obj.Values[key].([]interface{})
+		if !ok || len(fields) == 0 {
+			return op, fmt.Errorf("%q requires a list of fields in %s", op.Op, key)
+		}
+		for _, f := range fields {
+			s, ok := f.(string)
+			if !ok || s == "" {
+				return op, fmt.Errorf("%q: %s must list field names", op.Op, key)
+			}
+			op.Fields = append(op.Fields, s)
+		}
+		if _, ok := obj.Get("separator"); !ok {
+			op.Separator = " "
+		}
+	case "remove":
+	case "":
+		return op, fmt.Errorf("missing op")
+	default:
+		return op, fmt.Errorf("unknown op %q", op.Op)
+	}
+	if op.Path == "" {
+		return op, fmt.Errorf("missing path")
+	}
+	return op, nil
+}
+
+// versionText accepts versions written as strings or numbers.
+func versionText(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return string(val)
+	case Decimal:
+		return string(val)
+	}
+	return ""
+}
+
+// DocumentVersion returns the schema version a document declares in
+// $version, or "" if it declares none.
+func DocumentVersion(doc *Document) string {
+	v, _ := doc.Meta.Get("version")
+	return versionText(v)
+}
+
+// MigrationPath returns the steps that lead from version from to version
+// to, in order.
+func MigrationPath(steps []*Migration, from, to string) ([]*Migration, error) {
+	var path []*Migration
+	seen := map[string]bool{}
+	for v := from; v != to; {
+		if seen[v] {
+			return nil, fmt.Errorf("migrations loop at version %s", v)
+		}
+		seen[v] = true
+		var next *Migration
+		for _, m := range steps {
+			if m.From == v {
+				next = m
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("no migration from version %s towards %s", v, to)
+		}
+		path = append(path, next)
+		v = next.To
+	}
+	return path, nil
+}
+
+// MigrateSource applies the migrations to SHON source in order and sets
+// the document's $version to the last one's target. The source is edited
+// in place, so comments and formatting outside the changed fields are
+// kept.
+func MigrateSource(src []byte, steps []*Migration) ([]byte, error) {
+	for _, m := range steps {
+		for i, op := range m.Ops {
+			var err error
+			if src, err = applyMigrationOp(src, op); err != nil {
+				return nil, fmt.Errorf("migration %s -> %s, op %d (%s %s): %w", m.From, m.To, i, op.Op, op.Path, err)
+			}
+		}
+		var err error
+		if src, err = SetMetaSource(src, "version", m.To); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func applyMigrationOp(src []byte, op MigrationOp) ([]byte, error) {
+	if op.Op == "move" {
+		return moveSource(src, op.From, op.Path)
+	}
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := expandWildcards(doc, op.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if src, err = applyMigrationAt(src, op, path); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// applyMigrationAt applies op at one concrete path. Fields the op needs
+// that a document does not have are skipped, so a migration also runs on
+// documents that never used them.
+func applyMigrationAt(src []byte, op MigrationOp, path string) ([]byte, error) {
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	parent, key := splitPath(path)
+	value, lookupErr := doc.Lookup(path)
+
+	switch op.Op {
+	case "rename":
+		if lookupErr != nil {
+			return src, nil
+		}
+		if _, err := doc.Lookup(JoinPath(parent, op.To)); err == nil {
+			return nil, fmt.Errorf("%s already exists", JoinPath(parent, op.To))
+		}
+		return RenameSource(src, path, op.To)
+	case "remove":
+		if lookupErr != nil {
+			return src, nil
+		}
+		return RemoveSource(src, path)
+	case "convert":
+		if lookupErr != nil {
+			return src, nil
+		}
+		converted, err := ConvertValue(value, op.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return SetSource(src, path, converted)
+	case "default":
+		if lookupErr == nil {
+			return src, nil
+		}
+		if _, err := doc.Lookup(parent); err != nil {
+			return src, nil
+		}
+		return InsertSource(src, parent, key, Clone(op.Value))
+	case "split":
+		if lookupErr != nil {
+			return src, nil
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot split %s", TypeName(value))
+		}
+		parts := strings.SplitN(s, op.Separator, len(op.Fields))
+		// New fields take the split field's place and comments; fields
+		// that already exist are set where they are.
+		fields, existing := NewObject(), NewObject()
+		for i, field := range op.Fields {
+			part := ""
+			if i < len(parts) {
+				part = parts[i]
+			}
+			if _, err := doc.Lookup(JoinPath(parent, field)); err == nil && field != key {
+				existing.Set(field, part)
+			} else {
+				fields.Set(field, part)
+			}
+		}
+		if fields.Len() > 0 {
+			src, err = ReplaceFieldSource(src, path, fields)
+		} else {
+			src, _, err = removeFieldAndComments(src, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range existing.Keys {
+			if src, err = SetSource(src, JoinPath(parent, field), existing.Values[field]); err != nil {
+				return nil, err
+			}
+		}
+		return src, nil
+	case "merge":
+		// The merged field replaces the field it is written to or else
+		// the first merged one, and takes the comments of the others.
+		var parts, present []string
+		for _, field := range op.Fields {
+			v, err := doc.Lookup(JoinPath(parent, field))
+			if err != nil {
+				continue
+			}
+			s, ok := scalarText(v)
+			if !ok {
+				s = EncodeValue(v, 0, EncodeOptions{})
+			}
+			parts = append(parts, s)
+			present = append(present, field)
+		}
+		if len(parts) == 0 {
+			return src, nil
+		}
+		anchor := present[0]
+		if lookupErr == nil {
+			anchor = key
+		}
+		var comments []string
+		for _, field := range present {
+			if field == anchor {
+				continue
+			}
+			var moved []string
+			if src, moved, err = removeFieldAndComments(src, JoinPath(parent, field)); err != nil {
+				return nil, err
+			}
+			comments = append(comments, moved...)
+		}
+		merged := NewObject()
+		merged.Set(key, strings.Join(parts, op.Separator))
+		return replaceField(src, JoinPath(parent, anchor), merged, comments)
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// moveSource moves a field, copying its source text so comments inside the
+// value survive, re-indented for its new place.
+func moveSource(src []byte, from, to string) ([]byte, error) {
+	doc, m, err := ParseWithSourceMap(src)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := doc.Lookup(from); err != nil {
+		return src, nil
+	}
+	if strings.HasPrefix(to, from+".") || strings.HasPrefix(to, from+"[") {
+		return nil, fmt.Errorf("cannot move %s into itself", from)
+	}
+	if _, err := doc.Lookup(to); err == nil {
+		return nil, fmt.Errorf("%s already exists", to)
+	}
+	e, err := m.sourceEntry(from)
+	if err != nil {
+		return nil, err
+	}
+	raw := string(src[e.Value.Start:e.Value.End])
+	fromLevel := m.level(e.Key.Start)
+
+	if src, err = RemoveSource(src, from); err != nil {
+		return nil, err
+	}
+	parent, key := splitPath(to)
+	return insertSource(src, parent, key, func(level, unit int) string {
+		return reindent(raw, (level-fromLevel)*unit)
+	})
+}
+
+// reindent shifts every line but the first of text by delta spaces.
+func reindent(text string, delta int) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if delta > 0 {
+			lines[i] = strings.Repeat(" ", delta) + lines[i]
+		} else if delta < 0 {
+			trimmed := strings.TrimLeft(lines[i], " ")
+			cut := len(lines[i]) - len(trimmed)
+			if cut > -delta {
+				cut = -delta
+			}
+			lines[i] = lines[i][cut:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitPath splits a path into its parent path and last key.
+func splitPath(path string) (string, string) {
+	i := strings.LastIndexByte(path, '.')
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+// expandWildcards replaces each "[*]" in path with the indexes of the
+// array found there.
+func expandWildcards(doc *Document, path string) ([]string, error) {
+	i := strings.Index(path, "[*]")
+	if i < 0 {
+		return []string{path}, nil
+	}
+	v, err := doc.Lookup(path[:i])
+	if err != nil {
+		return nil, nil
+	}
+	items, ok := listItems(v)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an array", path[:i])
+	}
+	var paths []string
+	for n := range items {
+		expanded, err := expandWildcards(doc, IndexPath(path[:i], n)+path[i+3:])
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, expanded...)
+	}
+	return paths, nil
+}
+
+// convertible lists the types a migration can convert values to.
+var convertible = map[string]bool{
+	"string": true, "integer": true, "number": true, "float": true, "decimal": true,
+	"boolean": true, "bool": true, "timestamp": true, "ref": true,
+}
+
+// ConvertValue converts a scalar value to another SHON type, as a
+// migration's convert op does.
+func ConvertValue(v interface{}, typ string) (interface{}, error) {
+	text, isText := scalarText(v)
+	if b, ok := v.(bool); ok {
+		text, isText = fmt.Sprint(b), true
+	}
+	if !isText {
+		return nil, fmt.Errorf("cannot convert %s to %s", TypeName(v), typ)
+	}
+
+	switch typ {
+	case "string":
+		if r, ok := v.(Ref); ok {
+			return "&" + string(r), nil
+		}
+		return text, nil
+	case "integer", "number", "float", "decimal":
+		// big.Rat also reads fractions such as "1/2", which are not SHON
+		// numbers, so the text must look like a number or decimal first.
+		text = strings.TrimSpace(text)
+		if !jsonNumberPattern.MatchString(text) && !decimalPattern.MatchString(text) {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		switch typ {
+		case "integer":
+			r, ok := new(big.Rat).SetString(text)
+			if !ok || !r.IsInt() {
+				return nil, fmt.Errorf("%s is not an integer", text)
+			}
+			return json.Number(r.Num().String()), nil
+		case "decimal":
+			if !decimalPattern.MatchString(text) {
+				return nil, fmt.Errorf("%s is not a decimal; decimals have no exponent", text)
+			}
+			return Decimal(text), nil
+		}
+		if !jsonNumberPattern.MatchString(text) {
+			return nil, fmt.Errorf("%s is not a JSON number", text)
+		}
+		return json.Number(text), nil
+	case "boolean", "bool":
+		switch strings.ToLower(text) {
+		case "true", "1", "yes":
+			return true, nil
+		case "false", "0", "no":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%q is not a boolean", text)
+	case "timestamp":
+		if _, err := time.Parse(time.RFC3339Nano, text); err != nil {
+			return nil, fmt.Errorf("%q is not an RFC 3339 timestamp", text)
+		}
+		return Timestamp(text), nil
+	case "ref":
+		return Ref(strings.TrimPrefix(text, "&")), nil
+	}
+	return nil, fmt.Errorf("cannot convert to %q", typ)
+}
@@ -0,0 +1,758 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SyntaxError describes a malformed SHON document. Line and Col are 1-based.
+type SyntaxError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Msg)
+}
+
+var decimalPattern = regexp.MustCompile(`^[-+]?(\d+(\.\d*)?|\.\d+)$`)
+
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+type parser struct {
+	src []byte
+	pos int
+	// bareIdents lets identifiers stand for strings, as in @alias { addr: address }.
+	bareIdents bool
+	// spans, if set, receives the location of every value, keyed by path.
+	spans map[string]SourceEntry
+	// duplicates, if set, receives earlier locations of paths written
+	// twice; otherwise a key written twice is an error.
+	duplicates map[string][]SourceEntry
+	// comments receives the span of every comment when spans is set.
+	comments []Span
+	// commentErr is set when the source ends inside a block comment.
+	commentErr error
+	path       string
+	// limits bounds the document; depth and keys count towards them.
+	limits Limits
+	depth  int
+	keys   int
+}
+
+// ParseFile reads and parses a SHON file.
+func ParseFile(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SHON file: %w", err)
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// Parse parses SHON source into a Document.
+func Parse(data []byte) (*Document, error) {
+	return ParseWithLimits(data, Limits{})
+}
+
+// ParseWithLimits parses SHON source like Parse, returning a LimitError if
+// the source exceeds limits.
+func ParseWithLimits(data []byte, limits Limits) (*Document, error) {
+	if exceeds(len(data), limits.MaxSize) {
+		return nil, &LimitError{Limit: "MaxSize", Max: limits.MaxSize}
+	}
+	p := &parser{src: data, limits: limits}
+	return p.document()
+}
+
+// ParseValue parses a single SHON value such as `$decimal("1.5")` or
+// `{ a: 1 }`.
+func ParseValue(data []byte) (interface{}, error) {
+	p := &parser{src: data}
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.commentErr != nil {
+		return nil, p.commentErr
+	}
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q after value", p.peek())
+	}
+	return v, nil
+}
+
+func (p *parser) document() (*Document, error) {
+	doc := NewDocument()
+	seen := map[string]bool{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			if p.commentErr != nil {
+				return nil, p.commentErr
+			}
+			return doc, nil
+		}
+		switch p.peek() {
+		case '$':
+			keyStart := p.pos
+			p.pos++
+			key := p.ident()
+			if key == "" {
+				return nil, p.errorf("expected metadata name after '$'")
+			}
+			keyEnd := p.pos
+			if err := p.countKey(); err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Meta.Get(key); ok && p.duplicates == nil {
+				p.pos = keyStart
+				return nil, p.errorf("duplicate metadata $%s", key)
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			valueStart := p.pos
+			p.path = "$" + key
+			v, err := p.value()
+			p.path = ""
+			if err != nil {
+				return nil, err
+			}
+			p.record("$"+key, keyStart, keyEnd, valueStart)
+			doc.Meta.Set(key, v)
+		case '@':
+			nameStart := p.pos
+			p.pos++
+			name := p.ident()
+			nameEnd := p.pos
+			if name == "" {
+				return nil, p.errorf("expected namespace name after '@'")
+			}
+			if name == "include" {
+				p.skipSpace()
+				if p.eof() || p.peek() != '"' {
+					return nil, p.errorf("@include expects a quoted path")
+				}
+				path, err := p.string()
+				if err != nil {
+					return nil, err
+				}
+				doc.Includes = append(doc.Includes, path)
+				break
+			}
+			if seen[name] {
+				return nil, p.errorf("duplicate namespace %q", name)
+			}
+			seen[name] = true
+			if err := p.countKey(); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			bodyStart := p.pos
+			p.bareIdents = name == AliasNamespace
+			p.path = name
+			body, err := p.object()
+			p.bareIdents = false
+			p.path = ""
+			if err != nil {
+				return nil, err
+			}
+			p.record(name, nameStart, nameEnd, bodyStart)
+			doc.Namespaces = append(doc.Namespaces, &Namespace{Name: name, Body: body})
+		default:
+			return nil, p.errorf("expected '@namespace' or '$metadata', found %q", p.peek())
+		}
+		p.skipSpace()
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) object() (*Object, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	obj := NewObject()
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unterminated object")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return obj, nil
+		}
+		keyStart := p.pos
+		quoted := p.peek() == '"'
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		keyEnd := p.pos
+		if err := p.countKey(); err != nil {
+			return nil, err
+		}
+		meta := strings.HasPrefix(key, "$") && !quoted
+		if p.duplicates == nil {
+			var dup bool
+			if meta {
+				_, dup = obj.GetMeta(key[1:])
+			} else {
+				_, dup = obj.Get(key)
+			}
+			if dup {
+				p.pos = keyStart
+				return nil, p.errorf("duplicate key %q", key)
+			}
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		valueStart := p.pos
+		base := p.path
+		p.path = JoinPath(base, key)
+		v, err := p.value()
+		p.path = base
+		if err != nil {
+			return nil, err
+		}
+		p.record(JoinPath(base, key), keyStart, keyEnd, valueStart)
+		if meta {
+			obj.SetMeta(key[1:], v)
+		} else {
+			obj.Set(key, v)
+		}
+		p.skipSpace()
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) key() (string, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		return p.string()
+	case c == '$':
+		p.pos++
+		name := p.ident()
+		if name == "" {
+			return "", p.errorf("expected key after '$'")
+		}
+		return "$" + name, nil
+	case isIdentStart(c):
+		start := p.pos
+		key := p.ident()
+		if exceeds(len(key), p.limits.MaxStringLength) {
+			p.pos = start
+			return "", p.limitf("MaxStringLength", p.limits.MaxStringLength)
+		}
+		return key, nil
+	default:
+		return "", p.errorf("expected key, found %q", c)
+	}
+}
+
+func (p *parser) value() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("unexpected end of input, expected value")
+	}
+	switch c := p.peek(); {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		return p.string()
+	case c == '&':
+		return p.ref()
+	case c == '$':
+		return p.typed()
+	case c == '-' || c == '+' || isDigit(c):
+		return p.number()
+	case isIdentStart(c):
+		start := p.pos
+		name := p.ident()
+		switch name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if p.bareIdents {
+			return name, nil
+		}
+		p.skipSpace()
+		if !p.eof() && p.peek() == '(' {
+			items, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return &Tuple{Name: name, Items: items}, nil
+		}
+		p.pos = start
+		return nil, p.errorf("unexpected identifier %q", name)
+	default:
+		return nil, p.errorf("unexpected %q, expected value", c)
+	}
+}
+
+func (p *parser) array() ([]interface{}, error) {
+	p.pos++ // '['
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	items := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+		v, err := p.item(len(items))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.skipSpace()
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+// item parses the value at index i of an array or argument list.
+func (p *parser) item(i int) (interface{}, error) {
+	base := p.path
+	p.path = IndexPath(base, i)
+	start := p.pos
+	v, err := p.value()
+	p.path = base
+	if err == nil {
+		p.record(IndexPath(base, i), start, start, start)
+	}
+	return v, err
+}
+
+// record notes where the value just parsed at path was written, if the
+// caller asked for a source map.
+func (p *parser) record(path string, keyStart, keyEnd, valueStart int) {
+	if p.spans != nil {
+		if prev, ok := p.spans[path]; ok {
+			p.duplicates[path] = append(p.duplicates[path], prev)
+		}
+		p.spans[path] = SourceEntry{Key: Span{keyStart, keyEnd}, Value: Span{valueStart, p.pos}}
+	}
+}
+
+// args parses a parenthesised, comma separated value list.
+func (p *parser) args() ([]interface{}, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	items := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unterminated argument list")
+		}
+		if p.peek() == ')' {
+			p.pos++
+			return items, nil
+		}
+		v, err := p.item(len(items))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.skipSpace()
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+// typed parses $decimal(...), $timestamp(...), $secret(...), $env(...),
+// $expr(...) and $tuple(...).
+func (p *parser) typed() (interface{}, error) {
+	start := p.pos
+	p.pos++ // '$'
+	name := p.ident()
+	p.skipSpace()
+	if p.eof() || p.peek() != '(' {
+		p.pos = start
+		return nil, p.errorf("expected '(' after $%s", name)
+	}
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "tuple":
+		return &Tuple{Items: args}, nil
+	case "decimal":
+		if len(args) != 1 {
+			p.pos = start
+			return nil, p.errorf("$decimal takes exactly one argument")
+		}
+		var lit string
+		switch a := args[0].(type) {
+		case string:
+			lit = a
+		case json.Number:
+			lit = string(a)
+		default:
+			p.pos = start
+			return nil, p.errorf("$decimal argument must be a string")
+		}
+		if !decimalPattern.MatchString(lit) {
+			p.pos = start
+			return nil, p.errorf("invalid decimal %q", lit)
+		}
+		return Decimal(lit), nil
+	case "timestamp":
+		s, ok := singleString(args)
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("$timestamp takes exactly one string argument")
+		}
+		return Timestamp(s), nil
+	case "secret":
+		s, ok := singleString(args)
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("$secret takes exactly one string argument")
+		}
+		if !validSecret(s) {
+			p.pos = start
+			return nil, p.errorf("invalid secret: expected base64 AES-GCM ciphertext")
+		}
+		return Secret(s), nil
+	case "env":
+		name, ok := "", len(args) == 1 || len(args) == 2
+		if ok {
+			name, ok = args[0].(string)
+		}
+		if !ok || name == "" {
+			p.pos = start
+			return nil, p.errorf("$env takes a variable name and an optional default")
+		}
+		env := &Env{Name: name}
+		if len(args) == 2 {
+			env.Default, env.HasDefault = args[1], true
+		}
+		return env, nil
+	case "expr":
+		s, ok := singleString(args)
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("$expr takes exactly one string argument")
+		}
+		e, err := ParseExpr(s)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid expression %q: %v", s, err)
+		}
+		return e, nil
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown type $%s", name)
+	}
+}
+
+func singleString(args []interface{}) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+	s, ok := args[0].(string)
+	return s, ok
+}
+
+func (p *parser) ref() (Ref, error) {
+	p.pos++ // '&'
+	start := p.pos
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		if c == '[' {
+			depth++
+		} else if c == ']' && depth > 0 {
+			depth--
+		} else if depth == 0 && !isIdentChar(c) && c != '.' && c != '-' {
+			break
+		}
+		p.pos++
+	}
+	if exceeds(p.pos-start, p.limits.MaxStringLength) {
+		p.pos = start
+		return "", p.limitf("MaxStringLength", p.limits.MaxStringLength)
+	}
+	path := string(p.src[start:p.pos])
+	if path == "" || depth != 0 {
+		return "", p.errorf("invalid reference")
+	}
+	return Ref(path), nil
+}
+
+// string parses a double-quoted string. Escapes follow JSON, except that an
+// unknown escape such as \d is kept literally so regular expressions can be
+// written without doubling every backslash.
+func (p *parser) string() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			p.pos = start
+			return "", p.errorf("unterminated string")
+		}
+		if exceeds(sb.Len(), p.limits.MaxStringLength) {
+			p.pos = start
+			return "", p.limitf("MaxStringLength", p.limits.MaxStringLength)
+		}
+		c := p.peek()
+		p.pos++
+		if c == '"' {
+			return sb.String(), nil
+		}
+		if c != '\\' {
+			sb.WriteByte(c)
+			continue
+		}
+		if p.eof() {
+			continue
+		}
+		e := p.peek()
+		p.pos++
+		switch e {
+		case '"', '\\', '/':
+			sb.WriteByte(e)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'u':
+			r, ok := p.hex4(p.pos)
+			if !ok {
+				p.pos = start
+				return "", p.errorf("invalid unicode escape")
+			}
+			p.pos += 4
+			// Characters outside the Basic Multilingual Plane are written
+			// as a UTF-16 surrogate pair, \ud83d\ude00.
+			if utf16.IsSurrogate(r) && p.pos+2 <= len(p.src) && p.src[p.pos] == '\\' && p.src[p.pos+1] == 'u' {
+				if low, ok := p.hex4(p.pos + 2); ok {
+					if pair := utf16.DecodeRune(r, low); pair != utf8.RuneError {
+						r = pair
+						p.pos += 6
+					}
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(e)
+		}
+	}
+}
+
+// hex4 reads the four hex digits of a \u escape at offset i.
+func (p *parser) hex4(i int) (rune, bool) {
+	if i+4 > len(p.src) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(p.src[i:i+4]), 16, 16)
+	return rune(n), err == nil
+}
+
+func (p *parser) number() (json.Number, error) {
+	start := p.pos
+	if c := p.peek(); c == '-' || c == '+' {
+		p.pos++
+	}
+	for !p.eof() {
+		c := p.peek()
+		if isDigit(c) || c == '.' || c == 'e' || c == 'E' || ((c == '-' || c == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	lit := strings.TrimPrefix(string(p.src[start:p.pos]), "+")
+	if !json.Valid([]byte(lit)) {
+		p.pos = start
+		return "", p.errorf("invalid number")
+	}
+	return json.Number(lit), nil
+}
+
+func (p *parser) ident() string {
+	start := p.pos
+	if p.eof() || !isIdentStart(p.peek()) {
+		return ""
+	}
+	for !p.eof() && isIdentChar(p.peek()) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.eof() {
+		return p.errorf("unexpected end of input, expected %q", c)
+	}
+	if p.peek() != c {
+		return p.errorf("expected %q, found %q", c, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// skipSpace skips whitespace and comments.
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/':
+			start := p.pos
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+			p.comment(start)
+		case c == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '*':
+			end := bytes.Index(p.src[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				p.commentErr = p.errorf("unterminated block comment")
+				p.pos = len(p.src)
+				return
+			}
+			start := p.pos
+			p.pos += end + 4
+			p.comment(start)
+		default:
+			return
+		}
+	}
+}
+
+// comment records the comment from start to the current position. The
+// same comment may be skipped more than once.
+func (p *parser) comment(start int) {
+	if p.spans == nil || len(p.comments) > 0 && p.comments[len(p.comments)-1].Start >= start {
+		return
+	}
+	p.comments = append(p.comments, Span{Start: start, End: p.pos})
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	return p.src[p.pos]
+}
+
+// position converts a byte offset into a 1-based line and column.
+func (p *parser) position(offset int) (int, int) {
+	line, col := 1, 1
+	for i := 0; i < offset && i < len(p.src); i++ {
+		if p.src[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// errorf returns a SyntaxError at the current position. Once the source
+// has ended inside a block comment, every error is that one.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.commentErr != nil {
+		return p.commentErr
+	}
+	line, col := p.position(p.pos)
+	return &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// limitf returns a LimitError for the named limit, prefixed with the
+// current position.
+func (p *parser) limitf(limit string, max int) error {
+	line, col := p.position(p.pos)
+	return fmt.Errorf("line %d, column %d: %w", line, col, &LimitError{Limit: limit, Max: max})
+}
+
+// enter and leave track the nesting of objects, arrays and argument lists.
+func (p *parser) enter() error {
+	p.depth++
+	if exceeds(p.depth, p.limits.MaxDepth) {
+		return p.limitf("MaxDepth", p.limits.MaxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// countKey counts a key against Limits.MaxKeys.
+func (p *parser) countKey() error {
+	p.keys++
+	if exceeds(p.keys, p.limits.MaxKeys) {
+		return p.limitf("MaxKeys", p.limits.MaxKeys)
+	}
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPatchTestFailed is returned when a patch "test" operation does not match.
+var ErrPatchTestFailed = errors.New("test failed")
+
+// PatchOp is a single patch operation. Paths use SHON path syntax, e.g.
+// "user.location.city" or "user.tags[-]".
+type PatchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// Patch is an ordered list of operations, written in SHON as:
+//
+//	@patch {
+//	    ops: [
+//	        { op: "test", path: "user.balance", value: $decimal("1042.75") },
+//	        { op: "replace", path: "user.balance", value: $decimal("99.00") }
+//	    ]
+//	}
+type Patch struct {
+	Ops []PatchOp
+}
+
+// ParsePatch parses a SHON patch document.
+func ParsePatch(data []byte) (*Patch, error) {
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return PatchFromDocument(doc)
+}
+
+// PatchFromDocument reads the operations from a document's @patch namespace.
+func PatchFromDocument(doc *Document) (*Patch, error) {
+	ns := doc.Namespace("patch")
+	if ns == nil {
+		return nil, fmt.Errorf("patch document has no @patch namespace")
+	}
+	raw, ok := ns.Body.Values["ops"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("@patch must contain an ops array")
+	}
+
+	patch := &Patch{}
+	for i, item := range raw {
+		obj, ok := item.(*Object)
+		if !ok {
+			return nil, fmt.Errorf("patch op %d: expected object, found %s", i, TypeName(item))
+		}
+		op := PatchOp{}
+		op.Op, _ = obj.Values["op"].(string)
+		op.Path, _ = obj.Values["path"].(string)
+		op.From, _ = obj.Values["from"].(string)
+		value, hasValue := obj.Get("value")
+		op.Value = value
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if !hasValue {
+				return nil, fmt.Errorf("patch op %d: %q requires a value", i, op.Op)
+			}
+		case "move":
+			if op.From == "" {
+				return nil, fmt.Errorf("patch op %d: \"move\" requires from", i)
+			}
+		case "remove":
+		case "":
+			return nil, fmt.Errorf("patch op %d: missing op", i)
+		default:
+			return nil, fmt.Errorf("patch op %d: unknown op %q", i, op.Op)
+		}
+		if op.Path == "" {
+			return nil, fmt.Errorf("patch op %d: missing path", i)
+		}
+		patch.Ops = append(patch.Ops, op)
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies every operation to a copy of doc and returns the copy.
+// If any operation fails, including a "test", doc is left untouched and an
+// error naming the failing operation is returned.
+func ApplyPatch(doc *Document, patch *Patch) (*Document, error) {
+	out := doc.Clone()
+	root := out.root()
+	for i, op := range patch.Ops {
+		if err := applyOp(root, op); err != nil {
+			return nil, fmt.Errorf("patch op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	if err := out.setRoot(root); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PatchFile applies the patch at patchPath to the SHON file at inputPath and
+// writes the result to outputPath (stdout if empty).
+func PatchFile(inputPath, patchPath, outputPath string, opts EncodeOptions) error {
+	doc, err := ParseFile(inputPath)
+	if err != nil {
+		return err
+	}
+	patchDoc, err := ParseFile(patchPath)
+	if err != nil {
+		return err
+	}
+	patch, err := PatchFromDocument(patchDoc)
+	if err != nil {
+		return err
+	}
+	patched, err := ApplyPatch(doc, patch)
+	if err != nil {
+		return err
+	}
+	return WriteFile(patched, outputPath, opts)
+}
+
+func applyOp(root *Object, op PatchOp) error {
+	segs, err := ParsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add":
+		return addAt(root, segs, Clone(op.Value), false)
+	case "replace":
+		return addAt(root, segs, Clone(op.Value), true)
+	case "remove":
+		_, err := removeAt(root, segs)
+		return err
+	case "move":
+		from, err := ParsePath(op.From)
+		if err != nil {
+			return err
+		}
+		to, src := FormatPath(segs), FormatPath(from)
+		if strings.HasPrefix(to, src+".") || strings.HasPrefix(to, src+"[") {
+			return fmt.Errorf("cannot move %s into itself", op.From)
+		}
+		v, err := removeAt(root, from)
+		if err != nil {
+			return err
+		}
+		return addAt(root, segs, v, false)
+	case "test":
+		v, err := lookup(root, segs)
+		if err != nil {
+			return err
+		}
+		if !Equal(v, op.Value) {
+			return fmt.Errorf("%w: found %s, expected %s", ErrPatchTestFailed,
+				EncodeValue(v, 0, EncodeOptions{}), EncodeValue(op.Value, 0, EncodeOptions{}))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown op %q", op.Op)
+}
+
+// addAt inserts value at the path. With replace set the target must already
+// exist and array elements are overwritten rather than shifted.
+func addAt(root *Object, segs []PathSegment, value interface{}, replace bool) error {
+	_, err := updateAt(root, segs, func(parent interface{}, last PathSegment) (interface{}, error) {
+		switch p := parent.(type) {
+		case *Object:
+			if last.IsIndex {
+				return nil, fmt.Errorf("cannot index object with [%d]", last.Index)
+			}
+			if _, ok := p.Values[last.Key]; replace && !ok {
+				return nil, fmt.Errorf("key %q not found", last.Key)
+			}
+			p.Set(last.Key, value)
+			return p, nil
+		case []interface{}:
+			return insertItem(p, last, value, replace)
+		case *Tuple:
+			items, err := insertItem(p.Items, last, value, replace)
+			if err != nil {
+				return nil, err
+			}
+			p.Items = items
+			return p, nil
+		default:
+			return nil, fmt.Errorf("cannot add to %s", TypeName(parent))
+		}
+	})
+	return err
+}
+
+func insertItem(items []interface{}, last PathSegment, value interface{}, replace bool) ([]interface{}, error) {
+	if !last.IsIndex {
+		return nil, fmt.Errorf("expected array index, found %q", last.Key)
+	}
+	if last.Append {
+		if replace {
+			return nil, fmt.Errorf("cannot replace [-]")
+		}
+		return append(items, value), nil
+	}
+	if replace {
+		if last.Index >= len(items) {
+			return nil, fmt.Errorf("index %d out of range", last.Index)
+		}
+		items[last.Index] = value
+		return items, nil
+	}
+	if last.Index > len(items) {
+		return nil, fmt.Errorf("index %d out of range", last.Index)
+	}
+	items = append(items, nil)
+	copy(items[last.Index+1:], items[last.Index:])
+	items[last.Index] = value
+	return items, nil
+}
+
+// removeAt deletes the value at the path and returns it.
+func removeAt(root *Object, segs []PathSegment) (interface{}, error) {
+	var removed interface{}
+	_, err := updateAt(root, segs, func(parent interface{}, last PathSegment) (interface{}, error) {
+		v, err := childAt(parent, last)
+		if err != nil {
+			return nil, err
+		}
+		removed = v
+		switch p := parent.(type) {
+		case *Object:
+			p.Delete(last.Key)
+			return p, nil
+		case []interface{}:
+			return append(p[:last.Index:last.Index], p[last.Index+1:]...), nil
+		case *Tuple:
+			p.Items = append(p.Items[:last.Index:last.Index], p.Items[last.Index+1:]...)
+			return p, nil
+		}
+		return nil, fmt.Errorf("cannot remove from %s", TypeName(parent))
+	})
+	return removed, err
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathSegment is one step of a SHON path: an object key or an array index.
+// Append marks the "[-]" position one past the end of an array.
+type PathSegment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+	Append  bool
+}
+
+// ParsePath parses a SHON path such as "user.tags[0]" or "&people.sean".
+// The first segment names a namespace.
+func ParsePath(s string) ([]PathSegment, error) {
+	s = strings.TrimPrefix(s, "&")
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var segs []PathSegment
+	i := 0
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			if i == 0 || i == len(s)-1 {
+				return nil, fmt.Errorf("invalid path %q", s)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in path %q", s)
+			}
+			inner := s[i+1 : i+end]
+			if inner == "-" {
+				segs = append(segs, PathSegment{IsIndex: true, Append: true})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q in path %q", inner, s)
+				}
+				segs = append(segs, PathSegment{IsIndex: true, Index: n})
+			}
+			i += end + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			segs = append(segs, PathSegment{Key: s[i:j]})
+			i = j
+		}
+	}
+	if len(segs) == 0 || segs[0].IsIndex {
+		return nil, fmt.Errorf("path %q must start with a namespace", s)
+	}
+	return segs, nil
+}
+
+// FormatPath is the inverse of ParsePath.
+func FormatPath(segs []PathSegment) string {
+	var sb strings.Builder
+	for i, seg := range segs {
+		switch {
+		case seg.Append:
+			sb.WriteString("[-]")
+		case seg.IsIndex:
+			sb.WriteString(fmt.Sprintf("[%d]", seg.Index))
+		default:
+			if i > 0 {
+				sb.WriteString(".")
+			}
+			sb.WriteString(seg.Key)
+		}
+	}
+	return sb.String()
+}
+
+// Lookup returns the value at path.
+func (d *Document) Lookup(path string) (interface{}, error) {
+	segs, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return lookup(d.root(), segs)
+}
+
+func lookup(node interface{}, segs []PathSegment) (interface{}, error) {
+	for _, seg := range segs {
+		child, err := childAt(node, seg)
+		if err != nil {
+			return nil, err
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// root presents the namespaces as a single object so paths can address them
+// uniformly.
+func (d *Document) root() *Object {
+	root := NewObject()
+	for _, ns := range d.Namespaces {
+		root.Set(ns.Name, ns.Body)
+	}
+	return root
+}
+
+// setRoot is the inverse of root.
+func (d *Document) setRoot(root *Object) error {
+	var namespaces []*Namespace
+	for _, k := range root.Keys {
+		body, ok := root.Values[k].(*Object)
+		if !ok {
+			return fmt.Errorf("namespace %q must be an object", k)
+		}
+		namespaces = append(namespaces, &Namespace{Name: k, Body: body})
+	}
+	d.Namespaces = namespaces
+	return nil
+}
+
+func childAt(node interface{}, seg PathSegment) (interface{}, error) {
+	switch val := node.(type) {
+	case *Object:
+		if seg.IsIndex {
+			return nil, fmt.Errorf("cannot index object with [%d]", seg.Index)
+		}
+		v, ok := val.Values[seg.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", seg.Key)
+		}
+		return v, nil
+	case []interface{}:
+		if !seg.IsIndex || seg.Append {
+			return nil, fmt.Errorf("expected array index, found %q", seg.Key)
+		}
+		if seg.Index >= len(val) {
+			return nil, fmt.Errorf("index %d out of range", seg.Index)
+		}
+		return val[seg.Index], nil
+	case *Tuple:
+		return childAt(val.Items, seg)
+	default:
+		return nil, fmt.Errorf("cannot descend into %s", TypeName(node))
+	}
+}
+
+// updateAt walks to the parent of the final segment and lets fn replace it.
+// Arrays may change length, so every parent on the way back is rewritten.
+func updateAt(node interface{}, segs []PathSegment, fn func(parent interface{}, last PathSegment) (interface{}, error)) (interface{}, error) {
+	if len(segs) == 1 {
+		return fn(node, segs[0])
+	}
+	child, err := childAt(node, segs[0])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := updateAt(child, segs[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch val := node.(type) {
+	case *Object:
+		val.Values[segs[0].Key] = updated
+	case []interface{}:
+		val[segs[0].Index] = updated
+	case *Tuple:
+		val.Items[segs[0].Index] = updated
+	}
+	return node, nil
+}
+
+// TypeName returns the SHON type name of a value, for use in messages.
+func TypeName(v interface{}) string {
+	switch val := v.(type) {
+	case *Object:
+		return "object"
+	case []interface{}:
+		return "array"
+	case *Tuple:
+		if val.Name != "" {
+			return val.Name
+		}
+		return "tuple"
+	case string:
+		return "string"
+	case Decimal:
+		return "decimal"
+	case Timestamp:
+		return "timestamp"
+	case Secret:
+		return "secret"
+	case *Env:
+		return "env"
+	case *Expr:
+		return "expr"
+	case Ref:
+		return "ref"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,259 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// constraints are the optional value checks layered on top of a type.
+type constraints struct {
+	minimum, maximum                   *bound
+	exclusiveMinimum, exclusiveMaximum *bound
+	precision, scale                   *int
+	minLength, maxLength               *int
+	pattern                            *regexp.Regexp
+	minItems, maxItems                 *int
+	uniqueItems                        bool
+	after, before                      *timeBound
+}
+
+// bound is a numeric limit, kept with its literal for messages.
+type bound struct {
+	value *big.Rat
+	text  string
+}
+
+// timeBound is a timestamp limit, kept with its literal for messages. It is
+// parsed by compileTimes once the node's format is known.
+type timeBound struct {
+	value time.Time
+	text  string
+}
+
+// constraintTypes lists the types each constraint keyword applies to.
+var constraintTypes = map[string][]string{
+	"minimum":          {"integer", "number", "decimal"},
+	"maximum":          {"integer", "number", "decimal"},
+	"exclusiveMinimum": {"integer", "number", "decimal"},
+	"exclusiveMaximum": {"integer", "number", "decimal"},
+	"precision":        {"decimal"},
+	"scale":            {"decimal"},
+	"minLength":        {"string"},
+	"maxLength":        {"string"},
+	"pattern":          {"string"},
+	"minItems":         {"array"},
+	"maxItems":         {"array"},
+	"uniqueItems":      {"array"},
+	"after":            {"timestamp"},
+	"before":           {"timestamp"},
+}
+
+// compileConstraint handles a constraint keyword, reporting false if key is
+// not one.
+func compileConstraint(n *node, key string, v interface{}, path string) (bool, error) {
+	allowed, ok := constraintTypes[key]
+	if !ok {
+		return false, nil
+	}
+	applies := false
+	for _, t := range allowed {
+		if n.typ == t {
+			applies = true
+		}
+	}
+	if !applies {
+		return true, fmt.Errorf("%s: %s does not apply to type %s", path, key, n.typ)
+	}
+
+	var err error
+	c := &n.constraints
+	switch key {
+	case "minimum":
+		c.minimum, err = compileBound(v, path)
+	case "maximum":
+		c.maximum, err = compileBound(v, path)
+	case "exclusiveMinimum":
+		c.exclusiveMinimum, err = compileBound(v, path)
+	case "exclusiveMaximum":
+		c.exclusiveMaximum, err = compileBound(v, path)
+	case "precision":
+		c.precision, err = compileCount(v, path)
+	case "scale":
+		c.scale, err = compileCount(v, path)
+	case "minLength":
+		c.minLength, err = compileCount(v, path)
+	case "maxLength":
+		c.maxLength, err = compileCount(v, path)
+	case "minItems":
+		c.minItems, err = compileCount(v, path)
+	case "maxItems":
+		c.maxItems, err = compileCount(v, path)
+	case "uniqueItems":
+		b, ok := v.(bool)
+		if !ok {
+			return true, fmt.Errorf("%s: expected true or false", path)
+		}
+		c.uniqueItems = b
+	case "pattern":
+		s, ok := v.(string)
+		if !ok {
+			return true, fmt.Errorf("%s: pattern must be a string", path)
+		}
+		c.pattern, err = regexp.Compile(s)
+		if err != nil {
+			return true, fmt.Errorf("%s: invalid pattern: %v", path, err)
+		}
+	case "after":
+		c.after, err = compileTime(v, path)
+	case "before":
+		c.before, err = compileTime(v, path)
+	}
+	return true, err
+}
+
+func compileBound(v interface{}, path string) (*bound, error) {
+	var text string
+	switch val := v.(type) {
+	case json.Number:
+		text = string(val)
+	case pkg.Decimal:
+		text = string(val)
+	default:
+		return nil, fmt.Errorf("%s: expected a number, found %s", path, pkg.TypeName(v))
+	}
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid number %q", path, text)
+	}
+	return &bound{value: r, text: text}, nil
+}
+
+func compileCount(v interface{}, path string) (*int, error) {
+	num, ok := v.(json.Number)
+	if ok {
+		if n, err := num.Int64(); err == nil && n >= 0 {
+			i := int(n)
+			return &i, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: expected a non-negative integer", path)
+}
+
+func compileTime(v interface{}, path string) (*timeBound, error) {
+	switch val := v.(type) {
+	case pkg.Timestamp:
+		return &timeBound{text: string(val)}, nil
+	case string:
+		return &timeBound{text: val}, nil
+	}
+	return nil, fmt.Errorf("%s: expected a timestamp, found %s", path, pkg.TypeName(v))
+}
+
+// compileTimes parses the node's after and before bounds in its format,
+// which may be given after them.
+func compileTimes(n *node, path string) error {
+	for i, b := range []*timeBound{n.constraints.after, n.constraints.before} {
+		if b == nil {
+			continue
+		}
+		t, err := time.Parse(n.layout(), b.text)
+		if err != nil {
+			key := [...]string{"after", "before"}[i]
+			return fmt.Errorf("%s: invalid timestamp %q for format %s", pkg.JoinPath(path, key), b.text, n.formatName())
+		}
+		b.value = t
+	}
+	return nil
+}
+
+// checkConstraints runs the node's constraints against a value that already
+// has the right type.
+func (v *validator) checkConstraints(n *node, value interface{}, path string) {
+	c := &n.constraints
+	switch val := value.(type) {
+	case json.Number:
+		v.checkBounds(c, string(val), path)
+	case pkg.Decimal:
+		v.checkBounds(c, string(val), path)
+		v.checkDigits(c, string(val), path)
+	case string:
+		length := utf8.RuneCountInString(val)
+		if c.minLength != nil && length < *c.minLength {
+			v.errorf(path, "length %d is less than minLength %d", length, *c.minLength)
+		}
+		if c.maxLength != nil && length > *c.maxLength {
+			v.errorf(path, "length %d is greater than maxLength %d", length, *c.maxLength)
+		}
+		if c.pattern != nil && !c.pattern.MatchString(val) {
+			v.errorf(path, "%q does not match pattern %s", val, c.pattern)
+		}
+	case []interface{}:
+		if c.minItems != nil && len(val) < *c.minItems {
+			v.errorf(path, "%d items is fewer than minItems %d", len(val), *c.minItems)
+		}
+		if c.maxItems != nil && len(val) > *c.maxItems {
+			v.errorf(path, "%d items is more than maxItems %d", len(val), *c.maxItems)
+		}
+		if c.uniqueItems {
+			for i := range val {
+				for j := 0; j < i; j++ {
+					if pkg.Equal(val[i], val[j]) {
+						v.errorf(pkg.IndexPath(path, i), "duplicate of item %d; items must be unique", j)
+						break
+					}
+				}
+			}
+		}
+	case pkg.Timestamp:
+		t, err := time.Parse(n.layout(), string(val))
+		if err != nil {
+			return
+		}
+		if c.after != nil && !t.After(c.after.value) {
+			v.errorf(path, "%s is not after %s", val, c.after.text)
+		}
+		if c.before != nil && !t.Before(c.before.value) {
+			v.errorf(path, "%s is not before %s", val, c.before.text)
+		}
+	}
+}
+
+func (v *validator) checkBounds(c *constraints, text, path string) {
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return
+	}
+	if c.minimum != nil && r.Cmp(c.minimum.value) < 0 {
+		v.errorf(path, "%s is less than minimum %s", text, c.minimum.text)
+	}
+	if c.maximum != nil && r.Cmp(c.maximum.value) > 0 {
+		v.errorf(path, "%s is greater than maximum %s", text, c.maximum.text)
+	}
+	if c.exclusiveMinimum != nil && r.Cmp(c.exclusiveMinimum.value) <= 0 {
+		v.errorf(path, "%s must be greater than %s", text, c.exclusiveMinimum.text)
+	}
+	if c.exclusiveMaximum != nil && r.Cmp(c.exclusiveMaximum.value) >= 0 {
+		v.errorf(path, "%s must be less than %s", text, c.exclusiveMaximum.text)
+	}
+}
+
+// checkDigits enforces decimal precision (total significant digits) and
+// scale (digits after the point).
+func (v *validator) checkDigits(c *constraints, text, path string) {
+	digits := strings.TrimLeft(text, "+-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	whole = strings.TrimLeft(whole, "0")
+	if c.scale != nil && len(frac) > *c.scale {
+		v.errorf(path, "%s has %d decimal places, more than scale %d", text, len(frac), *c.scale)
+	}
+	if c.precision != nil && len(whole)+len(frac) > *c.precision {
+		v.errorf(path, "%s has %d digits, more than precision %d", text, len(whole)+len(frac), *c.precision)
+	}
+}
@@ -0,0 +1,94 @@
+package schema
+
+import (
+	"fmt"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// checkDefaults reports the first default value that does not match the
+// schema it belongs to.
+func (c *compiler) checkDefaults() error {
+	for _, d := range c.defaults {
+		v := &validator{following: map[pkg.Ref]bool{}}
+		v.check(d.n, d.n.def, "")
+		if len(v.diags) > 0 {
+			return fmt.Errorf("%s: invalid default: %s", d.path, reasons(v.diags, ""))
+		}
+	}
+	return nil
+}
+
+// FillDefaults returns a copy of doc in which every missing optional field
+// that has a default in the schema is set to that default. Fields are filled
+// in nested structs, arrays, maps and tuples as well; required fields and
+// namespaces missing from the document are left for Validate to report.
+func (s *Schema) FillDefaults(doc *pkg.Document) *pkg.Document {
+	out := doc.Clone()
+	for _, name := range s.namespaces {
+		if ns := out.Namespace(name); ns != nil {
+			fill(s.nodes[name], ns.Body)
+		}
+	}
+	return out
+}
+
+// fill sets defaults in value, which it modifies in place.
+func fill(n *node, value interface{}) {
+	for ; n != nil; n = n.target {
+		switch val := value.(type) {
+		case *pkg.Object:
+			fillObject(n, val)
+		case []interface{}:
+			if n.items != nil {
+				for _, item := range val {
+					fill(n.items, item)
+				}
+			}
+		case *pkg.Tuple:
+			if len(n.tupleItems) == len(val.Items) {
+				for i, item := range val.Items {
+					fill(n.tupleItems[i], item)
+				}
+			}
+		}
+	}
+}
+
+func fillObject(n *node, obj *pkg.Object) {
+	for _, name := range n.propertyOrder {
+		prop := n.properties[name]
+		if child, ok := obj.Get(name); ok {
+			fill(prop, child)
+			continue
+		}
+		if def, ok := prop.defaultValue(); ok && !isRequired(n, name) {
+			obj.Set(name, pkg.Clone(def))
+		}
+	}
+	if n.values != nil {
+		for _, k := range obj.Keys {
+			fill(n.values, obj.Values[k])
+		}
+	}
+}
+
+// defaultValue returns the node's default, or that of the definition it
+// refers to.
+func (n *node) defaultValue() (interface{}, bool) {
+	for ; n != nil; n = n.target {
+		if n.hasDefault {
+			return n.def, true
+		}
+	}
+	return nil, false
+}
+
+func isRequired(n *node, name string) bool {
+	for _, r := range n.required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package schema
+
+import (
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// Field is what a schema says about one value of a document, as editors
+// show it.
+type Field struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// Describe returns what the schema says about the value at path, such as
+// "user.location.city". It reports false for paths the schema does not
+// describe.
+func (s *Schema) Describe(path string) (Field, bool) {
+	segs, err := pkg.ParsePath(path)
+	if err != nil {
+		return Field{}, false
+	}
+	n := s.nodes[segs[0].Key]
+	if n == nil {
+		return Field{}, false
+	}
+	var parent *node
+	for _, seg := range segs[1:] {
+		parent = n
+		if n = childNode(n, seg); n == nil {
+			return Field{}, false
+		}
+	}
+
+	last := segs[len(segs)-1]
+	f := Field{Name: last.Key, Type: typeLabel(n), Description: n.describe()}
+	if parent != nil && !last.IsIndex {
+		f.Required = isRequired(resolve(parent), last.Key)
+	}
+	return f, true
+}
+
+// Fields returns the fields the schema declares for the struct at path, in
+// schema order, including those added through allOf.
+func (s *Schema) Fields(path string) []Field {
+	segs, err := pkg.ParsePath(path)
+	if err != nil {
+		return nil
+	}
+	n := s.nodes[segs[0].Key]
+	for _, seg := range segs[1:] {
+		if n == nil {
+			return nil
+		}
+		n = childNode(n, seg)
+	}
+	if n == nil {
+		return nil
+	}
+
+	var fields []Field
+	seen := map[string]bool{}
+	var collect func(n *node, depth int)
+	collect = func(n *node, depth int) {
+		n = resolve(n)
+		if n == nil || depth > maxRefDepth {
+			return
+		}
+		for _, name := range n.propertyOrder {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			child := n.properties[name]
+			fields = append(fields, Field{Name: name, Type: typeLabel(child), Description: child.describe(), Required: isRequired(n, name)})
+		}
+		for _, b := range n.allOf {
+			collect(b, depth+1)
+		}
+	}
+	collect(n, 0)
+	return fields
+}
+
+// maxRefDepth bounds how many $refs resolve follows, so reference cycles
+// without an own type end.
+const maxRefDepth = 32
+
+// resolve follows $refs from nodes that declare nothing themselves.
+func resolve(n *node) *node {
+	for i := 0; n != nil && n.target != nil && n.typ == "" && n.properties == nil && i < maxRefDepth; i++ {
+		n = n.target
+	}
+	return n
+}
+
+// childNode returns the node describing one step below n.
+func childNode(n *node, seg pkg.PathSegment) *node {
+	n = resolve(n)
+	if n == nil {
+		return nil
+	}
+	if seg.IsIndex {
+		if n.typ == "tuple" && n.tupleItems != nil {
+			if seg.Index < len(n.tupleItems) {
+				return n.tupleItems[seg.Index]
+			}
+			return nil
+		}
+		return n.items
+	}
+	if child := n.properties[seg.Key]; child != nil {
+		return child
+	}
+	for _, branches := range [][]*node{n.allOf, n.oneOf, n.anyOf} {
+		for _, b := range branches {
+			if child := childNode(b, seg); child != nil {
+				return child
+			}
+		}
+	}
+	if n.values != nil {
+		return n.values
+	}
+	return n.additionalProperties
+}
+
+// typeLabel names a node's type for display: the definition a $ref names,
+// or the type, marked with ? when nullable.
+func typeLabel(n *node) string {
+	label := n.typ
+	if n.refName != "" && (label == "" || label == n.target.typ) {
+		label = n.refName
+	}
+	if label == "" {
+		switch {
+		case n.oneOf != nil:
+			label = "oneOf"
+		case n.anyOf != nil:
+			label = "anyOf"
+		case n.allOf != nil:
+			label = "allOf"
+		}
+	}
+	if label == "ref" && n.refNamespace != "" {
+		label = "ref @" + n.refNamespace
+	}
+	if n.isNullable() {
+		label += "?"
+	}
+	return label
+}
+
+// describe returns a node's description, or that of the definition it
+// refers to.
+func (n *node) describe() string {
+	for i := 0; n != nil && i < maxRefDepth; i++ {
+		if n.description != "" {
+			return n.description
+		}
+		n = n.target
+	}
+	return ""
+}
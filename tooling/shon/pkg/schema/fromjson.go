@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+var decimalText = regexp.MustCompile(decimalJSONPattern)
+
+// TypeJSON turns a JSON value, as read by pkg.ParseJSON, into a document
+// typed by the schema: strings become decimals, timestamps and refs, and
+// arrays become tuples, wherever the schema says so. Tuples reached through
+// a $ref to a definition are named after it. A schema with one namespace
+// takes the whole JSON object as that namespace; with several, each
+// top-level key names a namespace.
+//
+// Values that cannot take the schema's type are reported as errors, as is
+// anything the typed document then fails to validate against.
+func (s *Schema) TypeJSON(v interface{}) (*pkg.Document, []pkg.Diagnostic) {
+	t := &typer{}
+	doc := pkg.NewDocument()
+	root, ok := v.(*pkg.Object)
+	if !ok {
+		t.errorf("", "expected a JSON object, found %s", pkg.TypeName(v))
+		return doc, t.diags
+	}
+
+	if len(s.namespaces) == 1 {
+		name := s.namespaces[0]
+		body, _ := t.typed(s.nodes[name], root, name).(*pkg.Object)
+		doc.Namespaces = append(doc.Namespaces, &pkg.Namespace{Name: name, Body: body})
+	} else {
+		for _, k := range root.Keys {
+			body, ok := root.Values[k].(*pkg.Object)
+			if !ok {
+				t.errorf(k, "expected an object for namespace @%s, found %s", k, pkg.TypeName(root.Values[k]))
+				continue
+			}
+			if n, ok := s.nodes[k]; ok {
+				body, _ = t.typed(n, body, k).(*pkg.Object)
+			}
+			doc.Namespaces = append(doc.Namespaces, &pkg.Namespace{Name: k, Body: body})
+		}
+	}
+	if pkg.HasErrors(t.diags) {
+		return doc, t.diags
+	}
+	return doc, s.Validate(doc)
+}
+
+// typer collects diagnostics for one TypeJSON call.
+type typer struct {
+	diags []pkg.Diagnostic
+}
+
+func (t *typer) errorf(path, format string, args ...interface{}) {
+	t.diags = append(t.diags, pkg.Diagnostic{Severity: pkg.SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// typed converts v to the SHON type n describes. Values that are already
+// typed are kept, so a value may pass through several allOf branches.
+func (t *typer) typed(n *node, v interface{}, path string) interface{} {
+	if v == nil {
+		return nil
+	}
+	if n.target != nil {
+		v = t.typed(n.target, v, path)
+		if tuple, ok := v.(*pkg.Tuple); ok && tuple.Name == "" && n.target.typ == "tuple" {
+			tuple.Name = n.refName
+		}
+	}
+	for _, b := range n.allOf {
+		v = t.typed(b, v, path)
+	}
+	if n.oneOf != nil {
+		v = t.firstMatch(n.oneOf, v, path)
+	}
+	if n.anyOf != nil {
+		v = t.firstMatch(n.anyOf, v, path)
+	}
+
+	switch n.typ {
+	case "decimal":
+		switch val := v.(type) {
+		case string:
+			if !decimalText.MatchString(val) {
+				t.errorf(path, "%q is not a decimal", val)
+				return v
+			}
+			return pkg.Decimal(val)
+		case json.Number:
+			return pkg.Decimal(val)
+		}
+	case "timestamp":
+		if s, ok := v.(string); ok {
+			return pkg.Timestamp(s)
+		}
+	case "ref":
+		if s, ok := v.(string); ok {
+			if !strings.HasPrefix(s, "&") {
+				t.errorf(path, "%q is not a reference; expected \"&namespace.path\"", s)
+				return v
+			}
+			return pkg.Ref(strings.TrimPrefix(s, "&"))
+		}
+	case "tuple":
+		if obj, ok := v.(*pkg.Object); ok && len(n.tupleNames) > 0 {
+			v = t.tupleItems(n, obj, path)
+		}
+		if items, ok := v.([]interface{}); ok {
+			tuple := &pkg.Tuple{Items: make([]interface{}, len(items))}
+			for i, item := range items {
+				tuple.Items[i] = item
+				if i < len(n.tupleItems) {
+					tuple.Items[i] = t.typed(n.tupleItems[i], item, pkg.IndexPath(path, i))
+				}
+			}
+			return tuple
+		}
+	case "array":
+		if items, ok := v.([]interface{}); ok && n.items != nil {
+			out := make([]interface{}, len(items))
+			for i, item := range items {
+				out[i] = t.typed(n.items, item, pkg.IndexPath(path, i))
+			}
+			return out
+		}
+	case "struct", "map":
+		if obj, ok := v.(*pkg.Object); ok {
+			return t.typedObject(n, obj, path)
+		}
+	}
+	return v
+}
+
+// tupleItems reads a tuple written as a JSON object keyed by position
+// names, as pkg.ConvertOptions.TupleObjects produces.
+func (t *typer) tupleItems(n *node, obj *pkg.Object, path string) interface{} {
+	items := make([]interface{}, len(n.tupleNames))
+	for i, name := range n.tupleNames {
+		item, ok := obj.Get(name)
+		if name == "" || !ok {
+			t.errorf(path, "tuple object is missing position %q", name)
+			return obj
+		}
+		items[i] = item
+	}
+	if obj.Len() != len(items) {
+		t.errorf(path, "tuple object has %d fields, expected %d", obj.Len(), len(items))
+		return obj
+	}
+	return items
+}
+
+func (t *typer) typedObject(n *node, obj *pkg.Object, path string) *pkg.Object {
+	out := pkg.NewObject()
+	out.Meta = obj.Meta
+	for _, k := range obj.Keys {
+		child := obj.Values[k]
+		childPath := pkg.JoinPath(path, k)
+		switch {
+		case n.properties[k] != nil:
+			child = t.typed(n.properties[k], child, childPath)
+		case n.values != nil:
+			child = t.typed(n.values, child, childPath)
+		case n.additionalProperties != nil:
+			child = t.typed(n.additionalProperties, child, childPath)
+		}
+		out.Set(k, child)
+	}
+	return out
+}
+
+// firstMatch types v with the first branch that both accepts and validates
+// it, leaving v unchanged if none does.
+func (t *typer) firstMatch(branches []*node, v interface{}, path string) interface{} {
+	for _, b := range branches {
+		sub := &typer{}
+		typed := sub.typed(b, v, path)
+		if len(sub.diags) > 0 {
+			continue
+		}
+		check := &validator{following: map[pkg.Ref]bool{}}
+		check.check(b, typed, path)
+		if len(check.diags) == 0 {
+			return typed
+		}
+	}
+	return v
+}
@@ -0,0 +1,629 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// JSONSchemaDialect is the JSON Schema draft that JSONSchema writes.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// decimalJSONPattern matches the JSON string form of a SHON decimal.
+const decimalJSONPattern = `^[-+]?(\d+(\.\d*)?|\.\d+)$`
+
+// extensionPrefix marks JSON Schema keywords that carry SHON details JSON
+// Schema cannot express, such as x-shon-type: "decimal". JSON Schema
+// validators ignore them; ImportJSONSchema reads them back.
+const extensionPrefix = "x-shon-"
+
+// JSONSchema converts the schema to JSON Schema describing the JSON that
+// SHON conversion produces. Decimals become strings with a decimal pattern,
+// timestamps date-time strings, tuples arrays with prefixItems and refs
+// "&path" strings. A single namespace becomes the root schema; several
+// become properties of the root, matching pkg.DocumentToJSON. Definitions,
+// including those from other schema files, are written to $defs.
+func (s *Schema) JSONSchema() *pkg.Object {
+	e := &exporter{names: map[*node]string{}, taken: map[string]bool{}}
+	for _, name := range s.definitionOrder {
+		e.add(s.definitions[name], name)
+	}
+
+	var root *pkg.Object
+	if len(s.namespaces) == 1 {
+		root = e.export(s.nodes[s.namespaces[0]])
+	} else {
+		root = pkg.NewObject()
+		root.Set("type", "object")
+		props := pkg.NewObject()
+		var required []interface{}
+		for _, name := range s.namespaces {
+			props.Set(name, e.export(s.nodes[name]))
+			required = append(required, name)
+		}
+		root.Set("properties", props)
+		if required != nil {
+			root.Set("required", required)
+		}
+	}
+
+	defs := pkg.NewObject()
+	for i := 0; i < len(e.order); i++ {
+		n := e.order[i]
+		defs.Set(e.names[n], e.export(n))
+	}
+
+	out := pkg.NewObject()
+	out.Set("$schema", JSONSchemaDialect)
+	for _, k := range root.Keys {
+		out.Set(k, root.Values[k])
+	}
+	var names []interface{}
+	for _, name := range s.namespaces {
+		names = append(names, name)
+	}
+	out.Set(extensionPrefix+"namespaces", names)
+	if defs.Len() > 0 {
+		out.Set("$defs", defs)
+	}
+	return out
+}
+
+// exporter names every definition written to $defs. Definitions reached
+// through $ref to other files are added as they are found.
+type exporter struct {
+	names map[*node]string
+	taken map[string]bool
+	order []*node
+}
+
+func (e *exporter) add(n *node, name string) string {
+	if existing, ok := e.names[n]; ok {
+		return existing
+	}
+	unique := name
+	for i := 2; e.taken[unique]; i++ {
+		unique = fmt.Sprintf("%s_%d", name, i)
+	}
+	e.names[n] = unique
+	e.taken[unique] = true
+	e.order = append(e.order, n)
+	return unique
+}
+
+func (e *exporter) export(n *node) *pkg.Object {
+	out := pkg.NewObject()
+	if n.target != nil {
+		out.Set("$ref", "#/$defs/"+e.add(n.target, n.refName))
+	}
+	e.exportType(n, out)
+	if n.description != "" {
+		out.Set("description", n.description)
+	}
+	if n.enum != nil {
+		out.Set("enum", pkg.ToJSON(n.enum, pkg.ConvertOptions{}))
+	}
+	if n.hasDefault {
+		out.Set("default", pkg.ToJSON(n.def, pkg.ConvertOptions{}))
+	}
+	for _, c := range []struct {
+		key      string
+		branches []*node
+	}{{"oneOf", n.oneOf}, {"anyOf", n.anyOf}, {"allOf", n.allOf}} {
+		if c.branches == nil {
+			continue
+		}
+		var list []interface{}
+		for _, b := range c.branches {
+			list = append(list, e.export(b))
+		}
+		out.Set(c.key, list)
+	}
+
+	if !n.nullable {
+		return out
+	}
+	if typ, ok := out.Values["type"].(string); ok && n.target == nil && n.oneOf == nil && n.anyOf == nil && n.allOf == nil {
+		out.Set("type", []interface{}{typ, "null"})
+		return out
+	}
+	null := pkg.NewObject()
+	null.Set("type", "null")
+	wrapped := pkg.NewObject()
+	wrapped.Set("anyOf", []interface{}{out, null})
+	return wrapped
+}
+
+func (e *exporter) exportType(n *node, out *pkg.Object) {
+	c := &n.constraints
+	switch n.typ {
+	case "":
+		return
+	case "string":
+		out.Set("type", "string")
+		setCount(out, "minLength", c.minLength)
+		setCount(out, "maxLength", c.maxLength)
+		if c.pattern != nil {
+			out.Set("pattern", c.pattern.String())
+		}
+	case "integer", "number":
+		out.Set("type", n.typ)
+		setBounds(out, c, "")
+	case "boolean":
+		out.Set("type", "boolean")
+	case "decimal":
+		out.Set("type", "string")
+		out.Set("pattern", decimalJSONPattern)
+		out.Set(extensionPrefix+"type", "decimal")
+		setBounds(out, c, extensionPrefix)
+		setCount(out, extensionPrefix+"precision", c.precision)
+		setCount(out, extensionPrefix+"scale", c.scale)
+	case "secret":
+		out.Set("type", "string")
+		out.Set(extensionPrefix+"type", "secret")
+	case "timestamp":
+		out.Set("type", "string")
+		if n.format == "date" {
+			out.Set("format", "date")
+		} else {
+			out.Set("format", "date-time")
+		}
+		out.Set(extensionPrefix+"type", "timestamp")
+		if n.format != "" {
+			out.Set(extensionPrefix+"format", n.format)
+		}
+		if c.after != nil {
+			out.Set(extensionPrefix+"after", c.after.text)
+		}
+		if c.before != nil {
+			out.Set(extensionPrefix+"before", c.before.text)
+		}
+	case "array":
+		out.Set("type", "array")
+		if n.items != nil {
+			out.Set("items", e.export(n.items))
+		}
+		setCount(out, "minItems", c.minItems)
+		setCount(out, "maxItems", c.maxItems)
+		if c.uniqueItems {
+			out.Set("uniqueItems", true)
+		}
+	case "tuple":
+		out.Set("type", "array")
+		out.Set(extensionPrefix+"type", "tuple")
+		if n.tupleItems != nil {
+			var items []interface{}
+			for i, item := range n.tupleItems {
+				schema := e.export(item)
+				if name := n.tupleNames[i]; name != "" {
+					schema.Set("title", name)
+				}
+				items = append(items, schema)
+			}
+			count := len(items)
+			out.Set("prefixItems", items)
+			out.Set("items", false)
+			setCount(out, "minItems", &count)
+			setCount(out, "maxItems", &count)
+		}
+	case "struct":
+		out.Set("type", "object")
+		if n.propertyOrder != nil {
+			props := pkg.NewObject()
+			for _, name := range n.propertyOrder {
+				props.Set(name, e.export(n.properties[name]))
+			}
+			out.Set("properties", props)
+		}
+		if n.required != nil {
+			out.Set("required", pkg.ToJSON(toList(n.required), pkg.ConvertOptions{}))
+		}
+		switch {
+		case n.additionalProperties != nil:
+			out.Set("additionalProperties", e.export(n.additionalProperties))
+		case n.closed:
+			out.Set("additionalProperties", false)
+		}
+	case "map":
+		out.Set("type", "object")
+		out.Set(extensionPrefix+"type", "map")
+		if n.values != nil {
+			out.Set("additionalProperties", e.export(n.values))
+		}
+	case "ref":
+		out.Set("type", "string")
+		out.Set(extensionPrefix+"type", "ref")
+		switch {
+		case n.refNamespace != "":
+			out.Set("pattern", `^&`+regexp.QuoteMeta(n.refNamespace)+`([.\[]|$)`)
+			out.Set(extensionPrefix+"target", n.refNamespace)
+		default:
+			out.Set("pattern", "^&")
+			if n.refSchema != nil {
+				out.Set(extensionPrefix+"target", e.export(n.refSchema))
+			}
+		}
+	}
+}
+
+func setBounds(out *pkg.Object, c *constraints, prefix string) {
+	for _, b := range []struct {
+		key   string
+		bound *bound
+	}{
+		{"minimum", c.minimum}, {"maximum", c.maximum},
+		{"exclusiveMinimum", c.exclusiveMinimum}, {"exclusiveMaximum", c.exclusiveMaximum},
+	} {
+		if b.bound != nil {
+			out.Set(prefix+b.key, json.Number(b.bound.text))
+		}
+	}
+}
+
+func setCount(out *pkg.Object, key string, n *int) {
+	if n != nil {
+		out.Set(key, json.Number(strconv.Itoa(*n)))
+	}
+}
+
+func toList(names []string) []interface{} {
+	var out []interface{}
+	for _, name := range names {
+		out = append(out, name)
+	}
+	return out
+}
+
+// annotations are JSON Schema keywords with no .shos equivalent that
+// ImportJSONSchema drops without comment.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "examples": true, "readOnly": true,
+	"writeOnly": true, "deprecated": true,
+}
+
+// ImportJSONSchema converts a JSON Schema document into a .shos schema
+// document. Schemas written by JSONSchema convert back to their original
+// namespaces; any other schema becomes a single namespace with the given
+// name. $defs and definitions become @definitions. Keywords that cannot be
+// expressed in .shos are dropped and reported as warnings.
+func ImportJSONSchema(data []byte, namespace string) (*pkg.Document, []pkg.Diagnostic, error) {
+	v, err := pkg.ParseJSON(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	root, ok := v.(*pkg.Object)
+	if !ok {
+		return nil, nil, fmt.Errorf("JSON Schema must be an object, found %s", pkg.TypeName(v))
+	}
+
+	im := &importer{}
+	doc := pkg.NewDocument()
+	doc.Meta.Set("schema", "0.6")
+
+	for _, key := range []string{"$defs", "definitions"} {
+		defs, ok := root.Values[key].(*pkg.Object)
+		if !ok {
+			continue
+		}
+		body := pkg.NewObject()
+		for _, name := range defs.Keys {
+			body.Set(name, im.convert(defs.Values[name], pkg.JoinPath(DefinitionsNamespace, name)))
+		}
+		doc.Namespaces = append(doc.Namespaces, &pkg.Namespace{Name: DefinitionsNamespace, Body: body})
+	}
+
+	names, _ := root.Values[extensionPrefix+"namespaces"].([]interface{})
+	props, _ := root.Values["properties"].(*pkg.Object)
+	switch {
+	case len(names) > 1 && props != nil:
+		for _, raw := range names {
+			name, _ := raw.(string)
+			if schema, ok := props.Get(name); ok {
+				doc.Namespaces = append(doc.Namespaces, &pkg.Namespace{Name: name, Body: im.namespace(schema, name)})
+			}
+		}
+	default:
+		if len(names) == 1 {
+			namespace, _ = names[0].(string)
+		}
+		if namespace == "" {
+			namespace = "data"
+		}
+		doc.Namespaces = append(doc.Namespaces, &pkg.Namespace{Name: namespace, Body: im.namespace(root, namespace)})
+	}
+	return doc, im.diags, nil
+}
+
+// importer collects warnings for one ImportJSONSchema call.
+type importer struct {
+	diags []pkg.Diagnostic
+}
+
+func (im *importer) warnf(path, format string, args ...interface{}) {
+	im.diags = append(im.diags, pkg.Diagnostic{Severity: pkg.SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// namespace converts a schema for use as a namespace body, which must be an
+// object rather than a bare type name.
+func (im *importer) namespace(v interface{}, path string) *pkg.Object {
+	switch val := im.convert(v, path).(type) {
+	case *pkg.Object:
+		return val
+	case string:
+		obj := pkg.NewObject()
+		obj.Set("type", val)
+		return obj
+	}
+	return pkg.NewObject()
+}
+
+// convert turns one JSON Schema into a .shos schema, written as a bare type
+// name when nothing but the type is set.
+func (im *importer) convert(v interface{}, path string) interface{} {
+	in, ok := v.(*pkg.Object)
+	if !ok {
+		im.warnf(path, "expected a schema object, found %s", pkg.TypeName(v))
+		return "string"
+	}
+	out := pkg.NewObject()
+
+	if raw, ok := in.Values["$ref"]; ok {
+		ref, _ := raw.(string)
+		name := ""
+		for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+			if strings.HasPrefix(ref, prefix) {
+				name = strings.TrimPrefix(ref, prefix)
+			}
+		}
+		if name == "" {
+			im.warnf(path, "unsupported $ref %q dropped", ref)
+		} else {
+			out.SetMeta("ref", name)
+		}
+	}
+
+	typ, nullable, others := im.typeOf(in, path)
+	if typ != "" {
+		out.Set("type", typ)
+	}
+	if f, ok := in.Values[extensionPrefix+"format"].(string); ok && typ == "timestamp" {
+		out.Set("format", f)
+	} else if in.Values["format"] == "date" && typ == "timestamp" {
+		out.Set("format", "date")
+	}
+	if nullable {
+		out.Set("nullable", true)
+	}
+	if others != nil {
+		out.Set("anyOf", others)
+	}
+
+	for _, key := range in.Keys {
+		val := in.Values[key]
+		kp := pkg.JoinPath(path, key)
+		switch key {
+		case "$ref", "type", "format", "$defs", "definitions", extensionPrefix + "type", extensionPrefix + "format", extensionPrefix + "namespaces":
+		case "enum":
+			if list, ok := val.([]interface{}); ok {
+				var enum []interface{}
+				for _, item := range list {
+					enum = append(enum, fromJSON(item, typ))
+				}
+				out.Set("enum", enum)
+			}
+		case "const":
+			out.Set("enum", []interface{}{fromJSON(val, typ)})
+		case "default":
+			out.Set("default", fromJSON(val, typ))
+		case "properties":
+			props, ok := val.(*pkg.Object)
+			if !ok {
+				im.warnf(kp, "properties must be an object")
+				continue
+			}
+			converted := pkg.NewObject()
+			for _, name := range props.Keys {
+				converted.Set(name, im.convert(props.Values[name], pkg.JoinPath(kp, name)))
+			}
+			out.Set("properties", converted)
+		case "required":
+			out.Set("required", val)
+		case "additionalProperties":
+			switch ap := val.(type) {
+			case bool:
+				if typ == "struct" && !ap {
+					out.Set("additionalProperties", false)
+				}
+			default:
+				if typ == "map" {
+					out.Set("values", im.convert(val, kp))
+				} else {
+					out.Set("additionalProperties", im.convert(val, kp))
+				}
+			}
+		case "items":
+			if typ == "tuple" {
+				continue
+			}
+			out.Set("items", im.convert(val, kp))
+		case "prefixItems":
+			list, _ := val.([]interface{})
+			var items []interface{}
+			for i, item := range list {
+				schema := im.convert(item, pkg.IndexPath(kp, i))
+				if obj, ok := item.(*pkg.Object); ok {
+					if title, ok := obj.Values["title"].(string); ok {
+						named, ok := schema.(*pkg.Object)
+						if !ok {
+							named = pkg.NewObject()
+							named.Set("type", schema)
+						}
+						named.Set("name", title)
+						schema = named
+					}
+				}
+				items = append(items, schema)
+			}
+			out.Set("items", items)
+		case "minItems", "maxItems":
+			if typ != "tuple" {
+				out.Set(key, val)
+			}
+		case "description":
+			if _, ok := val.(string); ok {
+				out.Set(key, val)
+			}
+		case "pattern":
+			if typ == "string" {
+				out.Set(key, val)
+			}
+		case "minLength", "maxLength", "uniqueItems", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			out.Set(key, val)
+		case "oneOf", "allOf", "anyOf":
+			list, _ := val.([]interface{})
+			var branches []interface{}
+			for i, item := range list {
+				if isNullSchema(item) && key == "anyOf" {
+					out.Set("nullable", true)
+					continue
+				}
+				branches = append(branches, im.convert(item, pkg.IndexPath(kp, i)))
+			}
+			if key == "anyOf" && len(branches) == 1 && in.Len() == 1 {
+				return withNullable(branches[0])
+			}
+			out.Set(key, branches)
+		case extensionPrefix + "target":
+			if obj, ok := val.(*pkg.Object); ok {
+				out.Set("target", im.convert(obj, kp))
+			} else {
+				out.Set("target", val)
+			}
+		case extensionPrefix + "after", extensionPrefix + "before":
+			if s, ok := val.(string); ok {
+				out.Set(strings.TrimPrefix(key, extensionPrefix), pkg.Timestamp(s))
+			}
+		default:
+			if keyword := strings.TrimPrefix(key, extensionPrefix); keyword != key {
+				if _, ok := constraintTypes[keyword]; ok {
+					out.Set(keyword, val)
+					continue
+				}
+			}
+			if !annotations[key] {
+				im.warnf(kp, "unsupported keyword %q dropped", key)
+			}
+		}
+	}
+
+	if out.Len() == 1 && out.Meta == nil && typ != "" {
+		return typ
+	}
+	return out
+}
+
+// typeOf maps a JSON Schema type, refined by x-shon-type and format, to a
+// .shos type. A type list containing "null" makes the schema nullable; any
+// further types are returned as anyOf branches.
+func (im *importer) typeOf(in *pkg.Object, path string) (string, bool, []interface{}) {
+	var jsonTypes []string
+	switch t := in.Values["type"].(type) {
+	case string:
+		jsonTypes = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				jsonTypes = append(jsonTypes, s)
+			}
+		}
+	}
+	nullable := false
+	var types []string
+	for _, t := range jsonTypes {
+		if t == "null" {
+			nullable = true
+		} else {
+			types = append(types, t)
+		}
+	}
+
+	if xt, ok := in.Values[extensionPrefix+"type"].(string); ok {
+		return xt, nullable, nil
+	}
+	switch len(types) {
+	case 0:
+		return "", nullable, nil
+	case 1:
+		return im.shosType(in, types[0], path), nullable, nil
+	}
+	var branches []interface{}
+	for _, t := range types {
+		branches = append(branches, im.shosType(in, t, path))
+	}
+	return "", nullable, branches
+}
+
+func (im *importer) shosType(in *pkg.Object, jsonType, path string) string {
+	switch jsonType {
+	case "string":
+		if f, _ := in.Values["format"].(string); f == "date-time" || f == "date" {
+			return "timestamp"
+		}
+		return "string"
+	case "integer", "number", "boolean":
+		return jsonType
+	case "array":
+		if _, ok := in.Values["prefixItems"]; ok {
+			return "tuple"
+		}
+		return "array"
+	case "object":
+		_, hasProps := in.Values["properties"]
+		if ap, ok := in.Values["additionalProperties"].(*pkg.Object); ok && ap != nil && !hasProps {
+			return "map"
+		}
+		return "struct"
+	}
+	im.warnf(path, "unsupported type %q dropped", jsonType)
+	return ""
+}
+
+// fromJSON converts an enum or default value to the SHON form of typ.
+func fromJSON(v interface{}, typ string) interface{} {
+	switch val := v.(type) {
+	case string:
+		switch typ {
+		case "decimal":
+			return pkg.Decimal(val)
+		case "timestamp":
+			return pkg.Timestamp(val)
+		case "ref":
+			return pkg.Ref(strings.TrimPrefix(val, "&"))
+		}
+	case []interface{}:
+		if typ == "tuple" {
+			return &pkg.Tuple{Items: val}
+		}
+	}
+	return v
+}
+
+func isNullSchema(v interface{}) bool {
+	obj, ok := v.(*pkg.Object)
+	return ok && obj.Len() == 1 && obj.Values["type"] == "null"
+}
+
+func withNullable(v interface{}) interface{} {
+	obj, ok := v.(*pkg.Object)
+	if !ok {
+		obj = pkg.NewObject()
+		obj.Set("type", v)
+	}
+	obj.Set("nullable", true)
+	return obj
+}
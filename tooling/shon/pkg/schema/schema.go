@@ -0,0 +1,662 @@
+// Package schema compiles .shos schema files into reusable validators for
+// SHON documents.
+package schema
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// DefinitionsNamespace is the reserved schema namespace holding named,
+// reusable definitions. Other schemas point at them with $ref:
+//
+//	@definitions {
+//	    address: { type: "struct", properties: { city: "string" } }
+//	}
+//
+//	@user {
+//	    home: { $ref: "address" },
+//	    work: { $ref: "common.shos#address" }
+//	}
+const DefinitionsNamespace = "definitions"
+
+// Schema is a compiled .shos file. It is immutable once compiled and safe
+// for concurrent use by multiple goroutines.
+type Schema struct {
+	version         string
+	schemaVersion   string
+	migrations      []*pkg.Migration
+	namespaces      []string
+	nodes           map[string]*node
+	definitions     map[string]*node
+	definitionOrder []string
+}
+
+// node is one compiled type definition. typ is empty when the node is
+// described only by a $ref or by composition keywords.
+type node struct {
+	typ                  string
+	description          string
+	nullable             bool
+	enum                 []interface{}
+	format               string
+	items                *node
+	tupleItems           []*node
+	tupleNames           []string
+	properties           map[string]*node
+	propertyOrder        []string
+	required             []string
+	values               *node
+	additionalProperties *node
+	closed               bool
+	constraints
+
+	// target is the definition named refName that $ref points at.
+	target  *node
+	refName string
+
+	oneOf, anyOf, allOf []*node
+
+	// def is the value FillDefaults uses when the field is missing.
+	def        interface{}
+	hasDefault bool
+
+	// refNamespace and refSchema restrict what a ref value may point at.
+	refNamespace string
+	refSchema    *node
+}
+
+var types = map[string]bool{
+	"string": true, "integer": true, "number": true, "decimal": true,
+	"boolean": true, "timestamp": true, "array": true, "tuple": true,
+	"struct": true, "map": true, "ref": true, "secret": true,
+}
+
+// typeAliases maps alternative spellings found in the spec to their types.
+var typeAliases = map[string]string{
+	"float": "number",
+	"bool":  "boolean",
+}
+
+// compiler holds the state for compiling one schema file. Every definition
+// gets its node before any is compiled, so definitions may refer to
+// themselves and to each other. files caches the definitions of every schema
+// file compiled so far, which also breaks $ref cycles between files.
+type compiler struct {
+	dir      string
+	defs     map[string]*node
+	files    map[string]map[string]*node
+	defaults []defaultValue
+}
+
+// defaultValue is a default waiting to be checked against its node once
+// every definition it may refer to has been compiled.
+type defaultValue struct {
+	n    *node
+	path string
+}
+
+// LoadFile reads and compiles a .shos file. Includes, aliases and constants
+// in the schema are resolved first, so constraints can use &const values.
+// $ref paths to other schema files are relative to this file.
+func LoadFile(path string) (*Schema, error) {
+	doc, _, err := pkg.LoadResolved(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return CompileFile(doc, path)
+}
+
+// CompileFile compiles a schema document read from path, which $ref paths
+// to other schema files are relative to. Use it for schema source that is
+// not on disk yet, such as an editor buffer.
+func CompileFile(doc *pkg.Document, path string) (*Schema, error) {
+	s, err := compileFile(doc, path, map[string]map[string]*node{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return s, nil
+}
+
+// Parse compiles schema source. Includes are not resolved and $ref paths to
+// other schema files are relative to the working directory.
+func Parse(data []byte) (*Schema, error) {
+	doc, err := pkg.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, _, err = pkg.Resolve(doc)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(doc)
+}
+
+// Compile builds a validator from a parsed schema document. Each namespace
+// describes the namespace of the same name in data documents, either as an
+// explicit type definition ({ type: "struct", properties: {...} }) or as a
+// plain list of fields ({ name: { type: "string" } }). The @definitions
+// namespace and namespaces declaring named tuples, such as
+// @Vec3 { type: "tuple", ... }, are not matched against documents; both may
+// be named wherever a type is expected, e.g. position: "Vec3".
+func Compile(doc *pkg.Document) (*Schema, error) {
+	return compileFile(doc, "", map[string]map[string]*node{})
+}
+
+func compileFile(doc *pkg.Document, path string, files map[string]map[string]*node) (*Schema, error) {
+	c := &compiler{dir: filepath.Dir(path), defs: map[string]*node{}, files: files}
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			files[abs] = c.defs
+		}
+	}
+
+	entries, err := definitionEntries(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.defs[e.name] = &node{}
+	}
+	for _, e := range entries {
+		n, err := c.compileValue(e.value, e.path)
+		if err != nil {
+			return nil, err
+		}
+		*c.defs[e.name] = *n
+	}
+	for _, e := range entries {
+		if chain := refCycle(c.defs[e.name], c.defs[e.name], map[*node]bool{}); chain != nil {
+			return nil, fmt.Errorf("%s: $ref cycle %s never reaches a struct, array, map or tuple", e.path, strings.Join(append([]string{e.name}, chain...), " -> "))
+		}
+	}
+
+	s := &Schema{nodes: map[string]*node{}, definitions: c.defs}
+	for _, e := range entries {
+		s.definitionOrder = append(s.definitionOrder, e.name)
+	}
+	if v, ok := doc.Meta.Get("schema"); ok {
+		s.version, _ = v.(string)
+	}
+	s.schemaVersion = pkg.DocumentVersion(doc)
+	if s.migrations, err = pkg.MigrationsFromDocument(doc); err != nil {
+		return nil, err
+	}
+	for _, ns := range doc.Namespaces {
+		if ns.Name == DefinitionsNamespace || ns.Name == pkg.MigrationsNamespace || isTupleDefinition(ns.Body) {
+			continue
+		}
+		n, err := c.compileNamespace(ns.Body, ns.Name)
+		if err != nil {
+			return nil, err
+		}
+		s.namespaces = append(s.namespaces, ns.Name)
+		s.nodes[ns.Name] = n
+	}
+	if err := c.checkDefaults(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// refCycle returns the $refs leading from n back to the definition start
+// without passing through a container, or nil if there are none. Such a
+// cycle describes a value only in terms of itself, so checking one would
+// never end. Refs and composition branches describe the same value as
+// their node; items, properties and values describe values inside it.
+func refCycle(n, start *node, seen map[*node]bool) []string {
+	if t := n.target; t != nil {
+		if t == start {
+			return []string{n.refName}
+		}
+		if !seen[t] {
+			seen[t] = true
+			if chain := refCycle(t, start, seen); chain != nil {
+				return append([]string{n.refName}, chain...)
+			}
+		}
+	}
+	for _, branches := range [][]*node{n.oneOf, n.anyOf, n.allOf} {
+		for _, b := range branches {
+			if chain := refCycle(b, start, seen); chain != nil {
+				return chain
+			}
+		}
+	}
+	return nil
+}
+
+// definitionEntry is one named definition, either from @definitions or a
+// named tuple declared as a namespace, e.g. @Vec3 { type: "tuple", ... }.
+type definitionEntry struct {
+	name  string
+	value interface{}
+	path  string
+}
+
+func definitionEntries(doc *pkg.Document) ([]definitionEntry, error) {
+	var entries []definitionEntry
+	seen := map[string]bool{}
+	if ns := doc.Namespace(DefinitionsNamespace); ns != nil {
+		for _, name := range ns.Body.Keys {
+			seen[name] = true
+			entries = append(entries, definitionEntry{name, ns.Body.Values[name], pkg.JoinPath(DefinitionsNamespace, name)})
+		}
+	}
+	for _, ns := range doc.Namespaces {
+		if ns.Name == DefinitionsNamespace || !isTupleDefinition(ns.Body) {
+			continue
+		}
+		if seen[ns.Name] {
+			return nil, fmt.Errorf("%s: tuple %s is also defined in @%s", ns.Name, ns.Name, DefinitionsNamespace)
+		}
+		entries = append(entries, definitionEntry{ns.Name, ns.Body, ns.Name})
+	}
+	return entries, nil
+}
+
+// isTupleDefinition reports whether a namespace declares a named tuple type
+// rather than describing a namespace of data documents.
+func isTupleDefinition(body *pkg.Object) bool {
+	return body.Values["type"] == "tuple"
+}
+
+// Version returns the spec version the schema declares in $schema.
+func (s *Schema) Version() string {
+	return s.version
+}
+
+// SchemaVersion returns the version of the schema itself, declared in
+// $version. Documents record the version they follow the same way.
+func (s *Schema) SchemaVersion() string {
+	return s.schemaVersion
+}
+
+// Migrations returns the schema's migration steps.
+func (s *Schema) Migrations() []*pkg.Migration {
+	return append([]*pkg.Migration(nil), s.migrations...)
+}
+
+// Namespaces returns the names of the namespaces the schema describes.
+func (s *Schema) Namespaces() []string {
+	return append([]string(nil), s.namespaces...)
+}
+
+func (c *compiler) compileNamespace(body *pkg.Object, path string) (*node, error) {
+	if isExplicit(body) {
+		return c.compileNode(body, path)
+	}
+	n := &node{typ: "struct", properties: map[string]*node{}}
+	for _, k := range body.Keys {
+		child, err := c.compileValue(body.Values[k], pkg.JoinPath(path, k))
+		if err != nil {
+			return nil, err
+		}
+		n.properties[k] = child
+		n.propertyOrder = append(n.propertyOrder, k)
+	}
+	return n, nil
+}
+
+// isExplicit reports whether a namespace body is a type definition rather
+// than a plain list of fields. A type alone is not enough, since a field
+// may be named type: the body must also set another keyword, and every
+// key must be one, as in { type: "decimal", minimum: 0 }.
+func isExplicit(body *pkg.Object) bool {
+	if _, ok := body.Values["type"].(string); ok && len(body.Keys) > 1 {
+		for _, k := range body.Keys {
+			if !schemaKeywords[k] {
+				return false
+			}
+		}
+		return true
+	}
+	if _, ok := body.GetMeta("ref"); ok {
+		return true
+	}
+	for _, k := range []string{"oneOf", "anyOf", "allOf"} {
+		if _, ok := body.Values[k].([]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaKeywords are the keys compileNode understands.
+var schemaKeywords = map[string]bool{
+	"type": true, "name": true, "description": true, "nullable": true,
+	"oneOf": true, "anyOf": true, "allOf": true, "default": true,
+	"target": true, "enum": true, "format": true, "items": true,
+	"properties": true, "required": true, "values": true,
+	"additionalProperties": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true,
+	"precision": true, "scale": true,
+	"minLength": true, "maxLength": true, "minItems": true,
+	"maxItems": true, "uniqueItems": true, "pattern": true,
+	"after": true, "before": true,
+}
+
+// compileValue compiles a schema given either as an object or as the
+// shorthand type name, e.g. items: "string".
+func (c *compiler) compileValue(v interface{}, path string) (*node, error) {
+	switch val := v.(type) {
+	case string:
+		typ, err := normalizeType(val, path)
+		if err != nil {
+			if target, ok := c.defs[val]; ok {
+				return &node{target: target, refName: val}, nil
+			}
+			return nil, err
+		}
+		return &node{typ: typ}, nil
+	case *pkg.Object:
+		return c.compileNode(val, path)
+	default:
+		return nil, fmt.Errorf("%s: expected a schema object or type name, found %s", path, pkg.TypeName(v))
+	}
+}
+
+func (c *compiler) compileNode(obj *pkg.Object, path string) (*node, error) {
+	n := &node{}
+	if raw, ok := obj.Values["type"]; ok {
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: type must be a string", path)
+		}
+		typ, err := normalizeType(s, path)
+		if err != nil {
+			return nil, err
+		}
+		n.typ = typ
+	}
+	if raw, ok := obj.GetMeta("ref"); ok {
+		if err := c.compileRef(n, raw, pkg.JoinPath(path, "$ref")); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range obj.Keys {
+		v := obj.Values[key]
+		kp := pkg.JoinPath(path, key)
+		switch key {
+		case "type", "name":
+			// name labels a tuple position; see compileItems.
+		case "description":
+			d, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: description must be a string", kp)
+			}
+			n.description = d
+		case "nullable":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: expected true or false", kp)
+			}
+			n.nullable = b
+		case "oneOf", "anyOf", "allOf":
+			branches, err := c.compileBranches(v, kp)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "oneOf":
+				n.oneOf = branches
+			case "anyOf":
+				n.anyOf = branches
+			default:
+				n.allOf = branches
+			}
+		case "default":
+			n.def = v
+			n.hasDefault = true
+			c.defaults = append(c.defaults, defaultValue{n: n, path: kp})
+		case "target":
+			if err := c.compileTarget(n, v, kp); err != nil {
+				return nil, err
+			}
+		case "enum":
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: enum must be an array", kp)
+			}
+			n.enum = items
+		case "format":
+			f, ok := v.(string)
+			if !ok || !validFormat(n.typ, f) {
+				return nil, fmt.Errorf("%s: unsupported format %s for %s", kp, pkg.EncodeValue(v, 0, pkg.EncodeOptions{}), n.typ)
+			}
+			n.format = f
+		case "items":
+			if err := c.compileItems(n, v, kp); err != nil {
+				return nil, err
+			}
+		case "properties":
+			props, ok := v.(*pkg.Object)
+			if !ok {
+				return nil, fmt.Errorf("%s: properties must be an object", kp)
+			}
+			n.properties = map[string]*node{}
+			for _, name := range props.Keys {
+				child, err := c.compileValue(props.Values[name], pkg.JoinPath(kp, name))
+				if err != nil {
+					return nil, err
+				}
+				n.properties[name] = child
+				n.propertyOrder = append(n.propertyOrder, name)
+			}
+		case "required":
+			names, err := stringList(v, kp)
+			if err != nil {
+				return nil, err
+			}
+			n.required = names
+		case "values":
+			child, err := c.compileValue(v, kp)
+			if err != nil {
+				return nil, err
+			}
+			n.values = child
+		case "additionalProperties":
+			switch ap := v.(type) {
+			case bool:
+				n.closed = !ap
+			default:
+				child, err := c.compileValue(v, kp)
+				if err != nil {
+					return nil, err
+				}
+				n.additionalProperties = child
+			}
+		default:
+			handled, err := compileConstraint(n, key, v, kp)
+			if err != nil {
+				return nil, err
+			}
+			if !handled {
+				return nil, fmt.Errorf("%s: unknown schema keyword %q", kp, key)
+			}
+		}
+	}
+
+	if err := compileTimes(n, path); err != nil {
+		return nil, err
+	}
+	if n.typ == "" && n.target == nil && n.oneOf == nil && n.anyOf == nil && n.allOf == nil {
+		return nil, fmt.Errorf("%s: missing type", path)
+	}
+	if n.closed {
+		for _, name := range n.required {
+			if _, ok := n.properties[name]; !ok {
+				return nil, fmt.Errorf("%s: required field %q is not a property", path, name)
+			}
+		}
+	}
+	return n, nil
+}
+
+// compileRef points n at the definition named by $ref: "name" for one in
+// this file, or "file.shos#name" for one in another schema file.
+func (c *compiler) compileRef(n *node, raw interface{}, path string) error {
+	ref, ok := raw.(string)
+	if !ok || ref == "" {
+		return fmt.Errorf("%s: $ref must be a string", path)
+	}
+	defs := c.defs
+	name := ref
+	if file, def, external := strings.Cut(ref, "#"); external {
+		var err error
+		if defs, err = c.loadDefinitions(file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		name = def
+	}
+	target, ok := defs[name]
+	if !ok {
+		return fmt.Errorf("%s: undefined definition %q", path, ref)
+	}
+	n.target = target
+	n.refName = name
+	return nil
+}
+
+// loadDefinitions compiles another schema file, relative to this one, and
+// returns its definitions.
+func (c *compiler) loadDefinitions(file string) (map[string]*node, error) {
+	path := filepath.FromSlash(file)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.dir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if defs, ok := c.files[abs]; ok {
+		return defs, nil
+	}
+	doc, _, err := pkg.LoadResolved(path, "")
+	if err != nil {
+		return nil, err
+	}
+	s, err := compileFile(doc, path, c.files)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s.definitions, nil
+}
+
+// compileTarget restricts a ref to a namespace, target: "people", or to
+// values matching a schema, target: { $ref: "person" }.
+func (c *compiler) compileTarget(n *node, v interface{}, path string) error {
+	if n.typ != "ref" {
+		return fmt.Errorf("%s: target does not apply to type %s", path, n.typ)
+	}
+	switch val := v.(type) {
+	case string:
+		n.refNamespace = val
+		return nil
+	case *pkg.Object:
+		child, err := c.compileNode(val, path)
+		if err != nil {
+			return err
+		}
+		n.refSchema = child
+		return nil
+	default:
+		return fmt.Errorf("%s: target must be a namespace name or a schema", path)
+	}
+}
+
+func (c *compiler) compileBranches(v interface{}, path string) ([]*node, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: expected a non-empty array of schemas", path)
+	}
+	var branches []*node
+	for i, item := range list {
+		child, err := c.compileValue(item, pkg.IndexPath(path, i))
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, child)
+	}
+	return branches, nil
+}
+
+func (c *compiler) compileItems(n *node, v interface{}, path string) error {
+	if n.typ == "tuple" {
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: tuple items must be an array", path)
+		}
+		for i, item := range list {
+			child, err := c.compileValue(item, pkg.IndexPath(path, i))
+			if err != nil {
+				return err
+			}
+			name := ""
+			if obj, ok := item.(*pkg.Object); ok {
+				name, _ = obj.Values["name"].(string)
+			}
+			n.tupleItems = append(n.tupleItems, child)
+			n.tupleNames = append(n.tupleNames, name)
+		}
+		return nil
+	}
+	child, err := c.compileValue(v, path)
+	if err != nil {
+		return err
+	}
+	n.items = child
+	return nil
+}
+
+func normalizeType(t, path string) (string, error) {
+	if alias, ok := typeAliases[t]; ok {
+		t = alias
+	}
+	if !types[t] {
+		return "", fmt.Errorf("%s: unknown type %q", path, t)
+	}
+	return t, nil
+}
+
+func validFormat(typ, format string) bool {
+	if typ != "timestamp" {
+		return false
+	}
+	_, ok := timestampFormats[format]
+	return ok
+}
+
+func stringList(v interface{}, path string) ([]string, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an array of strings", path)
+	}
+	var out []string
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an array of strings", path)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// PathFor returns the schema file a document at docPath declares with
+// $schema, resolved relative to the document's directory. It returns ""
+// if the document declares no schema.
+func PathFor(docPath string, doc *pkg.Document) string {
+	ref := doc.Schema()
+	if ref == "" {
+		return ""
+	}
+	if filepath.IsAbs(ref) {
+		return ref
+	}
+	return filepath.Join(filepath.Dir(docPath), filepath.FromSlash(ref))
+}
@@ -0,0 +1,396 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// timestampFormats maps the supported timestamp formats to Go layouts.
+var timestampFormats = map[string]string{
+	"iso8601":   time.RFC3339Nano,
+	"rfc3339":   time.RFC3339Nano,
+	"date-time": time.RFC3339Nano,
+	"date":      "2006-01-02",
+}
+
+// formatName returns the node's timestamp format, iso8601 if none is given.
+func (n *node) formatName() string {
+	if n.format == "" {
+		return "iso8601"
+	}
+	return n.format
+}
+
+// layout returns the Go layout for the node's timestamp format.
+func (n *node) layout() string {
+	return timestampFormats[n.formatName()]
+}
+
+// Validate checks a document against the schema. Every namespace the schema
+// describes must be present; namespaces the schema does not mention are not
+// checked. The document should already have its aliases and constants
+// resolved, as pkg.LoadResolved does.
+func (s *Schema) Validate(doc *pkg.Document) []pkg.Diagnostic {
+	v := &validator{doc: doc, following: map[pkg.Ref]bool{}}
+	if want, got := s.schemaVersion, pkg.DocumentVersion(doc); want != "" && got != "" && got != want {
+		v.warnf("", "document is at version %s, schema is at version %s; run shon migrate", got, want)
+	}
+	for _, name := range s.namespaces {
+		ns := doc.Namespace(name)
+		if ns == nil {
+			v.errorf("", "missing namespace @%s", name)
+			continue
+		}
+		v.check(s.nodes[name], ns.Body, name)
+	}
+	pkg.WalkDocument(doc, func(val interface{}, path string) error {
+		if t, ok := val.(*pkg.Tuple); ok && t.Name != "" {
+			v.checkNamedTuple(s, t, path)
+		}
+		return nil
+	})
+	return v.diags
+}
+
+// TupleFields returns the position names of the named tuple definition
+// name. It reports false unless every position has a name.
+func (s *Schema) TupleFields(name string) ([]string, bool) {
+	def, ok := s.definitions[name]
+	if !ok || def.typ != "tuple" || len(def.tupleNames) == 0 {
+		return nil, false
+	}
+	for _, f := range def.tupleNames {
+		if f == "" {
+			return nil, false
+		}
+	}
+	return append([]string(nil), def.tupleNames...), true
+}
+
+// checkNamedTuple checks a named tuple such as Vec3(1, 2, 3), wherever it
+// appears, against the tuple definition of the same name.
+func (v *validator) checkNamedTuple(s *Schema, t *pkg.Tuple, path string) {
+	def, ok := s.definitions[t.Name]
+	if !ok || def.typ != "tuple" {
+		v.warnf(path, "unknown tuple type %s", t.Name)
+		return
+	}
+	v.checkTuple(def, t, path)
+}
+
+// ValidateFile loads a SHON file, resolving includes, aliases and constants,
+// and validates it against the schema.
+func (s *Schema) ValidateFile(path string) ([]pkg.Diagnostic, error) {
+	doc, warnings, err := pkg.LoadResolved(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return append(warnings, s.Validate(doc)...), nil
+}
+
+// validator collects diagnostics for a single Validate call, which keeps the
+// compiled Schema free of mutable state. following holds the references
+// whose targets are being checked, so reference cycles end.
+type validator struct {
+	doc       *pkg.Document
+	following map[pkg.Ref]bool
+	diags     []pkg.Diagnostic
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, pkg.Diagnostic{Severity: pkg.SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(path, format string, args ...interface{}) {
+	v.diags = append(v.diags, pkg.Diagnostic{Severity: pkg.SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) check(n *node, value interface{}, path string) {
+	if value == nil && n.isNullable() {
+		return
+	}
+	switch value.(type) {
+	case *pkg.Env, *pkg.Expr:
+		// Not known until the document is interpolated; validate the
+		// interpolated document to check it.
+		return
+	}
+	if t, ok := value.(*pkg.Tuple); ok && t.Name != "" && n.target != nil && n.target.typ == "tuple" {
+		// Validate checks the items of every named tuple against its
+		// definition; here only the name has to agree.
+		if t.Name != n.refName {
+			v.errorf(path, "expected tuple %s, found tuple %s", n.refName, t.Name)
+		}
+	} else if n.target != nil {
+		v.check(n.target, value, path)
+	}
+	for i, branch := range n.allOf {
+		for _, d := range v.validateBranch(branch, value, path) {
+			d.Message = fmt.Sprintf("allOf[%d]: %s", i, d.Message)
+			v.diags = append(v.diags, d)
+		}
+	}
+	if n.anyOf != nil {
+		if failures := v.matchBranches(n.anyOf, value, path); len(failures) == len(n.anyOf) {
+			v.errorf(path, "value matches none of anyOf:%s", explain(n.anyOf, failures, path))
+		}
+	}
+	if n.oneOf != nil {
+		failures := v.matchBranches(n.oneOf, value, path)
+		switch matched := len(n.oneOf) - len(failures); {
+		case matched == 0:
+			v.errorf(path, "value matches none of oneOf:%s", explain(n.oneOf, failures, path))
+		case matched > 1:
+			v.errorf(path, "value matches %d oneOf branches, expected exactly one", matched)
+		}
+	}
+	if n.typ == "" || !v.checkType(n, value, path) {
+		return
+	}
+	if len(n.enum) > 0 && !inEnum(n.enum, value) {
+		v.errorf(path, "value %s is not one of %s", encode(value), encode(n.enum))
+	}
+	v.checkConstraints(n, value, path)
+
+	switch n.typ {
+	case "timestamp":
+		if _, err := time.Parse(n.layout(), string(value.(pkg.Timestamp))); err != nil {
+			v.errorf(path, "invalid timestamp %q", value)
+		}
+	case "array":
+		if n.items != nil {
+			for i, item := range value.([]interface{}) {
+				v.check(n.items, item, pkg.IndexPath(path, i))
+			}
+		}
+	case "tuple":
+		if t := value.(*pkg.Tuple); t.Name == "" {
+			v.checkTuple(n, t, path)
+		}
+	case "struct":
+		v.checkStruct(n, value.(*pkg.Object), path)
+	case "ref":
+		v.checkRef(n, value.(pkg.Ref), path)
+	case "map":
+		if n.values != nil {
+			obj := value.(*pkg.Object)
+			for _, k := range obj.Keys {
+				v.check(n.values, obj.Values[k], pkg.JoinPath(path, k))
+			}
+		}
+	}
+}
+
+// checkType reports whether value has the node's type, recording an error if
+// it does not.
+func (v *validator) checkType(n *node, value interface{}, path string) bool {
+	ok := false
+	switch n.typ {
+	case "string":
+		_, ok = value.(string)
+	case "integer":
+		num, isNum := value.(json.Number)
+		ok = isNum && isInteger(string(num))
+	case "number":
+		_, ok = value.(json.Number)
+	case "decimal":
+		_, ok = value.(pkg.Decimal)
+	case "boolean":
+		_, ok = value.(bool)
+	case "timestamp":
+		_, ok = value.(pkg.Timestamp)
+	case "secret":
+		_, ok = value.(pkg.Secret)
+	case "array":
+		_, ok = value.([]interface{})
+	case "tuple":
+		_, ok = value.(*pkg.Tuple)
+	case "struct", "map":
+		_, ok = value.(*pkg.Object)
+	case "ref":
+		_, ok = value.(pkg.Ref)
+	}
+	if !ok {
+		v.errorf(path, "expected %s, found %s", n.typ, describe(value))
+	}
+	return ok
+}
+
+func (v *validator) checkTuple(n *node, t *pkg.Tuple, path string) {
+	if n.tupleItems == nil {
+		return
+	}
+	if len(t.Items) != len(n.tupleItems) {
+		v.errorf(path, "expected %d tuple items, found %d", len(n.tupleItems), len(t.Items))
+		return
+	}
+	for i, item := range t.Items {
+		v.check(n.tupleItems[i], item, pkg.IndexPath(path, i))
+	}
+}
+
+// checkRef checks that a reference with a target resolves, stays within the
+// target namespace and points at a value matching the target schema.
+func (v *validator) checkRef(n *node, ref pkg.Ref, path string) {
+	if n.refNamespace == "" && n.refSchema == nil {
+		return
+	}
+	target := string(ref)
+	if n.refNamespace != "" {
+		if ns, _, _ := strings.Cut(strings.SplitN(target, "[", 2)[0], "."); ns != n.refNamespace {
+			v.errorf(path, "reference &%s must point into @%s", ref, n.refNamespace)
+			return
+		}
+	}
+	if v.doc == nil {
+		return
+	}
+	value, err := v.doc.Deref(ref)
+	if err != nil {
+		v.errorf(path, "dangling reference &%s", ref)
+		return
+	}
+	if n.refSchema == nil || v.following[ref] {
+		return
+	}
+	v.following[ref] = true
+	defer delete(v.following, ref)
+	if diags := v.validateBranch(n.refSchema, value, target); pkg.HasErrors(diags) {
+		v.errorf(path, "&%s does not match the target schema: %s", ref, reasons(errorsOnly(diags), target))
+	}
+}
+
+func (v *validator) checkStruct(n *node, obj *pkg.Object, path string) {
+	for _, name := range n.required {
+		if _, ok := obj.Get(name); !ok {
+			v.errorf(path, "missing required field %q", name)
+		}
+	}
+	for _, k := range obj.Keys {
+		child := obj.Values[k]
+		childPath := pkg.JoinPath(path, k)
+		if prop, ok := n.properties[k]; ok {
+			v.check(prop, child, childPath)
+			continue
+		}
+		switch {
+		case n.additionalProperties != nil:
+			v.check(n.additionalProperties, child, childPath)
+		case n.closed:
+			v.errorf(childPath, "unexpected field %q", k)
+		}
+	}
+}
+
+// isNullable reports whether null is accepted, either by the node itself or
+// by the definition it refers to.
+func (n *node) isNullable() bool {
+	for ; n != nil; n = n.target {
+		if n.nullable {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBranch checks a value against one composition branch without
+// recording anything on the caller's validator.
+func (v *validator) validateBranch(n *node, value interface{}, path string) []pkg.Diagnostic {
+	sub := &validator{doc: v.doc, following: v.following}
+	sub.check(n, value, path)
+	return sub.diags
+}
+
+// matchBranches returns the failures of every branch the value does not
+// match, keyed by branch index. Only errors fail a branch; warnings do not.
+func (v *validator) matchBranches(branches []*node, value interface{}, path string) map[int][]pkg.Diagnostic {
+	failures := map[int][]pkg.Diagnostic{}
+	for i, b := range branches {
+		if diags := v.validateBranch(b, value, path); pkg.HasErrors(diags) {
+			failures[i] = errorsOnly(diags)
+		}
+	}
+	return failures
+}
+
+// errorsOnly returns the error diagnostics in diags.
+func errorsOnly(diags []pkg.Diagnostic) []pkg.Diagnostic {
+	var out []pkg.Diagnostic
+	for _, d := range diags {
+		if d.Severity == pkg.SeverityError {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// explain lists why each failed branch rejected the value, one line per
+// branch, so the caller can see how close each alternative came.
+func explain(branches []*node, failures map[int][]pkg.Diagnostic, path string) string {
+	var b strings.Builder
+	for i, n := range branches {
+		diags, failed := failures[i]
+		if !failed {
+			continue
+		}
+		fmt.Fprintf(&b, "\n  %s: %s", branchLabel(n, i), reasons(diags, path))
+	}
+	return b.String()
+}
+
+// reasons joins diagnostics found below path into one line, naming each
+// by its path relative to path.
+func reasons(diags []pkg.Diagnostic, path string) string {
+	var out []string
+	for _, d := range diags {
+		reason := d.Message
+		if rel := strings.TrimPrefix(d.Path, path); rel != "" {
+			reason = strings.TrimPrefix(rel, ".") + ": " + reason
+		}
+		out = append(out, reason)
+	}
+	return strings.Join(out, "; ")
+}
+
+// branchLabel names a branch by its definition, or by position and type.
+func branchLabel(n *node, i int) string {
+	switch {
+	case n.refName != "":
+		return n.refName
+	case n.typ != "":
+		return fmt.Sprintf("branch %d (%s)", i, n.typ)
+	default:
+		return fmt.Sprintf("branch %d", i)
+	}
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if pkg.Equal(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInteger(s string) bool {
+	r, ok := new(big.Rat).SetString(s)
+	return ok && r.IsInt()
+}
+
+// describe names the type of a value for error messages.
+func describe(v interface{}) string {
+	if t, ok := v.(*pkg.Tuple); ok && t.Name != "" {
+		return "tuple " + t.Name
+	}
+	return pkg.TypeName(v)
+}
+
+func encode(v interface{}) string {
+	return pkg.EncodeValue(v, 0, pkg.EncodeOptions{})
+}
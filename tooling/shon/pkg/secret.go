@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretKeyEnv is the environment variable a secret key is read from when
+// no key file is given.
+const SecretKeyEnv = "SHON_SECRET_KEY"
+
+// RedactedSecret replaces secrets in JSON output unless they are revealed.
+const RedactedSecret = "[secret]"
+
+// ErrSecretDecrypt is returned when a secret cannot be decrypted because
+// the key is wrong or the value has been altered.
+var ErrSecretDecrypt = errors.New("cannot decrypt secret: wrong key or corrupted value")
+
+// GenerateSecretKey returns a new random 256-bit AES key, base64 encoded as
+// key files and SHON_SECRET_KEY hold it.
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseSecretKey decodes a base64 AES key of 16, 24 or 32 bytes.
+func ParseSecretKey(text string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("secret key is not base64: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("secret key must be 16, 24 or 32 bytes, found %d", len(key))
+}
+
+// LoadSecretKey reads the key in the file at path or, if path is "", in
+// SHON_SECRET_KEY. It returns nil without an error if neither is set.
+func LoadSecretKey(path string) ([]byte, error) {
+	if path == "" {
+		text := os.Getenv(SecretKeyEnv)
+		if text == "" {
+			return nil, nil
+		}
+		key, err := ParseSecretKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", SecretKeyEnv, err)
+		}
+		return key, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseSecretKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// EncryptSecret encrypts plaintext with AES-GCM under a random nonce for
+// the field at path, such as "db.password". The secret holds the nonce
+// followed by the sealed text. The path is authenticated along with it, so
+// the secret only decrypts at that path: copied to another field it fails
+// with ErrSecretDecrypt, and a field that is moved or renamed has to be
+// decrypted and encrypted again.
+func EncryptSecret(plaintext, path string, key []byte) (Secret, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), secretData(path))
+	return Secret(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// DecryptSecret decrypts a secret made by EncryptSecret for the field at
+// path.
+func DecryptSecret(s Secret, path string, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(s))
+	if err != nil || len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+		return "", fmt.Errorf("malformed secret")
+	}
+	n := gcm.NonceSize()
+	plaintext, err := gcm.Open(nil, sealed[:n], sealed[n:], secretData(path))
+	if err != nil {
+		return "", ErrSecretDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// secretData is the additional data a secret at path is sealed with: the
+// path as FormatPath writes it, so a path given as "&db.password" on the
+// command line matches the db.password a walk of the document reports.
+func secretData(path string) []byte {
+	if segs, err := ParsePath(path); err == nil {
+		path = FormatPath(segs)
+	}
+	return []byte(path)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// validSecret reports whether text could be a secret made by
+// EncryptSecret: base64 long enough for a nonce and a tag.
+func validSecret(text string) bool {
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	return err == nil && len(sealed) >= 12+16
+}
+
+// DecryptSecrets returns a copy of doc with every secret replaced by its
+// plaintext string.
+func DecryptSecrets(doc *Document, key []byte) (*Document, error) {
+	out := doc.Clone()
+	var first error
+	decrypt := func(v interface{}, path string) interface{} {
+		s, ok := v.(Secret)
+		if !ok || first != nil {
+			return v
+		}
+		plaintext, err := DecryptSecret(s, path, key)
+		if err != nil {
+			first = fmt.Errorf("%s: %w", pathOrRoot(path), err)
+			return v
+		}
+		return plaintext
+	}
+	out.Meta = Rewrite(out.Meta, "", decrypt).(*Object)
+	for _, ns := range out.Namespaces {
+		ns.Body = Rewrite(ns.Body, ns.Name, decrypt).(*Object)
+	}
+	if first != nil {
+		return nil, first
+	}
+	return out, nil
+}
+
+// EncryptSource replaces the strings at paths with secrets, leaving the
+// rest of the source, including comments, untouched.
+func EncryptSource(src []byte, paths []string, key []byte) ([]byte, error) {
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		v, err := doc.Lookup(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(Secret); ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: only strings can be encrypted, found %s", path, TypeName(v))
+		}
+		secret, err := EncryptSecret(s, path, key)
+		if err != nil {
+			return nil, err
+		}
+		if src, err = SetSource(src, path, secret); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// DecryptSource replaces the secrets at paths, or every secret if paths is
+// empty, with their plaintext strings.
+func DecryptSource(src []byte, paths []string, key []byte) ([]byte, error) {
+	doc, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		WalkDocument(doc, func(v interface{}, path string) error {
+			if _, ok := v.(Secret); ok {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+	}
+	for _, path := range paths {
+		v, err := doc.Lookup(path)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(Secret)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a secret, found %s", path, TypeName(v))
+		}
+		plaintext, err := DecryptSecret(s, path, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if src, err = SetSource(src, path, plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
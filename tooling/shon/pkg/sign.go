@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SignatureMeta is the top-level metadata field a signature embedded in a
+// document is stored in, as $signature: "ed25519:...". It is not part of
+// the signed content.
+const SignatureMeta = "signature"
+
+// SignaturePrefix starts every signature, naming its algorithm.
+const SignaturePrefix = "ed25519:"
+
+// SignatureExt is appended to a document's path to name its sidecar
+// signature file.
+const SignatureExt = ".sig"
+
+// ErrBadSignature is returned by Verify when a signature does not match the
+// document or the key.
+var ErrBadSignature = errors.New("signature does not match")
+
+// SignedContent returns the bytes a signature covers: the document's
+// canonical form without its $signature field. Sign documents loaded with
+// LoadFile, as shon sign does, so that the signature also covers what they
+// include; a document parsed on its own has only its @include paths.
+func SignedContent(doc *Document) []byte {
+	if _, ok := doc.Meta.Get(SignatureMeta); !ok {
+		return Canonicalize(doc)
+	}
+	unsigned := *doc
+	unsigned.Meta = Clone(doc.Meta).(*Object)
+	unsigned.Meta.Delete(SignatureMeta)
+	return Canonicalize(&unsigned)
+}
+
+// Sign signs a document's canonical form, ignoring any $signature it
+// already has.
+func Sign(doc *Document, key ed25519.PrivateKey) string {
+	return SignaturePrefix + base64.StdEncoding.EncodeToString(ed25519.Sign(key, SignedContent(doc)))
+}
+
+// Verify checks a signature made by Sign against a document, returning
+// ErrBadSignature if it does not match.
+func Verify(doc *Document, key ed25519.PublicKey, signature string) error {
+	text := strings.TrimSpace(signature)
+	if !strings.HasPrefix(text, SignaturePrefix) {
+		return fmt.Errorf("unsupported signature, expected %q followed by base64", SignaturePrefix)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, SignaturePrefix))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	}
+	if !ed25519.Verify(key, SignedContent(doc), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// EmbeddedSignature returns the document's $signature, or "" if it has
+// none.
+func EmbeddedSignature(doc *Document) string {
+	s, _ := doc.Meta.Values[SignatureMeta].(string)
+	return s
+}
+
+// GenerateSigningKey creates an ed25519 key pair, encoded as PEM.
+func GenerateSigningKey() (private, public []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	private = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	public = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return private, public, nil
+}
+
+// ParsePrivateKey reads a PEM encoded PKCS #8 ed25519 private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("expected a PEM \"PRIVATE KEY\" block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an ed25519 key, found %T", key)
+	}
+	return priv, nil
+}
+
+// ParsePublicKey reads a PEM encoded PKIX ed25519 public key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("expected a PEM \"PUBLIC KEY\" block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an ed25519 key, found %T", key)
+	}
+	return pub, nil
+}
@@ -0,0 +1,501 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Span is a byte range of SHON source; Start is inclusive and End exclusive.
+type Span struct {
+	Start, End int
+}
+
+// SourceEntry locates one value in SHON source. Key is the key, metadata
+// name or @namespace it is written under; it is empty for array and tuple
+// items.
+type SourceEntry struct {
+	Key   Span
+	Value Span
+}
+
+// SourceMap records where each value of a parsed document is written,
+// keyed by SHON path. Top-level metadata is keyed "$name".
+type SourceMap struct {
+	Entries map[string]SourceEntry
+	// Duplicates holds the earlier occurrences of keys written more than
+	// once in the same object; Entries holds the last, which is the one the
+	// document keeps.
+	Duplicates map[string][]SourceEntry
+	// Comments holds every // and /* */ comment, in source order.
+	Comments []Span
+	src      []byte
+}
+
+// ParseWithSourceMap parses SHON source like Parse and also returns where
+// each value was found, for tools that edit or annotate the source.
+func ParseWithSourceMap(data []byte) (*Document, *SourceMap, error) {
+	return ParseWithSourceMapLimits(data, Limits{})
+}
+
+// ParseWithSourceMapLimits parses like ParseWithSourceMap, returning a
+// LimitError if the source exceeds limits.
+func ParseWithSourceMapLimits(data []byte, limits Limits) (*Document, *SourceMap, error) {
+	if exceeds(len(data), limits.MaxSize) {
+		return nil, nil, &LimitError{Limit: "MaxSize", Max: limits.MaxSize}
+	}
+	p := &parser{src: data, limits: limits, spans: map[string]SourceEntry{}, duplicates: map[string][]SourceEntry{}}
+	doc, err := p.document()
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, &SourceMap{Entries: p.spans, Duplicates: p.duplicates, Comments: p.comments, src: data}, nil
+}
+
+// Position converts a byte offset into a 1-based line and column.
+func (m *SourceMap) Position(offset int) (line, col int) {
+	line, col = 1, 1
+	for i := 0; i < offset && i < len(m.src); i++ {
+		if m.src[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
+
+// Offset converts a 1-based line and column into a byte offset.
+func (m *SourceMap) Offset(line, col int) int {
+	l := 1
+	for i := 0; i < len(m.src); i++ {
+		if l == line {
+			if i+col-1 > len(m.src) {
+				return len(m.src)
+			}
+			return i + col - 1
+		}
+		if m.src[i] == '\n' {
+			l++
+		}
+	}
+	return len(m.src)
+}
+
+// editSource parses src, hands it to fn with its source map and splices in
+// the edit fn returns.
+func editSource(src []byte, fn func(doc *Document, m *SourceMap) (Span, string, error)) ([]byte, error) {
+	doc, m, err := ParseWithSourceMap(src)
+	if err != nil {
+		return nil, err
+	}
+	span, text, err := fn(doc, m)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(src)+len(text))
+	out = append(out, src[:span.Start]...)
+	out = append(out, text...)
+	return append(out, src[span.End:]...), nil
+}
+
+func (m *SourceMap) entry(path string) (SourceEntry, error) {
+	e, ok := m.Entries[path]
+	if !ok {
+		return SourceEntry{}, fmt.Errorf("path %s not found", path)
+	}
+	return e, nil
+}
+
+// canonicalPath rewrites a user supplied path into the form source map
+// keys use.
+func canonicalPath(path string) (string, error) {
+	segs, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+	return FormatPath(segs), nil
+}
+
+// SetSource replaces the value at path, leaving the rest of the source,
+// including comments, untouched.
+func SetSource(src []byte, path string, value interface{}) ([]byte, error) {
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		e, err := m.sourceEntry(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		return e.Value, EncodeValue(value, m.level(e.Value.Start), EncodeOptions{Indent: m.indentUnit()}), nil
+	})
+}
+
+// RenameSource renames the key at path.
+func RenameSource(src []byte, path, newKey string) ([]byte, error) {
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		e, err := m.sourceEntry(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		if e.Key.Start == e.Key.End {
+			return Span{}, "", fmt.Errorf("%s is not an object field", path)
+		}
+		return e.Key, encodeKey(newKey), nil
+	})
+}
+
+// RemoveSource deletes the field at path along with its separating comma.
+// Comments around the field are kept.
+func RemoveSource(src []byte, path string) ([]byte, error) {
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		e, err := m.sourceEntry(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		if e.Key.Start == e.Key.End {
+			return Span{}, "", fmt.Errorf("%s is not an object field", path)
+		}
+		start, end := e.Key.Start, skipBlanks(src, e.Value.End)
+		hasComma := end < len(src) && src[end] == ','
+		if hasComma {
+			end = skipBlanks(src, end+1)
+		}
+
+		lineStart := lineStartOf(src, start)
+		prev := bytes.LastIndexByte(src[:lineStart], ',')
+		switch {
+		case isBlank(src[lineStart:start]) && (end == len(src) || src[end] == '\n'):
+			// The field has its own line: remove the whole line, and the
+			// comma before it if it was the last field.
+			if end < len(src) {
+				end++
+			}
+			if !hasComma && prev >= 0 && len(bytes.TrimSpace(src[prev+1:lineStart])) == 0 && m.closesAt(end) {
+				return Span{prev, end}, string(src[prev+1 : lineStart]), nil
+			}
+			return Span{lineStart, end}, "", nil
+		case !hasComma && m.closesAt(e.Value.End):
+			// Last field written inline: drop the comma before it instead.
+			if prev := bytes.LastIndexByte(src[:start], ','); prev >= 0 && isBlank(src[prev+1:start]) {
+				return Span{prev, e.Value.End}, "", nil
+			}
+			return Span{start, e.Value.End}, "", nil
+		}
+		return Span{start, end}, "", nil
+	})
+}
+
+// ReplaceFieldSource replaces the field at path with fields, written where
+// it was: the first field takes over its comments and the others follow
+// it one per line, or inline if it was written inline, with the same
+// trailing commas as the rest of the object.
+func ReplaceFieldSource(src []byte, path string, fields *Object) ([]byte, error) {
+	return replaceField(src, path, fields, nil)
+}
+
+// replaceField is ReplaceFieldSource also writing comments, each on its
+// own line, above the first field.
+func replaceField(src []byte, path string, fields *Object, comments []string) ([]byte, error) {
+	if fields.Len() == 0 {
+		return nil, fmt.Errorf("no fields to replace %s with", path)
+	}
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		e, err := m.sourceEntry(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		if e.Key.Start == e.Key.End {
+			return Span{}, "", fmt.Errorf("%s is not an object field", path)
+		}
+		lineStart := lineStartOf(src, e.Key.Start)
+		indent := string(src[lineStart:skipBlanks(src, lineStart)])
+		level, unit := m.level(e.Key.Start), m.indentUnit()
+		texts := make([]string, fields.Len())
+		for i, k := range fields.Keys {
+			texts[i] = encodeKey(k) + ": " + EncodeValue(fields.Values[k], level, EncodeOptions{Indent: unit})
+		}
+
+		var sb strings.Builder
+		for _, c := range comments {
+			sb.WriteString(indent + c + "\n")
+		}
+		sb.WriteString(string(src[lineStart:e.Key.Start]))
+
+		lineEnd := len(src)
+		if i := bytes.IndexByte(src[e.Value.End:], '\n'); i >= 0 {
+			lineEnd = e.Value.End + i
+		}
+		rest := string(src[e.Value.End:lineEnd])
+		tail := strings.TrimLeft(strings.TrimPrefix(strings.TrimLeft(rest, " \t"), ","), " \t")
+		ownLine := isBlank(src[lineStart:e.Key.Start]) && (tail == "" || strings.HasPrefix(tail, "//") || strings.HasPrefix(tail, "/*"))
+		if !ownLine {
+			sb.WriteString(strings.Join(texts, ", "))
+			return Span{lineStart, e.Value.End}, sb.String(), nil
+		}
+
+		// The first field keeps the line and its trailing comment, and
+		// needs a comma if it had none and others follow.
+		hasComma := strings.HasPrefix(strings.TrimLeft(rest, " \t"), ",")
+		sb.WriteString(texts[0])
+		if !hasComma && len(texts) > 1 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(rest)
+		for i, text := range texts[1:] {
+			sb.WriteString("\n" + indent + text)
+			if hasComma || i < len(texts)-2 {
+				sb.WriteString(",")
+			}
+		}
+		return Span{lineStart, lineEnd}, sb.String(), nil
+	})
+}
+
+// removeFieldAndComments removes the field at path like RemoveSource,
+// along with the comments attached to it, and returns their text.
+func removeFieldAndComments(src []byte, path string) ([]byte, []string, error) {
+	_, m, err := ParseWithSourceMap(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := m.sourceEntry(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	leading, trailing := m.fieldComments(e)
+	var comments []string
+	for _, c := range leading {
+		comments = append(comments, string(src[c.Start:c.End]))
+	}
+	out := src
+	if trailing != nil {
+		comments = append(comments, string(src[trailing.Start:trailing.End]))
+		// Cut the comment and the blanks before it.
+		start := trailing.Start
+		for start > e.Value.End && (src[start-1] == ' ' || src[start-1] == '\t') {
+			start--
+		}
+		out = append(append([]byte(nil), src[:start]...), src[trailing.End:]...)
+	}
+	if len(leading) > 0 {
+		from, to := lineStartOf(src, leading[0].Start), lineStartOf(src, e.Key.Start)
+		out = append(append([]byte(nil), out[:from]...), out[to:]...)
+	}
+	out, err = RemoveSource(out, path)
+	return out, comments, err
+}
+
+// fieldComments returns the comments attached to a field written on its
+// own line: the comment lines directly above it and a comment after it on
+// the same line.
+func (m *SourceMap) fieldComments(e SourceEntry) (leading []Span, trailing *Span) {
+	src := m.src
+	lineStart := lineStartOf(src, e.Key.Start)
+	if !isBlank(src[lineStart:e.Key.Start]) {
+		return nil, nil
+	}
+	pos := lineStart
+	for i := len(m.Comments) - 1; i >= 0; i-- {
+		c := m.Comments[i]
+		if c.Start >= e.Value.End {
+			after := strings.TrimLeft(string(src[e.Value.End:c.Start]), " \t")
+			if trailing == nil && !strings.Contains(after, "\n") && strings.TrimLeft(strings.TrimPrefix(after, ","), " \t") == "" {
+				trailing = &Span{c.Start, c.End}
+			}
+			continue
+		}
+		if c.End > pos {
+			continue
+		}
+		between := src[c.End:pos]
+		if bytes.Count(between, []byte("\n")) != 1 || len(bytes.TrimSpace(between)) != 0 || !isBlank(src[lineStartOf(src, c.Start):c.Start]) {
+			break
+		}
+		leading = append([]Span{c}, leading...)
+		pos = lineStartOf(src, c.Start)
+	}
+	return leading, trailing
+}
+
+// InsertSource adds key: value as the last field of the object at path.
+// The field is written on its own line when the object spans several lines
+// and inline otherwise.
+func InsertSource(src []byte, path, key string, value interface{}) ([]byte, error) {
+	return insertSource(src, path, key, func(level, unit int) string {
+		return EncodeValue(value, level, EncodeOptions{Indent: unit})
+	})
+}
+
+// insertSource adds a field whose text, written at the given indentation
+// level, text returns.
+func insertSource(src []byte, path, key string, text func(level, unit int) string) ([]byte, error) {
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		e, err := m.sourceEntry(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		obj, err := doc.Lookup(path)
+		if err != nil {
+			return Span{}, "", err
+		}
+		o, ok := obj.(*Object)
+		if !ok || src[e.Value.Start] != '{' {
+			return Span{}, "", fmt.Errorf("%s is not an object", path)
+		}
+		if _, exists := o.Get(key); exists {
+			return Span{}, "", fmt.Errorf("%s already has a field %q", path, key)
+		}
+		return m.insertField(e, o, path, key, text)
+	})
+}
+
+// SetMetaSource sets top-level metadata such as $version, adding it after
+// the existing metadata, or at the top of the file, if it is not there.
+func SetMetaSource(src []byte, key string, value interface{}) ([]byte, error) {
+	return editSource(src, func(doc *Document, m *SourceMap) (Span, string, error) {
+		text := EncodeValue(value, 0, EncodeOptions{})
+		if e, ok := m.Entries["$"+key]; ok {
+			return e.Value, text, nil
+		}
+		line := fmt.Sprintf("$%s: %s\n", key, text)
+		last := -1
+		for _, k := range doc.Meta.Keys {
+			if e, ok := m.Entries["$"+k]; ok && e.Value.End > last {
+				last = e.Value.End
+			}
+		}
+		if last < 0 {
+			if (len(doc.Namespaces) > 0 || len(doc.Includes) > 0) && !bytes.HasPrefix(src, []byte("\n")) {
+				line += "\n"
+			}
+			return Span{0, 0}, line, nil
+		}
+		end := bytes.IndexByte(src[last:], '\n')
+		if end < 0 {
+			return Span{len(src), len(src)}, "\n" + strings.TrimSuffix(line, "\n"), nil
+		}
+		return Span{last + end + 1, last + end + 1}, line, nil
+	})
+}
+
+func (m *SourceMap) sourceEntry(path string) (SourceEntry, error) {
+	canon, err := canonicalPath(path)
+	if err != nil {
+		return SourceEntry{}, err
+	}
+	return m.entry(canon)
+}
+
+func (m *SourceMap) insertField(e SourceEntry, o *Object, path, key string, text func(level, unit int) string) (Span, string, error) {
+	src := m.src
+	closing := e.Value.End - 1
+	unit := m.indentUnit()
+
+	var last *SourceEntry
+	for _, k := range append(append([]string(nil), o.Keys...), metaKeys(o)...) {
+		if child, ok := m.Entries[JoinPath(path, k)]; ok && (last == nil || child.Value.End > last.Value.End) {
+			c := child
+			last = &c
+		}
+	}
+
+	if last == nil {
+		// Empty object: write the field inline, unless it spans lines.
+		level := m.level(e.Value.Start)
+		value := text(level+1, unit)
+		if !strings.Contains(value, "\n") {
+			return e.Value, fmt.Sprintf("{ %s: %s }", encodeKey(key), value), nil
+		}
+		indent := string(src[lineStartOf(src, e.Value.Start):skipBlanks(src, lineStartOf(src, e.Value.Start))])
+		field := fmt.Sprintf("{\n%s%s%s: %s\n%s}", indent, strings.Repeat(" ", unit), encodeKey(key), value, indent)
+		return e.Value, field, nil
+	}
+
+	level := m.level(last.Key.Start)
+	field := fmt.Sprintf("%s: %s", encodeKey(key), text(level, unit))
+	after := skipBlanks(src, last.Value.End)
+	hasComma := after < len(src) && src[after] == ','
+	lineEnd := bytes.IndexByte(src[last.Value.End:], '\n')
+
+	if lineEnd < 0 || last.Value.End+lineEnd > closing {
+		// The object closes on the same line: continue inline.
+		if hasComma {
+			return Span{after + 1, after + 1}, " " + field + ",", nil
+		}
+		return Span{last.Value.End, last.Value.End}, ", " + field, nil
+	}
+
+	indent := string(src[lineStartOf(src, last.Key.Start):last.Key.Start])
+	insertAt := last.Value.End + lineEnd
+	line := "\n" + indent + field
+	if !hasComma {
+		// Add the missing separator after the last value, then the field at
+		// the end of its line so a trailing comment stays where it was.
+		tail := string(src[last.Value.End:insertAt])
+		return Span{last.Value.End, insertAt}, "," + tail + line, nil
+	}
+	return Span{insertAt, insertAt}, line, nil
+}
+
+func metaKeys(o *Object) []string {
+	if o.Meta == nil {
+		return nil
+	}
+	var keys []string
+	for _, k := range o.Meta.Keys {
+		keys = append(keys, "$"+k)
+	}
+	return keys
+}
+
+// closesAt reports whether the next thing after offset, skipping
+// whitespace, is a closing bracket. Commas are optional, so this tells the
+// last field of an object from one followed by another.
+func (m *SourceMap) closesAt(offset int) bool {
+	rest := bytes.TrimLeft(m.src[offset:], " \t\r\n")
+	return len(rest) > 0 && (rest[0] == '}' || rest[0] == ']')
+}
+
+// level is the indentation level of the line containing offset.
+func (m *SourceMap) level(offset int) int {
+	start := lineStartOf(m.src, offset)
+	width := 0
+	for _, c := range m.src[start:offset] {
+		switch c {
+		case '\t':
+			width += m.indentUnit()
+		case ' ':
+			width++
+		default:
+			return width / m.indentUnit()
+		}
+	}
+	return width / m.indentUnit()
+}
+
+// indentUnit guesses the number of spaces per indentation level from the
+// first indented line, defaulting to 4.
+func (m *SourceMap) indentUnit() int {
+	for _, line := range bytes.Split(m.src, []byte("\n")) {
+		n := len(line) - len(bytes.TrimLeft(line, " "))
+		if n > 0 && n < len(line) {
+			return n
+		}
+	}
+	return 4
+}
+
+func lineStartOf(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+func skipBlanks(src []byte, i int) int {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+func isBlank(b []byte) bool {
+	return len(bytes.TrimLeft(b, " \t")) == 0
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagExpr is a parsed tag filter such as "public,!internal". Comma separated
+// terms must all hold; a term may list alternatives with '|' and be negated
+// with a leading '!'. For example "public|beta,!internal" matches objects
+// tagged public or beta that are not tagged internal.
+type TagExpr struct {
+	terms []tagTerm
+}
+
+type tagTerm struct {
+	negate bool
+	any    []string
+}
+
+// ParseTagExpr parses a tag filter expression.
+func ParseTagExpr(s string) (TagExpr, error) {
+	var expr TagExpr
+	for _, raw := range strings.Split(s, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		term := tagTerm{}
+		if strings.HasPrefix(raw, "!") {
+			term.negate = true
+			raw = strings.TrimSpace(raw[1:])
+		}
+		for _, tag := range strings.Split(raw, "|") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				return TagExpr{}, fmt.Errorf("empty tag in expression %q", s)
+			}
+			term.any = append(term.any, tag)
+		}
+		expr.terms = append(expr.terms, term)
+	}
+	if len(expr.terms) == 0 {
+		return TagExpr{}, fmt.Errorf("empty tag expression")
+	}
+	return expr, nil
+}
+
+// Match reports whether a set of tags satisfies the expression.
+func (e TagExpr) Match(tags []string) bool {
+	set := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		set[t] = true
+	}
+	for _, term := range e.terms {
+		found := false
+		for _, t := range term.any {
+			if set[t] {
+				found = true
+				break
+			}
+		}
+		if found == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterTags returns a copy of doc without the objects whose $tags do not
+// match expr. Objects without $tags are structural and always kept, so a
+// filter only ever removes tagged objects (and everything inside them),
+// whether they are fields, array items or tuple arguments. A namespace
+// whose body has non-matching $tags is removed whole.
+func FilterTags(doc *Document, expr TagExpr) *Document {
+	out := doc.Clone()
+	kept := out.Namespaces[:0]
+	for _, ns := range out.Namespaces {
+		if _, keep := filterTags(ns.Body, expr); keep {
+			kept = append(kept, ns)
+		}
+	}
+	out.Namespaces = kept
+	return out
+}
+
+// filterTags prunes non-matching tagged objects beneath v and reports whether
+// v itself should be kept.
+func filterTags(v interface{}, expr TagExpr) (interface{}, bool) {
+	switch val := v.(type) {
+	case *Object:
+		if _, tagged := val.GetMeta("tags"); tagged && !expr.Match(val.Tags()) {
+			return nil, false
+		}
+		for _, k := range append([]string(nil), val.Keys...) {
+			child, keep := filterTags(val.Values[k], expr)
+			if !keep {
+				val.Delete(k)
+				continue
+			}
+			val.Values[k] = child
+		}
+		return val, true
+	case []interface{}:
+		out := val[:0]
+		for _, item := range val {
+			if child, keep := filterTags(item, expr); keep {
+				out = append(out, child)
+			}
+		}
+		return out, true
+	case *Tuple:
+		items := val.Items[:0]
+		for _, item := range val.Items {
+			if child, keep := filterTags(item, expr); keep {
+				items = append(items, child)
+			}
+		}
+		val.Items = items
+		return val, true
+	default:
+		return v, true
+	}
+}
+
+// FilterFile writes the objects of the SHON file at inputPath that match the
+// tag expression to outputPath (stdout if empty). Includes are resolved
+// within root, as LoadFile does, so their objects are filtered too and the
+// output holds them inline.
+func FilterFile(inputPath, root, outputPath, expr string, opts EncodeOptions) error {
+	tagExpr, err := ParseTagExpr(expr)
+	if err != nil {
+		return err
+	}
+	doc, err := LoadFile(inputPath, root)
+	if err != nil {
+		return err
+	}
+	return WriteFile(FilterTags(doc, tagExpr), outputPath, opts)
+}
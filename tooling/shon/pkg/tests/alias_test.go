@@ -0,0 +1,102 @@
+package pkg_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+const aliasDoc = `@alias {
+	addr: address
+}
+
+@address {
+	hq: { city: "Palm Springs" }
+}
+
+@person {
+	// keep this comment
+	sean: { office: &addr.hq, note: "&addr.hq stays a string" }
+}`
+
+func TestAliasDeref(t *testing.T) {
+	doc, err := pkg.Parse([]byte(aliasDoc))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	office, err := doc.Lookup("person.sean.office")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	target, err := doc.Deref(office.(pkg.Ref))
+	if err != nil {
+		t.Fatalf("Deref failed: %v", err)
+	}
+	if city, _ := target.(*pkg.Object).Get("city"); city != "Palm Springs" {
+		t.Errorf("alias resolved to wrong value: %v", city)
+	}
+
+	resolved, diags := pkg.ResolveAliases(doc)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	if ref, _ := resolved.Lookup("person.sean.office"); ref != pkg.Ref("address.hq") {
+		t.Errorf("reference not canonicalised: %v", ref)
+	}
+}
+
+func TestAliasDiagnostics(t *testing.T) {
+	doc, err := pkg.Parse([]byte(`@alias {
+	person: address,
+	a: address,
+	b: a,
+	c: nowhere
+}
+@address { hq: 1 }
+@person { sean: 1 }`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	_, diags := doc.Aliases()
+	want := []string{"collides with namespace", "points at alias a", "unknown namespace @nowhere"}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %v", len(want), diags)
+	}
+	for i, w := range want {
+		if !strings.Contains(diags[i].Message, w) {
+			t.Errorf("diagnostic %d = %q, want %q", i, diags[i].Message, w)
+		}
+	}
+}
+
+func TestExpandAliasesSource(t *testing.T) {
+	out, diags, err := pkg.ExpandAliasesSource([]byte(aliasDoc))
+	if err != nil || len(diags) != 0 {
+		t.Fatalf("ExpandAliasesSource failed: %v %v", err, diags)
+	}
+	result := string(out)
+	if strings.Contains(result, "@alias") {
+		t.Error("@alias block not removed")
+	}
+	for _, want := range []string{"office: &address.hq", `"&addr.hq stays a string"`, "// keep this comment"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expanded source missing %q:\n%s", want, result)
+		}
+	}
+}
+
+func TestShonToJsonAliases(t *testing.T) {
+	in := writeTempFile(t, "input.shon", aliasDoc)
+	out := filepath.Join(t.TempDir(), "output.json")
+
+	if err := pkg.ShonToJson(in, out); err != nil {
+		t.Fatalf("ShonToJson failed: %v", err)
+	}
+	if result := readFile(t, out); !strings.Contains(result, `"office": "&address.hq"`) {
+		t.Errorf("alias not expanded in JSON:\n%s", result)
+	}
+}
@@ -0,0 +1,156 @@
+package pkg_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func shonToJSON(file string) string {
+	if filepath.Ext(file) == ".shon" {
+		return "json"
+	}
+	return ""
+}
+
+func TestPlanBatch(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"in/a.shon", "in/notes.txt", "in/x/b.shon", "in/x/y/c.shon", "in/z/c.shon", "in/.git/d.shon"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(`@a { b: 1 }`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+
+	jobs, err := pkg.PlanBatch([]string{in}, out, false, shonToJSON)
+	if err != nil {
+		t.Fatalf("PlanBatch failed: %v", err)
+	}
+	want := []pkg.ConvertJob{
+		{Input: filepath.Join(in, "a.shon"), Output: filepath.Join(out, "a.json")},
+		{Input: filepath.Join(in, "x/b.shon"), Output: filepath.Join(out, "x/b.json")},
+		{Input: filepath.Join(in, "x/y/c.shon"), Output: filepath.Join(out, "x/y/c.json")},
+		{Input: filepath.Join(in, "z/c.shon"), Output: filepath.Join(out, "z/c.json")},
+	}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("directory: got %v, want %v", jobs, want)
+	}
+
+	jobs, err = pkg.PlanBatch([]string{filepath.Join(in, "x/**/*.shon")}, out, false, shonToJSON)
+	if err != nil {
+		t.Fatalf("PlanBatch failed: %v", err)
+	}
+	want = []pkg.ConvertJob{
+		{Input: filepath.Join(in, "x/b.shon"), Output: filepath.Join(out, "b.json")},
+		{Input: filepath.Join(in, "x/y/c.shon"), Output: filepath.Join(out, "y/c.json")},
+	}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("pattern: got %v, want %v", jobs, want)
+	}
+
+	jobs, err = pkg.PlanBatch([]string{filepath.Join(in, "*.shon")}, "", false, shonToJSON)
+	if err != nil || len(jobs) != 1 || jobs[0].Output != filepath.Join(in, "a.json") {
+		t.Errorf("expected a.json next to a.shon, got %v, %v", jobs, err)
+	}
+
+	_, err = pkg.PlanBatch([]string{filepath.Join(in, "x/y"), filepath.Join(in, "z")}, out, false, shonToJSON)
+	if err == nil || !strings.Contains(err.Error(), "both be written") {
+		t.Errorf("expected an output collision, got %v", err)
+	}
+	if _, err := pkg.PlanBatch([]string{filepath.Join(in, "missing")}, out, false, shonToJSON); err == nil {
+		t.Error("expected an error for a missing input")
+	}
+}
+
+func TestPlanBatchSources(t *testing.T) {
+	convertible := func(file string) string {
+		switch filepath.Ext(file) {
+		case ".shon":
+			return "json"
+		case ".json":
+			return "shon"
+		}
+		return ""
+	}
+	dir := t.TempDir()
+	writeFileIn(t, dir, "a.json", `{"b": 1}`)
+	writeFileIn(t, dir, "a.shon", `@a { b: 2 }`)
+
+	for _, overwrite := range []bool{false, true} {
+		_, err := pkg.PlanBatch([]string{dir}, "", overwrite, convertible)
+		if err == nil || !strings.Contains(err.Error(), "is an input") {
+			t.Errorf("overwrite %v: expected an output that is an input to be refused, got %v", overwrite, err)
+		}
+	}
+
+	pattern := filepath.Join(dir, "*.json")
+	_, err := pkg.PlanBatch([]string{pattern}, "", false, convertible)
+	if err == nil || !strings.Contains(err.Error(), "overwrite the source file") {
+		t.Errorf("expected a.shon not to be overwritten, got %v", err)
+	}
+	if jobs, err := pkg.PlanBatch([]string{pattern}, "", true, convertible); err != nil || len(jobs) != 1 {
+		t.Errorf("expected --overwrite to allow writing a.shon, got %v, %v", jobs, err)
+	}
+	if jobs, err := pkg.PlanBatch([]string{pattern}, filepath.Join(dir, "out"), false, convertible); err != nil || len(jobs) != 1 {
+		t.Errorf("expected an output directory to be allowed, got %v, %v", jobs, err)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	var jobs []pkg.ConvertJob
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		jobs = append(jobs, pkg.ConvertJob{Input: name, Output: filepath.Join(dir, name, name+".json")})
+	}
+
+	var running, most int32
+	results := pkg.RunBatch(jobs, 2, func(job pkg.ConvertJob) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		if job.Input == "c" {
+			return errors.New("broken")
+		}
+		return os.WriteFile(job.Output, []byte("{}"), 0644)
+	})
+
+	if most > 2 {
+		t.Errorf("%d conversions ran at once with 2 workers", most)
+	}
+	for i, r := range results {
+		if r.ConvertJob != jobs[i] {
+			t.Errorf("result %d is for %v, want %v", i, r.ConvertJob, jobs[i])
+		}
+		if (r.Err != nil) != (r.Input == "c") {
+			t.Errorf("%s: unexpected error %v", r.Input, r.Err)
+		}
+	}
+	if _, err := os.Stat(jobs[4].Output); err != nil {
+		t.Errorf("output directory not created: %v", err)
+	}
+
+	// Without a worker count, RunBatch uses one per CPU.
+	var seen int32
+	results = pkg.RunBatch(jobs, 0, func(job pkg.ConvertJob) error {
+		atomic.AddInt32(&seen, 1)
+		return nil
+	})
+	if seen != int32(len(jobs)) || len(results) != len(jobs) {
+		t.Errorf("expected every job to run with the default workers, ran %d", seen)
+	}
+}
@@ -0,0 +1,78 @@
+package pkg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func TestCanonicalize(t *testing.T) {
+	a := mustParse(t, `$schema: "u.shos"
+// a comment
+@user {
+    name: "Ada",   price: $decimal("001.50"), n: 1.0e2,
+    born: $timestamp("1815-12-10T09:00:00.500+01:00"),
+    local: $timestamp("1815-12-10T09:00:00.000"),
+    v: Vec3(1, 2, 3), tags: [3, 2,], r: &color.red,
+    $tags: ["b"], "odd key": null, tiny: -0.000000012, big: 12e30
+}
+@color { red: "#f00" }`)
+	b := mustParse(t, `@color{red:"#f00"}
+$schema:"u.shos"
+@user{big:1.2e31,tiny:-1.2e-8,"odd key":null,$tags:["b"],r:&color.red,tags:[3,2],v:Vec3(1,2,3),
+local:$timestamp("1815-12-10T09:00:00"),born:$timestamp("1815-12-10T08:00:00.5Z"),n:100,price:$decimal("+1.5"),name:"Ada"}`)
+
+	want := `$schema:"u.shos"
+@color{red:"#f00"}
+@user{$tags:["b"],big:1.2e31,born:$timestamp("1815-12-10T08:00:00.5Z"),local:$timestamp("1815-12-10T09:00:00"),n:100,name:"Ada","odd key":null,price:$decimal("1.5"),r:&color.red,tags:[3,2],tiny:-1.2e-8,v:Vec3(1,2,3)}
+`
+	if got := string(pkg.Canonicalize(a)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if pkg.Hash(a) != pkg.Hash(b) {
+		t.Errorf("differently formatted documents hash differently:\n%s\n%s", pkg.Canonicalize(a), pkg.Canonicalize(b))
+	}
+	if h := pkg.Hash(a); !strings.HasPrefix(h, pkg.HashPrefix) || len(h) != len(pkg.HashPrefix)+64 {
+		t.Errorf("unexpected hash %q", h)
+	}
+
+	again := mustParse(t, want)
+	if string(pkg.Canonicalize(again)) != want {
+		t.Errorf("canonical form does not round-trip")
+	}
+
+	changed := mustParse(t, strings.Replace(want, `"Ada"`, `"Augusta"`, 1))
+	if pkg.Hash(changed) == pkg.Hash(a) {
+		t.Error("a changed value should change the hash")
+	}
+	reordered := mustParse(t, strings.Replace(want, "[3,2]", "[2,3]", 1))
+	if pkg.Hash(reordered) == pkg.Hash(a) {
+		t.Error("array order should change the hash")
+	}
+}
+
+func TestHashDistinguishesUnequal(t *testing.T) {
+	pairs := [][2]string{
+		{`@d { "$tags": ["x"] }`, `@d { $tags: ["x"] }`},
+		{`@d { "$x": 1 }`, `@d { $x: 1 }`},
+		{`@d { a: { "$type": "t" } }`, `@d { a: { $type: "t" } }`},
+		{`@d { a: "1" }`, `@d { a: 1 }`},
+		{`@d { a: $decimal("1") }`, `@d { a: 1 }`},
+		{`@d { a: [1, 2] }`, `@d { a: T(1, 2) }`},
+	}
+	for _, pair := range pairs {
+		a, b := mustParse(t, pair[0]), mustParse(t, pair[1])
+		if pkg.Equal(a.Namespace("d").Body, b.Namespace("d").Body) {
+			t.Errorf("%s and %s should not be equal", pair[0], pair[1])
+			continue
+		}
+		if pkg.Hash(a) == pkg.Hash(b) {
+			t.Errorf("%s and %s are not equal but hash the same:\n%s", pair[0], pair[1], pkg.Canonicalize(a))
+		}
+		again := mustParse(t, string(pkg.Canonicalize(a)))
+		if !pkg.Equal(a.Namespace("d").Body, again.Namespace("d").Body) {
+			t.Errorf("canonical form of %s does not parse to an equal document", pair[0])
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package pkg_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/lint"
+)
+
+func TestProjectConfig(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"project/schemas", "project/data/nested", "other"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rc := filepath.Join(dir, "project", pkg.ProjectConfigFile)
+	if err := os.WriteFile(rc, []byte(`// project settings
+@settings {
+	indentation: 2,
+	sort: true,
+	output: "shon",
+	schemaPaths: ["schemas"],
+	includeRoots: [".", "data"]
+}
+
+@lint {
+	trailingComma: "off"
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "project/schemas/user.shos"), []byte(`@user { name: "string" }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := pkg.FindProjectConfig(filepath.Join(dir, "project/data/nested")); found != rc {
+		t.Errorf("FindProjectConfig = %q, want %q", found, rc)
+	}
+	if found := pkg.FindProjectConfig(filepath.Join(dir, "other")); found != "" && strings.HasPrefix(found, dir) {
+		t.Errorf("unexpected config %q outside the project", found)
+	}
+
+	c, err := pkg.LoadProjectConfig(rc)
+	if err != nil {
+		t.Fatalf("LoadProjectConfig failed: %v", err)
+	}
+	project := filepath.Join(dir, "project")
+	if c.Indentation != 2 || !c.SortKeys || c.OutputFormat != "shon" {
+		t.Errorf("unexpected settings %+v", c)
+	}
+	if !reflect.DeepEqual(c.SchemaPaths, []string{filepath.Join(project, "schemas")}) {
+		t.Errorf("unexpected schema paths %v", c.SchemaPaths)
+	}
+	if root := c.IncludeRoot(filepath.Join(project, "data/nested/x.shon")); root != filepath.Join(project, "data") {
+		t.Errorf("expected the innermost include root, got %q", root)
+	}
+	if root := c.IncludeRoot(filepath.Join(dir, "other/x.shon")); root != "" {
+		t.Errorf("expected no include root outside the project, got %q", root)
+	}
+	if p := c.FindSchema(filepath.Join(project, "data/user.shos")); p != filepath.Join(project, "schemas/user.shos") {
+		t.Errorf("schema not found in schema paths: %q", p)
+	}
+
+	lc := lint.DefaultConfig()
+	if err := lc.Apply(c.Lint); err != nil || !lc.Rules["trailingComma"].Off {
+		t.Errorf("lint rules not applied: %v", err)
+	}
+
+	shown := pkg.Encode(c.Document(), pkg.EncodeOptions{})
+	again, err := pkg.ParseProjectConfig([]byte(shown), "/elsewhere")
+	if err != nil {
+		t.Fatalf("shown config does not parse: %v\n%s", err, shown)
+	}
+	again.Path = c.Path
+	if !reflect.DeepEqual(again, c) {
+		t.Errorf("shown config differs:\n%s", shown)
+	}
+}
+
+func TestProjectConfigErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		`@settings { indentation: "two" }`: "settings.indentation",
+		`@settings { output: "yaml" }`:     "json, shon",
+		`@settings { colour: true }`:       "unknown setting",
+		`@settings { schemaPaths: "x" }`:   "array",
+		`@format { indent: 2 }`:            "unknown namespace @format",
+	} {
+		if _, err := pkg.ParseProjectConfig([]byte(src), "."); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", src, want, err)
+		}
+	}
+}
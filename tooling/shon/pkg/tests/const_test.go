@@ -0,0 +1,65 @@
+package pkg_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func TestResolveConstants(t *testing.T) {
+	doc, err := pkg.Parse([]byte(`@const {
+	US_PHONE_PATTERN: "^\d{3}-\d{3}-\d{4}$",
+	THEME_DEFAULT: "dark",
+	THEME: { name: &const.THEME_DEFAULT },
+	UNUSED: 42
+}
+
+@settings {
+	theme: &const.THEME.name,
+	phonePattern: &const.US_PHONE_PATTERN,
+	missing: &const.NOPE
+}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	out, diags := pkg.ResolveConstants(doc)
+
+	if theme, _ := out.Lookup("settings.theme"); theme != "dark" {
+		t.Errorf("nested constant not substituted: %v", theme)
+	}
+	if pattern, _ := out.Lookup("settings.phonePattern"); pattern != `^\d{3}-\d{3}-\d{4}$` {
+		t.Errorf("pattern constant not substituted: %v", pattern)
+	}
+	if out.Namespace(pkg.ConstNamespace) != nil {
+		t.Error("@const namespace should be removed after resolution")
+	}
+
+	var undefined, unused bool
+	for _, d := range diags {
+		if d.Severity == pkg.SeverityError && strings.Contains(d.Message, "undefined constant NOPE") && d.Path == "settings.missing" {
+			undefined = true
+		}
+		if d.Severity == pkg.SeverityWarning && strings.Contains(d.Message, "UNUSED") {
+			unused = true
+		}
+	}
+	if !undefined || !unused {
+		t.Errorf("expected undefined and unused diagnostics, got %v", diags)
+	}
+}
+
+func TestShonToJsonConstants(t *testing.T) {
+	in := writeTempFile(t, "input.shon", `@const { THEME_DEFAULT: "dark" }
+@settings { theme: &const.THEME_DEFAULT }`)
+	out := filepath.Join(t.TempDir(), "output.json")
+
+	if err := pkg.ShonToJson(in, out); err != nil {
+		t.Fatalf("ShonToJson failed: %v", err)
+	}
+	if result := readFile(t, out); !strings.Contains(result, `"theme": "dark"`) {
+		t.Errorf("constant not substituted in JSON:\n%s", result)
+	}
+}
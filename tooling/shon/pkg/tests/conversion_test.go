@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
 )
 
 func writeTempFile(t *testing.T, name, content string) string {
@@ -20,6 +21,15 @@ func writeTempFile(t *testing.T, name, content string) string {
 	return tmp
 }
 
+func writeFileIn(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return path
+}
+
 func readFile(t *testing.T, path string) string {
 	t.Helper()
 	data, err := os.ReadFile(path)
@@ -173,4 +183,93 @@ Darcy,5678 2nd Ave,Engineer`
 	if !strings.Contains(result, "&address.") {
 		t.Error("references not generated")
 	}
+	doc, err := pkg.Parse([]byte(result))
+	if err != nil {
+		t.Fatalf("CSVToShon output does not parse: %v\n%s", err, result)
+	}
+	ref, _ := doc.Lookup("input.records[1].address")
+	if target, ok := ref.(pkg.Ref); !ok {
+		t.Errorf("expected Ellie's address to be a reference, got %v", ref)
+	} else if v, err := doc.Lookup(string(target)); err != nil || v != "1234 Main St" {
+		t.Errorf("expected &%s to be Ellie's address, got %v, %v", target, v, err)
+	}
+}
+
+func TestJsonToShonWithSchema(t *testing.T) {
+	s, err := schema.Parse([]byte(`@definitions {
+	point: { type: "tuple", items: ["number", "number"] }
+}
+
+@order {
+	note: "string",
+	price: "decimal",
+	fee: "decimal",
+	placed: "timestamp",
+	at: { $ref: "point" },
+	owner: "ref",
+	lines: { type: "array", items: { type: "struct", properties: { amount: "decimal" } } }
+}`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	in := writeTempFile(t, "order.json", `{
+	"note": "Tea: 1.5",
+	"price": "1042.75",
+	"fee": 0.5,
+	"placed": "2025-03-22T14:45:00Z",
+	"at": [1.5, 2],
+	"owner": "&people.sean",
+	"lines": [{ "amount": "3.10" }]
+}`)
+	out := filepath.Join(t.TempDir(), "order.shon")
+	opts := pkg.ConvertOptions{Schema: s, SchemaRef: "order.shos"}
+	if err := pkg.JsonToShonWithOptions(in, out, opts); err != nil {
+		t.Fatalf("JsonToShon failed: %v", err)
+	}
+
+	want := `$schema: "order.shos"
+
+@order {
+    note: "Tea: 1.5",
+    price: $decimal("1042.75"),
+    fee: $decimal("0.5"),
+    placed: $timestamp("2025-03-22T14:45:00Z"),
+    at: point(1.5, 2),
+    owner: &people.sean,
+    lines: [
+        {
+            amount: $decimal("3.10")
+        }
+    ]
+}
+`
+	if got := readFile(t, out); got != want {
+		t.Errorf("unexpected SHON:\n%s", got)
+	}
+}
+
+func TestJsonToShonWithSchemaErrors(t *testing.T) {
+	s, err := schema.Parse([]byte(`@order { price: "decimal", owner: "ref", placed: "timestamp", qty: "integer" }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	in := writeTempFile(t, "order.json", `{ "price": "cheap", "owner": "sean", "placed": "Tea: 1.5", "qty": "3" }`)
+	out := filepath.Join(t.TempDir(), "order.shon")
+
+	err = pkg.JsonToShonWithOptions(in, out, pkg.ConvertOptions{Schema: s})
+	if err == nil {
+		t.Fatal("expected conversion to fail")
+	}
+	for _, want := range []string{`order.price: "cheap" is not a decimal`, `order.owner: "sean" is not a reference`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q: %v", want, err)
+		}
+	}
+
+	in = writeTempFile(t, "order.json", `{ "price": "1", "owner": "&a.b", "placed": "Tea: 1.5", "qty": "3" }`)
+	err = pkg.JsonToShonWithOptions(in, out, pkg.ConvertOptions{Schema: s})
+	if err == nil || !strings.Contains(err.Error(), `order.placed: invalid timestamp`) || !strings.Contains(err.Error(), "order.qty: expected integer, found string") {
+		t.Errorf("expected validation errors, got %v", err)
+	}
 }
@@ -0,0 +1,97 @@
+package pkg_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+func TestUnmarshal(t *testing.T) {
+	var user struct {
+		ID      string      `json:"id"`
+		Balance pkg.Decimal `shon:"balance"`
+		Origin  [2]float64
+		Tags    []string
+		Manager pkg.Ref
+		Extra   interface{}
+		Skipped string `shon:"-"`
+	}
+	err := pkg.Unmarshal([]byte(`@user {
+	id: "001",
+	balance: $decimal("1042.75"),
+	origin: $tuple(1.5, 2),
+	tags: ["dev", "golang"],
+	manager: &people.ellie,
+	extra: { when: $timestamp("2025-03-22T14:45:00Z"), big: 12345678901234567890, list: [1, "a", true, null], at: Vec2(1, 2) },
+	skipped: "x"
+}`), &user)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if user.ID != "001" || user.Balance != "1042.75" || user.Origin != [2]float64{1.5, 2} ||
+		len(user.Tags) != 2 || user.Manager != "people.ellie" || user.Skipped != "" {
+		t.Errorf("unexpected result: %+v", user)
+	}
+	var want interface{}
+	if err := json.Unmarshal([]byte(`{"when": "2025-03-22T14:45:00Z", "big": 12345678901234567890, "list": [1, "a", true, null], "at": [1, 2]}`), &want); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(user.Extra, want) {
+		t.Errorf("empty interface should get what encoding/json gives:\ngot  %#v\nwant %#v", user.Extra, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var v struct{ Age int }
+	err := pkg.Unmarshal([]byte(`@user { age: "old" }`), &v)
+	if err == nil || !strings.Contains(err.Error(), "age: cannot decode string into int") {
+		t.Errorf("expected decode error, got %v", err)
+	}
+	if err := pkg.Unmarshal([]byte(`@user { age: 1 }`), v); err == nil {
+		t.Error("expected error for non-pointer target")
+	}
+}
+
+func TestShonToJsonFillDefaults(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFileIn(t, dir, "service.shon", `@service { name: "api" }`)
+	s, err := schema.Parse([]byte(`@service { name: "string", port: { type: "integer", default: 8080 } }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	output := filepath.Join(dir, "service.json")
+	if err := pkg.ShonToJsonWithOptions(input, output, pkg.ConvertOptions{Defaults: s}); err != nil {
+		t.Fatalf("ShonToJson failed: %v", err)
+	}
+	want := "{\n  \"name\": \"api\",\n  \"port\": 8080\n}"
+	if got := readFile(t, output); got != want {
+		t.Errorf("unexpected JSON:\n%s", got)
+	}
+}
+
+func TestUnmarshalTupleIntoStruct(t *testing.T) {
+	type vec3 struct {
+		X, Y, Z float64
+	}
+	var scene struct {
+		Camera vec3
+		Path   []vec3
+	}
+	err := pkg.Unmarshal([]byte(`@scene { camera: Vec3(1, 2.5, 3), path: [Vec3(0, 0, 0), $tuple(1, 1, 1)] }`), &scene)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if scene.Camera != (vec3{1, 2.5, 3}) || len(scene.Path) != 2 || scene.Path[1] != (vec3{1, 1, 1}) {
+		t.Errorf("unexpected result: %+v", scene)
+	}
+
+	var short struct{ Camera vec3 }
+	if err := pkg.Unmarshal([]byte(`@scene { camera: Vec3(1, 2) }`), &short); err == nil || !strings.Contains(err.Error(), "cannot decode 2 tuple items") {
+		t.Errorf("expected arity error, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package pkg_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// The fuzz targets run their seeds under go test; run them with random
+// input using, for example:
+//
+//	go test ./pkg/tests -run '^$' -fuzz FuzzParse -fuzztime 1m
+
+var fuzzSeeds = []string{
+	``,
+	`@a { x: 1 }`,
+	`$schema: "x.shos" @include "b.shon" @a { $tags: ["t"], "odd key": [1, -2.5e3, true, null] }`,
+	`@const { N: 2 } @alias { s: server } @server { port: &const.N, self: &s.port }`,
+	`@a { d: $decimal("1.50"), t: $timestamp("2024-01-01T00:00:00+02:00"), v: Vec3(1, 2, 3), u: $tuple("a") }`,
+	`@a { e: $env("HOME", "/"), n: $env("N", 1), x: $expr("(&a.n + 2) * 3 / 4"), s: $expr("'a' + &a.e") }`,
+	`@a { s: $secret("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA==") }`,
+	"// comment\n/* block */ @a {\n  x: \"\\u00e9\\n\", // trailing\n}",
+	`@a { x: [[[[{ y: { z: [] } }]]]] }`,
+	`@a { x: "unterminated }`,
+	`@a { x: $expr("((1") }`,
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkg.FormatSource(data, 4, false)
+		pkg.FormatSource(data, 4, true)
+
+		doc, err := pkg.ParseWithLimits(data, pkg.DefaultLimits)
+		if err != nil {
+			return
+		}
+		canon := pkg.Canonicalize(doc)
+		again, err := pkg.Parse(canon)
+		if err != nil {
+			t.Fatalf("canonical form does not parse: %v\n%s", err, canon)
+		}
+		if recanon := pkg.Canonicalize(again); !bytes.Equal(recanon, canon) {
+			t.Fatalf("canonical form is not stable:\n%s\n%s", canon, recanon)
+		}
+		encoded := pkg.Encode(doc, pkg.EncodeOptions{})
+		if _, err := pkg.Parse([]byte(encoded)); err != nil {
+			t.Fatalf("encoded document does not parse: %v\n%s", err, encoded)
+		}
+		if _, err := pkg.MarshalJSON(pkg.DocumentToJSON(doc, pkg.ConvertOptions{KeepMeta: true}), "  "); err != nil {
+			t.Fatalf("JSON conversion failed: %v", err)
+		}
+
+		resolved, _, err := pkg.ResolveWithLimits(doc, pkg.DefaultLimits)
+		if err != nil {
+			return
+		}
+		pkg.Interpolate(resolved, pkg.InterpolateOptions{
+			LookupEnv: func(string) (string, bool) { return "", false },
+			Limits:    pkg.DefaultLimits,
+		})
+	})
+}
+
+func FuzzParseExpr(f *testing.F) {
+	for _, s := range []string{"1 + 2 * 3", "-(&a.b - 'x')", `"a" + 1.5e3 / (2 - -1)`, "((", "&", "1e"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		e, err := pkg.ParseExpr(text)
+		if err != nil {
+			return
+		}
+		formatted := pkg.FormatExpr(e)
+		again, err := pkg.ParseExpr(formatted)
+		if err != nil {
+			t.Fatalf("FormatExpr(ParseExpr(%q)) = %q does not parse: %v", text, formatted, err)
+		}
+		if !pkg.Equal(again, e) {
+			t.Fatalf("%q does not round-trip through %q", text, formatted)
+		}
+	})
+}
+
+func FuzzConvert(f *testing.F) {
+	for _, s := range []string{
+		`{}`,
+		`{"a": 1, "b": [true, null, "x"], "c": {"d": "1.50", "e": "2024-01-01T00:00:00Z"}}`,
+		`{"odd key": "quote \" and \\ backslash", "$meta": -0.25}`,
+		`[1, 2, 3]`,
+		`"text"`,
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := pkg.ParseJSON(data); err != nil {
+			return
+		}
+		dir := t.TempDir()
+		in := filepath.Join(dir, "in.json")
+		shon := filepath.Join(dir, "out.shon")
+		out := filepath.Join(dir, "out.json")
+		if err := os.WriteFile(in, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := pkg.ConvertOptions{Quiet: true, Limits: pkg.DefaultLimits}
+		if err := pkg.JsonToShonWithOptions(in, shon, opts); err != nil {
+			return
+		}
+		written, err := os.ReadFile(shon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pkg.Parse(written); err != nil {
+			t.Fatalf("SHON converted from %s does not parse: %v\n%s", data, err, written)
+		}
+		if err := pkg.ShonToJsonWithOptions(shon, out, opts); err != nil {
+			t.Fatalf("SHON converted from %s does not convert back: %v\n%s", data, err, written)
+		}
+	})
+}
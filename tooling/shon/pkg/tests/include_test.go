@@ -0,0 +1,168 @@
+package pkg_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func TestLoadIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.shon": {Data: []byte(`$schema: "./main.shos"
+@include "./shared/address.shon"
+
+@address {
+	home: { city: "Indio" }
+}
+
+@person {
+	sean: { address: &address.hq }
+}`)},
+		"shared/address.shon": {Data: []byte(`@include "../common.shon"
+@address {
+	hq: { city: "Palm Springs" },
+	home: { city: "Overridden" }
+}`)},
+		"common.shon": {Data: []byte(`@country { us: "United States" }`)},
+	}
+
+	doc, err := pkg.NewLoader(fsys).Load("main.shon")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if city, _ := doc.Lookup("address.hq.city"); city != "Palm Springs" {
+		t.Errorf("included namespace not merged: %v", city)
+	}
+	if city, _ := doc.Lookup("address.home.city"); city != "Indio" {
+		t.Errorf("including file should win on conflicts, got %v", city)
+	}
+	if doc.Namespace("country") == nil {
+		t.Error("nested include not loaded")
+	}
+	if doc.Schema() != "./main.shos" {
+		t.Errorf("unexpected schema %q", doc.Schema())
+	}
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.shon": {Data: []byte(`@include "b.shon"
+@a { x: 1 }`)},
+		"b.shon": {Data: []byte(`@include "a.shon"
+@b { y: 2 }`)},
+	}
+
+	_, err := pkg.NewLoader(fsys).Load("a.shon")
+	if !errors.Is(err, pkg.ErrIncludeCycle) {
+		t.Fatalf("expected include cycle, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "a.shon -> b.shon -> a.shon") {
+		t.Errorf("error does not show include chain: %v", err)
+	}
+}
+
+func TestLoadIncludeOutsideRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.shon": {Data: []byte(`@include "../../etc/secrets.shon"
+@a { x: 1 }`)},
+	}
+
+	_, err := pkg.NewLoader(fsys).Load("main.shon")
+	if err == nil || !strings.Contains(err.Error(), "escapes the include root") {
+		t.Fatalf("expected traversal to be rejected, got %v", err)
+	}
+}
+
+func TestShonToJsonWithInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFileIn(t, dir, "address.shon", `@address { hq: { city: "Palm Springs" } }`)
+	in := writeFileIn(t, dir, "main.shon", `@include "address.shon"
+@person { sean: { address: &address.hq } }`)
+	out := filepath.Join(dir, "main.json")
+
+	if err := pkg.ShonToJson(in, out); err != nil {
+		t.Fatalf("ShonToJson failed: %v", err)
+	}
+
+	result := readFile(t, out)
+	if !strings.Contains(result, `"city": "Palm Springs"`) {
+		t.Errorf("included namespace missing from JSON:\n%s", result)
+	}
+}
+
+func TestLoadFileSymlinkOutsideRoot(t *testing.T) {
+	outside := t.TempDir()
+	writeFileIn(t, outside, "secrets.shon", `@secrets { key: "hunter2" }`)
+	root := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "linked")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	in := writeFileIn(t, root, "main.shon", `@include "linked/secrets.shon"
+@a { x: 1 }`)
+
+	if _, err := pkg.LoadFile(in, ""); err == nil {
+		t.Fatal("expected a symlink out of the include root to be rejected")
+	}
+}
+
+func TestLoadIncludeMetadata(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.shon":   {Data: []byte(`@include "shared.shon" @a { x: 1 }`)},
+		"shared.shon": {Data: []byte(`$schema: "shared.shos" $version: "2" @b { y: 2 }`)},
+		"typed.shon":  {Data: []byte(`$schema: "typed.shos" @include "shared.shon"`)},
+	}
+
+	doc, err := pkg.NewLoader(fsys).Load("main.shon")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(doc.Meta.Keys) != 0 || doc.Namespace("b") == nil {
+		t.Errorf("expected b without the included file's metadata, got %v", doc.Meta.Keys)
+	}
+	doc, err = pkg.NewLoader(fsys).Load("typed.shon")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if doc.Schema() != "typed.shos" || len(doc.Meta.Keys) != 1 {
+		t.Errorf("expected only the root file's metadata, got %v", doc.Meta.Keys)
+	}
+}
+
+func TestLoadIncludeNamespaceMetadata(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.shon": {Data: []byte(`@include "flags.shon"
+@flags { $type: "main", y: 2 }
+@other { z: 3 }`)},
+		"flags.shon": {Data: []byte(`@flags { $tags: ["beta"], $type: "shared", x: 1 }`)},
+	}
+
+	doc, err := pkg.NewLoader(fsys).Load("main.shon")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	body := doc.Namespace("flags").Body
+	if tags := body.Tags(); len(tags) != 1 || tags[0] != "beta" {
+		t.Errorf("included namespace $tags lost, got %v", tags)
+	}
+	if typ, _ := body.GetMeta("type"); typ != "main" {
+		t.Errorf("including file should win on metadata conflicts, got %v", typ)
+	}
+	if body.Len() != 2 {
+		t.Errorf("expected x and y, got %v", body.Keys)
+	}
+
+	expr, err := pkg.ParseTagExpr("!beta")
+	if err != nil {
+		t.Fatalf("ParseTagExpr failed: %v", err)
+	}
+	filtered := pkg.FilterTags(doc, expr)
+	if filtered.Namespace("flags") != nil || filtered.Namespace("other") == nil {
+		t.Errorf("expected only flags to be filtered out, got %d namespaces", len(filtered.Namespaces))
+	}
+}
@@ -0,0 +1,160 @@
+package pkg_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+const interpolateDoc = `@const { BASE: "https://api.example.com", PORT: 8000 }
+@alias { s: server }
+@server {
+    host: $env("DB_HOST", "localhost"),
+    port: $env("DB_PORT", &const.PORT),
+    debug: $env("DEBUG", false),
+    user: $env("DB_USER"),
+    url: $expr("'http://' + &s.host + ':' + &server.port"),
+    workers: $expr("(&server.port - 8000) * 2 + 1"),
+    api: $expr("&const.BASE + '/v1'"),
+    fee: $expr("&server.price * 2"),
+    price: $decimal("1.25")
+}`
+
+type serverConfig struct {
+	Host    string
+	Port    int
+	Debug   bool
+	User    string
+	URL     string
+	Workers int
+	API     string
+	Fee     pkg.Decimal
+}
+
+func envFrom(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	var cfg serverConfig
+	opts := pkg.DecodeOptions{LookupEnv: envFrom(map[string]string{"DB_USER": "app"})}
+	if err := pkg.UnmarshalWithOptions([]byte(interpolateDoc), &cfg, opts); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := serverConfig{Host: "localhost", Port: 8000, User: "app", URL: "http://localhost:8000", Workers: 1, API: "https://api.example.com/v1", Fee: "2.5"}
+	if cfg != want {
+		t.Errorf("defaults: got %+v, want %+v", cfg, want)
+	}
+
+	opts.LookupEnv = envFrom(map[string]string{"DB_USER": "app", "DB_HOST": "db", "DB_PORT": "8080", "DEBUG": "true"})
+	if err := pkg.UnmarshalWithOptions([]byte(interpolateDoc), &cfg, opts); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if cfg.Host != "db" || cfg.Port != 8080 || !cfg.Debug || cfg.URL != "http://db:8080" || cfg.Workers != 161 {
+		t.Errorf("environment: got %+v", cfg)
+	}
+
+	for vars, wantErr := range map[string]string{
+		"":             "DB_USER is not set",
+		"DB_PORT=high": `"high", expected a number`,
+		"DEBUG=maybe":  "expected a boolean",
+	} {
+		env := map[string]string{"DB_USER": "app"}
+		if name, value, ok := strings.Cut(vars, "="); ok {
+			env[name] = value
+		} else {
+			delete(env, "DB_USER")
+		}
+		err := pkg.UnmarshalWithOptions([]byte(interpolateDoc), &cfg, pkg.DecodeOptions{LookupEnv: envFrom(env)})
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: expected an error mentioning %q, got %v", vars, wantErr, err)
+		}
+	}
+
+	err := pkg.UnmarshalWithOptions([]byte(interpolateDoc), &cfg, pkg.DecodeOptions{NoInterpolation: true})
+	if !errors.Is(err, pkg.ErrInterpolationDisabled) {
+		t.Errorf("expected ErrInterpolationDisabled, got %v", err)
+	}
+
+	for src, wantErr := range map[string]string{
+		`@a { x: $expr("&a.y + 1"), y: $expr("&a.x") }`: "too many references",
+		`@a { x: $expr("1 / (2 - 2)") }`:                "division by zero",
+		`@a { x: $expr("&a.y * 2"), y: "two" }`:         "cannot apply *",
+		`@a { x: $expr("&a.y + 1"), y: [1] }`:           "scalars",
+		`@a { x: $expr("&b.nope") }`:                    "unresolved reference",
+	} {
+		var out map[string]interface{}
+		if err := pkg.Unmarshal([]byte(src), &out); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", src, wantErr, err)
+		}
+	}
+}
+
+func TestInterpolateSharedReferences(t *testing.T) {
+	// Each field refers to the next one twice, so evaluating a field
+	// afresh every time it is referenced takes 2^60 steps.
+	var sb strings.Builder
+	sb.WriteString("@a {")
+	for i := 0; i < 60; i++ {
+		sb.WriteString(fmt.Sprintf(" f%d: $expr(\"&a.f%d + &a.f%d\"),", i, i+1, i+1))
+	}
+	sb.WriteString(" f60: 1 }")
+	doc := mustParse(t, sb.String())
+
+	out, err := pkg.Interpolate(doc, pkg.InterpolateOptions{Limits: pkg.Limits{MaxExpansion: 1000}})
+	if err != nil {
+		t.Fatalf("Interpolate failed: %v", err)
+	}
+	if v, _ := out.Lookup("a.f0"); v != json.Number("1152921504606846976") {
+		t.Errorf("a.f0 = %v, want 2^60", v)
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	for text, want := range map[string]string{
+		"1+2*3":              "1 + 2 * 3",
+		"(1 + 2) * 3":        "(1 + 2) * 3",
+		"1 - (2 - 3)":        "1 - (2 - 3)",
+		"(1 - 2) - 3":        "1 - 2 - 3",
+		`-&a.b + "it's"`:     `-&a.b + 'it\'s'`,
+		"&a.list[0] / 2.5e1": "&a.list[0] / 2.5e1",
+		"-(1 + 2)":           "-(1 + 2)",
+		"true":               "true",
+	} {
+		e, err := pkg.ParseExpr(text)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) failed: %v", text, err)
+			continue
+		}
+		if got := pkg.FormatExpr(e); got != want {
+			t.Errorf("FormatExpr(ParseExpr(%q)) = %q, want %q", text, got, want)
+		}
+		again, err := pkg.ParseExpr(pkg.FormatExpr(e))
+		if err != nil || !pkg.Equal(again, e) {
+			t.Errorf("%q does not round-trip: %v", text, err)
+		}
+	}
+	for _, bad := range []string{"", "1 +", "(1", "x + 1", "'open", "1 2", "&", "1..2"} {
+		if _, err := pkg.ParseExpr(bad); err == nil {
+			t.Errorf("expected ParseExpr(%q) to fail", bad)
+		}
+	}
+
+	doc := mustParse(t, `@a { x: $expr("&a.y+1"), y: $env("Y", 2), z: $env("Z") }`)
+	again := mustParse(t, pkg.Encode(doc, pkg.EncodeOptions{}))
+	if !pkg.Equal(again.Namespace("a").Body, doc.Namespace("a").Body) {
+		t.Errorf("$env and $expr do not survive encoding:\n%s", pkg.Encode(doc, pkg.EncodeOptions{}))
+	}
+	for _, bad := range []string{`@a { x: $env() }`, `@a { x: $env(1) }`, `@a { x: $env("A", 1, 2) }`, `@a { x: $expr("1 +") }`} {
+		if _, err := pkg.Parse([]byte(bad)); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
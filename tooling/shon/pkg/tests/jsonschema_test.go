@@ -0,0 +1,160 @@
+package pkg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+const exportSchema = `$schema: "0.6"
+
+@definitions {
+	point: { type: "tuple", items: [{ type: "number", name: "x" }, { type: "number", name: "y" }] }
+}
+
+@order {
+	id: { type: "string", pattern: "^o-" },
+	qty: { type: "integer", minimum: 1 },
+	price: { type: "decimal", scale: 2, default: $decimal("0.00") },
+	placed: { type: "timestamp" },
+	day: { type: "timestamp", format: "date" },
+	at: { $ref: "point" },
+	owner: { type: "ref", target: "people" },
+	labels: { type: "map", values: "string" },
+	note: { type: "string", nullable: true },
+	status: { type: "string", enum: ["open", "closed"] }
+}`
+
+func TestSchemaExportJSONSchema(t *testing.T) {
+	s, err := schema.Parse([]byte(exportSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	out, err := pkg.MarshalJSON(s.JSONSchema(), "  ")
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+	got := string(out)
+	for _, want := range []string{
+		`"$schema": "https://json-schema.org/draft/2020-12/schema"`,
+		`"type": "object"`,
+		`"pattern": "^[-+]?(\\d+(\\.\\d*)?|\\.\\d+)$"`,
+		`"x-shon-scale": 2`,
+		`"default": "0.00"`,
+		`"format": "date-time"`,
+		`"format": "date"`,
+		`"$ref": "#/$defs/point"`,
+		`"prefixItems": [`,
+		`"title": "x"`,
+		`"items": false`,
+		`"pattern": "^&people([.\\[]|$)"`,
+		`"additionalProperties": {`,
+		`"null"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("export missing %s in:\n%s", want, got)
+		}
+	}
+}
+
+func TestSchemaJSONSchemaRoundTrip(t *testing.T) {
+	s, err := schema.Parse([]byte(exportSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	out, err := pkg.MarshalJSON(s.JSONSchema(), "  ")
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+
+	doc, warnings, err := schema.ImportJSONSchema(out, "")
+	if err != nil {
+		t.Fatalf("ImportJSONSchema failed: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	want := mustParse(t, exportSchema)
+	if !pkg.Equal(doc.Namespaces[0].Body, want.Namespaces[0].Body) {
+		t.Errorf("definitions differ:\n%s", pkg.Encode(doc, pkg.EncodeOptions{}))
+	}
+
+	imported, err := schema.Parse([]byte(pkg.Encode(doc, pkg.EncodeOptions{})))
+	if err != nil {
+		t.Fatalf("imported schema does not compile: %v\n%s", err, pkg.Encode(doc, pkg.EncodeOptions{}))
+	}
+	diags := imported.Validate(mustParse(t, `@order {
+	id: "x-1",
+	qty: 0,
+	price: $decimal("1.005"),
+	placed: $timestamp("2025-03-22T14:45:00Z"),
+	day: $timestamp("2025-03-22"),
+	at: $tuple(1, 2),
+	owner: &people.sean,
+	labels: { en: "Hi" },
+	note: null,
+	status: "open"
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"order.id":    {"does not match pattern"},
+		"order.qty":   {"less than minimum 1"},
+		"order.price": {"more than scale 2"},
+		"order.owner": {"dangling reference"},
+	})
+}
+
+func TestSchemaImportJSONSchema(t *testing.T) {
+	doc, warnings, err := schema.ImportJSONSchema([]byte(`{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "User",
+	"type": "object",
+	"properties": {
+		"name": { "type": "string", "minLength": 1 },
+		"born": { "type": "string", "format": "date" },
+		"tags": { "type": "array", "items": { "type": "string" } },
+		"meta": { "type": "object", "additionalProperties": { "type": "integer" } },
+		"role": { "const": "admin" },
+		"nick": { "type": ["string", "null"] },
+		"home": { "$ref": "#/definitions/address" },
+		"odd": { "type": "string", "contentEncoding": "base64" }
+	},
+	"required": ["name"],
+	"additionalProperties": false,
+	"definitions": {
+		"address": { "type": "object", "properties": { "city": { "type": "string" } } }
+	}
+}`), "user")
+	if err != nil {
+		t.Fatalf("ImportJSONSchema failed: %v", err)
+	}
+	expectDiagnostics(t, warnings, map[string][]string{
+		"user.properties.odd.contentEncoding": {`unsupported keyword "contentEncoding" dropped`},
+	})
+
+	want := mustParse(t, `$schema: "0.6"
+
+@definitions {
+	address: { type: "struct", properties: { city: "string" } }
+}
+
+@user {
+	type: "struct",
+	properties: {
+		name: { type: "string", minLength: 1 },
+		born: { type: "timestamp", format: "date" },
+		tags: { type: "array", items: "string" },
+		meta: { type: "map", values: "integer" },
+		role: { enum: ["admin"] },
+		nick: { type: "string", nullable: true },
+		home: { $ref: "address" },
+		odd: "string"
+	},
+	required: ["name"],
+	additionalProperties: false
+}`)
+	if !pkg.Equal(doc.Namespaces[1].Body, want.Namespaces[1].Body) || !pkg.Equal(doc.Namespaces[0].Body, want.Namespaces[0].Body) {
+		t.Errorf("unexpected import:\n%s", pkg.Encode(doc, pkg.EncodeOptions{}))
+	}
+}
@@ -0,0 +1,137 @@
+package pkg_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+// refBomb returns constants where each level refers to the one below ten
+// times, so resolving the top level expands to 10^levels values.
+func refBomb(levels int) string {
+	var sb strings.Builder
+	sb.WriteString("@const {\n    L0: [1, 1, 1, 1, 1, 1, 1, 1, 1, 1],\n")
+	for i := 1; i <= levels; i++ {
+		refs := strings.TrimSuffix(strings.Repeat(fmt.Sprintf("&const.L%d, ", i-1), 10), ", ")
+		fmt.Fprintf(&sb, "    L%d: [%s],\n", i, refs)
+	}
+	fmt.Fprintf(&sb, "}\n@data { bomb: &const.L%d }", levels)
+	return sb.String()
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		limits pkg.Limits
+		limit  string
+		// enough is the tightest limit the source is within.
+		enough pkg.Limits
+	}{
+		{"size", `@a { x: 1 }`, pkg.Limits{MaxSize: 10}, "MaxSize", pkg.Limits{MaxSize: 11}},
+		{"depth", `@a { x: [[[{ y: 1 }]]] }`, pkg.Limits{MaxDepth: 4}, "MaxDepth", pkg.Limits{MaxDepth: 5}},
+		{"tuple depth", `@a { x: $tuple($tuple(1)) }`, pkg.Limits{MaxDepth: 2}, "MaxDepth", pkg.Limits{MaxDepth: 3}},
+		{"string", `@a { x: "abcdef" }`, pkg.Limits{MaxStringLength: 5}, "MaxStringLength", pkg.Limits{MaxStringLength: 6}},
+		{"key", `@a { abcdef: 1 }`, pkg.Limits{MaxStringLength: 5}, "MaxStringLength", pkg.Limits{MaxStringLength: 6}},
+		{"reference", `@a { x: &a.abcdef }`, pkg.Limits{MaxStringLength: 7}, "MaxStringLength", pkg.Limits{MaxStringLength: 8}},
+		{"keys", `$v: 1 @a { x: 1, y: { z: 2 } }`, pkg.Limits{MaxKeys: 4}, "MaxKeys", pkg.Limits{MaxKeys: 5}},
+	}
+	for _, tt := range tests {
+		_, err := pkg.ParseWithLimits([]byte(tt.src), tt.limits)
+		var limitErr *pkg.LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit || !errors.Is(err, pkg.ErrLimitExceeded) {
+			t.Errorf("%s: expected a %s LimitError, got %v", tt.name, tt.limit, err)
+			continue
+		}
+		if _, err := pkg.ParseWithLimits([]byte(tt.src), tt.enough); err != nil {
+			t.Errorf("%s: expected %+v to be enough, got %v", tt.name, tt.enough, err)
+		}
+	}
+
+	if _, err := pkg.ParseWithLimits([]byte(`@a { x: [[[1]]] }`), pkg.Limits{}); err != nil {
+		t.Errorf("zero limits should limit nothing: %v", err)
+	}
+	_, err := pkg.ParseWithLimits([]byte("@a { x: "+strings.Repeat("[", 100000)), pkg.DefaultLimits)
+	if err == nil || !strings.Contains(err.Error(), "nested deeper than") {
+		t.Errorf("expected deep nesting to be refused, got %v", err)
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 5; i++ {
+		fsys[fmt.Sprintf("f%d.shon", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("@include \"f%d.shon\"\n@n%d { x: 1 }", i+1, i))}
+	}
+	fsys["f5.shon"] = &fstest.MapFile{Data: []byte(`@n5 { x: 1 }`)}
+
+	l := pkg.NewLoader(fsys)
+	l.Limits.MaxIncludeDepth = 4
+	_, err := l.Load("f0.shon")
+	var limitErr *pkg.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxIncludeDepth" {
+		t.Fatalf("expected a MaxIncludeDepth LimitError, got %v", err)
+	}
+	l.Limits.MaxIncludeDepth = 5
+	if _, err := l.Load("f0.shon"); err != nil {
+		t.Errorf("five levels of includes should load: %v", err)
+	}
+}
+
+func TestExpansionLimit(t *testing.T) {
+	var out map[string]interface{}
+	err := pkg.UnmarshalWithOptions([]byte(refBomb(9)), &out, pkg.DecodeOptions{Limits: pkg.DefaultLimits})
+	var limitErr *pkg.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxExpansion" {
+		t.Fatalf("expected a MaxExpansion LimitError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "data.bomb: ") {
+		t.Errorf("expected the error to name the reference, got %v", err)
+	}
+	if err := pkg.UnmarshalWithOptions([]byte(refBomb(2)), &out, pkg.DecodeOptions{Limits: pkg.DefaultLimits}); err != nil {
+		t.Errorf("a small expansion should resolve: %v", err)
+	}
+
+	// Each field doubles the one before, in work and in length.
+	var sb strings.Builder
+	sb.WriteString(`@a { x0: "ab"`)
+	for i := 1; i <= 40; i++ {
+		fmt.Fprintf(&sb, `, x%d: $expr("&a.x%d + &a.x%d")`, i, i-1, i-1)
+	}
+	sb.WriteString(" }")
+	err = pkg.UnmarshalWithOptions([]byte(sb.String()), &out, pkg.DecodeOptions{Limits: pkg.DefaultLimits})
+	if !errors.Is(err, pkg.ErrLimitExceeded) {
+		t.Errorf("expected an expression bomb to exceed a limit, got %v", err)
+	}
+	err = pkg.UnmarshalWithOptions([]byte(sb.String()), &out, pkg.DecodeOptions{Limits: pkg.Limits{MaxStringLength: 1000}})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxStringLength" {
+		t.Errorf("expected a MaxStringLength LimitError, got %v", err)
+	}
+
+	if _, err := pkg.ParseExpr(strings.Repeat("(", 2000) + "1" + strings.Repeat(")", 2000)); err == nil {
+		t.Error("expected deeply parenthesised expression to be refused")
+	}
+}
+
+func TestConvertLimits(t *testing.T) {
+	bomb := writeTempFile(t, "bomb.shon", refBomb(9))
+	out := filepath.Join(t.TempDir(), "out.json")
+	err := pkg.ConvertFileWithOptions(bomb, out, pkg.ConvertOptions{Quiet: true, Limits: pkg.DefaultLimits})
+	var limitErr *pkg.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxExpansion" {
+		t.Errorf("expected a MaxExpansion LimitError, got %v", err)
+	}
+
+	big := writeTempFile(t, "big.shon", `@a { x: "`+strings.Repeat("x", 100)+`" }`)
+	err = pkg.ConvertFileWithOptions(big, out, pkg.ConvertOptions{Quiet: true, Limits: pkg.Limits{MaxSize: 64}})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "MaxSize" {
+		t.Errorf("expected a MaxSize LimitError, got %v", err)
+	}
+	if err := pkg.ConvertFileWithOptions(big, out, pkg.ConvertOptions{Quiet: true, Limits: pkg.DefaultLimits}); err != nil {
+		t.Errorf("a small file should convert with the default limits: %v", err)
+	}
+}
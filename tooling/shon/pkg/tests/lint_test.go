@@ -0,0 +1,158 @@
+package pkg_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/lint"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+const lintDoc = `@user {
+	firstName: "Ada",
+	lastName: "Lovelace",
+	home_city: "London",
+	color: &color.color_1,
+	firstName: "Augusta",
+	price: $decimal(1.10),
+	born: $timestamp("1815-12-10T09:00:00"),
+	died: $timestamp("1852-11-27T12:00:00Z"),
+	tags: ["math", "poetry",],
+	a: { b: { c: { d: 1 } } }
+}
+
+@color {
+	color_1: "red"
+}
+
+@size {
+	large: "L"
+}
+`
+
+func lintRules(findings []lint.Finding) map[string][]int {
+	rules := map[string][]int{}
+	for _, f := range findings {
+		rules[f.Rule] = append(rules[f.Rule], f.Line)
+	}
+	return rules
+}
+
+func TestLint(t *testing.T) {
+	s, err := schema.Parse([]byte(`@user { type: "struct", properties: {} }
+@color { type: "map", values: "string" }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	config, err := lint.ParseConfig([]byte(`@rules { deepNesting: { maxDepth: 3 } }`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	findings, err := lint.Lint([]byte(lintDoc), config, s)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+
+	got := lintRules(findings)
+	want := map[string][]int{
+		"duplicateKeys":      {6},
+		"keyNaming":          {4},
+		"floatDecimal":       {7},
+		"zonelessTimestamp":  {8},
+		"trailingComma":      {10},
+		"deepNesting":        {11},
+		"unusedRefs":         {18},
+		"uncoveredNamespace": {18},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings on lines %v, want %v", got, want)
+	}
+	if len(findings) != 8 {
+		t.Errorf("expected 8 findings, got %v", findings)
+	}
+	if !lint.HasErrors(findings) {
+		t.Error("duplicate keys should be an error")
+	}
+	if !strings.Contains(findings[0].String(), `4:2: warning: key "home_city" is snake_case, but most keys are camelCase (keyNaming)`) {
+		t.Errorf("unexpected first finding %q", findings[0])
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	src := []byte(`@user {
+	firstName: "Ada",
+	last_name: "Lovelace", // shonlint-disable-line keyNaming
+	// shonlint-disable-next-line
+	tags: ["a",],
+	n: [1,],
+	homePage: "http://example.com // shonlint-disable-line"
+}
+`)
+	config, err := lint.ParseConfig([]byte(`// project rules
+@rules {
+	keyNaming: { severity: "error", style: "snake_case" },
+	trailingComma: "error"
+}`))
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	findings, err := lint.Lint(src, config, nil)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	want := map[string][]int{"keyNaming": {2, 7}, "trailingComma": {6}}
+	if got := lintRules(findings); !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings on lines %v, want %v", got, want)
+	}
+	if !strings.Contains(findings[0].Message, "not snake_case") || !lint.HasErrors(findings) {
+		t.Errorf("unexpected findings %v", findings)
+	}
+
+	for _, bad := range []string{`@rules { nope: "off" }`, `@rules { keyNaming: "loud" }`, `@rules { deepNesting: { maxDepth: 0 } }`} {
+		if _, err := lint.ParseConfig([]byte(bad)); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestLintCSVOutput(t *testing.T) {
+	in := writeTempFile(t, "staff.csv", `name,address,title
+Sean,1234 Main St,Engineer
+Ellie,1234 Main St,CTO
+Darcy,5678 2nd Ave,Engineer`)
+	out := filepath.Join(t.TempDir(), "staff.shon")
+	if err := pkg.CSVToShon(in, out); err != nil {
+		t.Fatalf("CSVToShon failed: %v", err)
+	}
+	src := []byte(readFile(t, out))
+	findings, err := lint.Lint(src, nil, nil)
+	if err != nil {
+		t.Fatalf("Lint failed: %v\n%s", err, src)
+	}
+	if got := lintRules(findings); len(got["unusedRefs"]) != 0 {
+		t.Errorf("unexpected unusedRefs findings %v", findings)
+	}
+
+	for i := 0; i < 3; i++ {
+		if src, err = pkg.RemoveSource(src, fmt.Sprintf("staff.records[%d].title", i)); err != nil {
+			t.Fatalf("RemoveSource failed: %v", err)
+		}
+	}
+	findings, err = lint.Lint(src, nil, nil)
+	if err != nil {
+		t.Fatalf("Lint failed: %v", err)
+	}
+	var unused []string
+	for _, f := range findings {
+		if f.Rule == "unusedRefs" {
+			unused = append(unused, f.Message)
+		}
+	}
+	if len(unused) != 1 || !strings.Contains(unused[0], "@title") {
+		t.Errorf("expected @title to be reported as unused, got %v\n%s", findings, src)
+	}
+}
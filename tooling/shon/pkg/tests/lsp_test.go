@@ -0,0 +1,286 @@
+package pkg_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/lsp"
+)
+
+const lspSchema = `@user {
+	type: "struct",
+	properties: {
+		name: { type: "string", description: "Full name" },
+		age: { type: "integer" },
+		city: { type: "string" }
+	},
+	required: ["name"]
+}
+`
+
+const lspDoc = `$schema: "./user.shos"
+
+@user {
+	name: "Ada",
+	age: "old",
+	friend: &people.grace
+}
+
+@people {
+	grace: { name: "Grace" }
+}
+`
+
+type rpcMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// runLSP sends requests to a server and returns its responses by ID and
+// the notifications it sent, in order.
+func runLSP(t *testing.T, requests ...map[string]interface{}) (map[int]rpcMessage, []rpcMessage) {
+	t.Helper()
+	return runLSPProject(t, nil, requests...)
+}
+
+// runLSPProject is runLSP with a project config.
+func runLSPProject(t *testing.T, project *pkg.ProjectConfig, requests ...map[string]interface{}) (map[int]rpcMessage, []rpcMessage) {
+	t.Helper()
+	var in bytes.Buffer
+	for _, r := range append(requests, map[string]interface{}{"id": 999, "method": "shutdown"}, map[string]interface{}{"method": "exit"}) {
+		r["jsonrpc"] = "2.0"
+		body, _ := json.Marshal(r)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var out bytes.Buffer
+	s := lsp.NewServer(&in, &out)
+	s.Project = project
+	if err := s.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	responses := map[int]rpcMessage{}
+	var notifications []rpcMessage
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("bad header: %v", err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		io.ReadFull(r, body)
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("bad message %s: %v", body, err)
+		}
+		if msg.ID != nil {
+			responses[*msg.ID] = msg
+		} else {
+			notifications = append(notifications, msg)
+		}
+	}
+	return responses, notifications
+}
+
+func lspWorkspace(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "user.shos"), []byte(lspSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func didOpen(uri, text string) map[string]interface{} {
+	return map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "shon", "version": 1, "text": text},
+	}}
+}
+
+func atPosition(id int, method, uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+	}}
+}
+
+func TestLSPDiagnostics(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(filepath.Join(lspWorkspace(t), "main.shon"))
+	responses, notes := runLSP(t,
+		map[string]interface{}{"id": 1, "method": "initialize", "params": map[string]interface{}{}},
+		didOpen(uri, lspDoc),
+		map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []map[string]string{{"text": "@user {\n\tname: \n}"}},
+		}},
+		map[string]interface{}{"id": 2, "method": "textDocument/unknown", "params": map[string]interface{}{}},
+	)
+
+	if !strings.Contains(string(responses[1].Result), `"hoverProvider":true`) {
+		t.Errorf("unexpected capabilities %s", responses[1].Result)
+	}
+	if e := responses[2].Error; e == nil || e.Code != -32601 {
+		t.Errorf("expected method not found, got %+v", responses[2])
+	}
+
+	if len(notes) != 2 {
+		t.Fatalf("expected 2 diagnostics notifications, got %d", len(notes))
+	}
+	var opened, changed struct {
+		Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+	}
+	json.Unmarshal(notes[0].Params, &opened)
+	json.Unmarshal(notes[1].Params, &changed)
+
+	if len(opened.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", opened.Diagnostics)
+	}
+	if d := opened.Diagnostics[0]; d.Range.Start != (lsp.Position{Line: 4, Character: 1}) || !strings.Contains(d.Message, "integer") {
+		t.Errorf("unexpected age diagnostic %+v", d)
+	}
+	if len(changed.Diagnostics) != 1 || changed.Diagnostics[0].Range.Start.Line != 2 {
+		t.Errorf("expected a syntax error on line 3, got %+v", changed.Diagnostics)
+	}
+}
+
+func TestLSPFeatures(t *testing.T) {
+	dir := lspWorkspace(t)
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "main.shon"))
+	partial := "$schema: \"./user.shos\"\n@user {\n\tname: \"Ada\",\n\t\n"
+	partialURI := "file://" + filepath.ToSlash(filepath.Join(dir, "partial.shon"))
+	responses, _ := runLSP(t,
+		didOpen(uri, lspDoc),
+		didOpen(partialURI, partial),
+		atPosition(1, "textDocument/hover", uri, 3, 2),
+		atPosition(2, "textDocument/completion", partialURI, 3, 1),
+		atPosition(3, "textDocument/definition", uri, 5, 12),
+		atPosition(4, "textDocument/definition", uri, 0, 12),
+		map[string]interface{}{"id": 5, "method": "textDocument/documentSymbol", "params": map[string]interface{}{"textDocument": map[string]string{"uri": uri}}},
+		map[string]interface{}{"id": 6, "method": "textDocument/formatting", "params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": partialURI},
+			"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+		}},
+	)
+
+	var hover lsp.Hover
+	json.Unmarshal(responses[1].Result, &hover)
+	if v := hover.Contents.Value; !strings.Contains(v, "`string` (required)") || !strings.Contains(v, "Full name") {
+		t.Errorf("unexpected hover %q", v)
+	}
+
+	var items []lsp.CompletionItem
+	json.Unmarshal(responses[2].Result, &items)
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if strings.Join(labels, ",") != "age,city" {
+		t.Errorf("unexpected completions %v", labels)
+	}
+
+	var loc lsp.Location
+	json.Unmarshal(responses[3].Result, &loc)
+	if loc.URI != uri || loc.Range.Start != (lsp.Position{Line: 9, Character: 1}) {
+		t.Errorf("unexpected ref definition %+v", loc)
+	}
+	json.Unmarshal(responses[4].Result, &loc)
+	if !strings.HasSuffix(loc.URI, "/user.shos") {
+		t.Errorf("unexpected $schema definition %+v", loc)
+	}
+
+	var symbols []lsp.DocumentSymbol
+	json.Unmarshal(responses[5].Result, &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "@user" || len(symbols[0].Children) != 3 || symbols[1].Children[0].Name != "grace" {
+		t.Errorf("unexpected symbols %+v", symbols)
+	}
+
+	var edits []lsp.TextEdit
+	json.Unmarshal(responses[6].Result, &edits)
+	if len(edits) != 1 || !strings.Contains(edits[0].NewText, "\n  name: \"Ada\",\n") {
+		t.Errorf("unexpected formatting edits %+v", edits)
+	}
+}
+
+func TestLSPIncludeRoots(t *testing.T) {
+	project := t.TempDir()
+	writeFileIn(t, project, "shared.shon", `@shared { port: 8080 }`)
+	dir := filepath.Join(project, "services")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "api.shon"))
+	text := "@include \"../shared.shon\"\n\n@api { port: &shared.port }\n"
+
+	diagnosticsFor := func(project *pkg.ProjectConfig) []lsp.Diagnostic {
+		_, notes := runLSPProject(t, project, didOpen(uri, text))
+		var params lsp.PublishDiagnosticsParams
+		for _, n := range notes {
+			if n.Method == "textDocument/publishDiagnostics" {
+				json.Unmarshal(n.Params, &params)
+			}
+		}
+		return params.Diagnostics
+	}
+	if diags := diagnosticsFor(nil); len(diags) == 0 {
+		t.Error("expected an include outside the document's directory to fail without a project")
+	}
+	config := pkg.DefaultProjectConfig()
+	config.IncludeRoots = []string{project}
+	if diags := diagnosticsFor(config); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics with the project's include root: %+v", diags)
+	}
+}
+
+func TestLSPFormattingUnbalanced(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(filepath.Join(lspWorkspace(t), "odd.shon"))
+	responses, _ := runLSP(t,
+		didOpen(uri, "}\n]\n@a {\nx: 1\n}\n"),
+		map[string]interface{}{"id": 1, "method": "textDocument/formatting", "params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"options":      map[string]interface{}{"tabSize": -2, "insertSpaces": true},
+		}},
+	)
+	var edits []lsp.TextEdit
+	json.Unmarshal(responses[1].Result, &edits)
+	if len(edits) != 1 || edits[0].NewText != "}\n]\n@a {\n    x: 1\n}\n" {
+		t.Errorf("unexpected formatting edits %+v", edits)
+	}
+	if got := pkg.FormatSource([]byte("}\n}\n@a {\nx: 1\n}"), 2, false); got != "}\n}\n@a {\n  x: 1\n}\n" {
+		t.Errorf("unexpected formatting of unbalanced source %q", got)
+	}
+}
+
+func TestLSPDiagnosticsUTF16(t *testing.T) {
+	uri := "file://" + filepath.ToSlash(filepath.Join(lspWorkspace(t), "main.shon"))
+	_, notes := runLSP(t, didOpen(uri, "@a {\n\tb: \"é😀\", c: ?\n}"))
+
+	if len(notes) != 1 {
+		t.Fatalf("expected 1 diagnostics notification, got %d", len(notes))
+	}
+	var params lsp.PublishDiagnosticsParams
+	json.Unmarshal(notes[0].Params, &params)
+	// The ? follows a two-byte and a four-byte character: byte column 19,
+	// but UTF-16 character 14.
+	want := lsp.Range{Start: lsp.Position{Line: 1, Character: 14}, End: lsp.Position{Line: 1, Character: 15}}
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Range != want {
+		t.Errorf("expected a syntax error at %+v, got %+v", want, params.Diagnostics)
+	}
+}
@@ -0,0 +1,256 @@
+package pkg_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+const migrationSchema = `$schema: "0.6"
+$version: "3"
+
+@user {
+	fullName: "string",
+	balance: "decimal",
+	active: "boolean"
+}
+
+@migrations {
+	steps: [
+		{ from: "1", to: "2", ops: [
+			{ op: "rename", path: "user.name", to: "fullName" },
+			{ op: "convert", path: "user.balance", type: "decimal" }
+		] },
+		{ from: "2", to: "3", ops: [
+			{ op: "default", path: "user.active", value: true },
+			{ op: "move", from: "user.old.city", path: "user.city" },
+			{ op: "remove", path: "user.old" },
+			{ op: "convert", path: "user.tags[*].n", type: "integer" }
+		] }
+	]
+}`
+
+const migrationDoc = `$version: "1"
+
+// kept
+@user {
+	name: "Ada", // trailing
+	balance: "10.50",
+	old: {
+		city: "London"
+	},
+	tags: [{ n: "1" }, { n: "2" }]
+}
+`
+
+func TestMigrateSource(t *testing.T) {
+	s, err := schema.Parse([]byte(migrationSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if s.SchemaVersion() != "3" {
+		t.Errorf("unexpected schema version %q", s.SchemaVersion())
+	}
+	for _, ns := range s.Namespaces() {
+		if ns == pkg.MigrationsNamespace {
+			t.Error("@migrations should not be a schema namespace")
+		}
+	}
+
+	steps, err := pkg.MigrationPath(s.Migrations(), "1", s.SchemaVersion())
+	if err != nil || len(steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d (%v)", len(steps), err)
+	}
+	out, err := pkg.MigrateSource([]byte(migrationDoc), steps)
+	if err != nil {
+		t.Fatalf("MigrateSource failed: %v", err)
+	}
+	text := string(out)
+	for _, want := range []string{"// kept", "fullName: \"Ada\", // trailing", `$version: "3"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	doc := mustParse(t, text)
+	user := doc.Namespace("user").Body
+	if user.Values["balance"] != pkg.Decimal("10.50") {
+		t.Errorf("balance not converted: %#v", user.Values["balance"])
+	}
+	if user.Values["active"] != true || user.Values["city"] != "London" {
+		t.Errorf("default or move not applied:\n%s", text)
+	}
+	if _, ok := user.Get("old"); ok {
+		t.Error("old should be removed")
+	}
+	if n, _ := doc.Lookup("user.tags[1].n"); n != json.Number("2") {
+		t.Errorf("tags not converted: %#v", n)
+	}
+	expectDiagnostics(t, s.Validate(doc), nil)
+}
+
+func TestMigrateSplitMerge(t *testing.T) {
+	steps, err := pkg.MigrationsFromDocument(mustParse(t, `@migrations {
+	steps: [
+		{ from: "1", to: "2", ops: [{ op: "split", path: "p.name", into: ["first", "last"] }] },
+		{ from: "2", to: "3", ops: [{ op: "merge", from: ["first", "last"], path: "p.full", separator: "_" }] }
+	]
+}`))
+	if err != nil {
+		t.Fatalf("MigrationsFromDocument failed: %v", err)
+	}
+
+	out, err := pkg.MigrateSource([]byte(`@p { name: "Ada Lovelace", age: 36 }`), steps[:1])
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	p := mustParse(t, string(out)).Namespace("p").Body
+	if p.Values["first"] != "Ada" || p.Values["last"] != "Lovelace" {
+		t.Errorf("unexpected split result:\n%s", out)
+	}
+
+	out, err = pkg.MigrateSource(out, steps[1:])
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	p = mustParse(t, string(out)).Namespace("p").Body
+	if p.Values["full"] != "Ada_Lovelace" || p.Len() != 2 {
+		t.Errorf("unexpected merge result:\n%s", out)
+	}
+}
+
+func TestMigrateSplitMergeComments(t *testing.T) {
+	steps, err := pkg.MigrationsFromDocument(mustParse(t, `@migrations {
+	steps: [
+		{ from: "1", to: "2", ops: [{ op: "split", path: "p.name", into: ["first", "last"] }] },
+		{ from: "2", to: "3", ops: [{ op: "merge", from: ["first", "last"], path: "p.full" }] }
+	]
+}`))
+	if err != nil {
+		t.Fatalf("MigrationsFromDocument failed: %v", err)
+	}
+
+	src := `$version: "1"
+
+@p {
+    // name comment
+    name: "Ada Lovelace",  // trailing
+    tags: ["x"],
+}
+`
+	want := `$version: "2"
+
+@p {
+    // name comment
+    first: "Ada",  // trailing
+    last: "Lovelace",
+    tags: ["x"],
+}
+`
+	out, err := pkg.MigrateSource([]byte(src), steps[:1])
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	if string(out) != want {
+		t.Errorf("split: got\n%s\nwant\n%s", out, want)
+	}
+
+	src = `@p {
+    first: "Ada",
+    // family name
+    last: "Lovelace"  // trailing
+}`
+	want = `@p {
+    // family name
+    // trailing
+    full: "Ada Lovelace"
+}`
+	if out, err = pkg.MigrateSource([]byte(src), steps[1:]); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if got := strings.TrimPrefix(string(out), "$version: \"3\"\n\n"); got != want {
+		t.Errorf("merge: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMigrationErrors(t *testing.T) {
+	_, err := pkg.MigrationsFromDocument(mustParse(t, `@migrations { steps: [{ from: "1", to: "2", ops: [{ op: "convert", path: "a.b", type: "blob" }] }] }`))
+	if err == nil || !strings.Contains(err.Error(), "blob") {
+		t.Errorf("expected an unknown type error, got %v", err)
+	}
+
+	if _, err := pkg.MigrationPath(nil, "1", "2"); err == nil {
+		t.Error("expected an error without a path between versions")
+	}
+
+	if _, err := pkg.ConvertValue("abc", "integer"); err == nil {
+		t.Error("expected converting \"abc\" to integer to fail")
+	}
+	for _, tt := range []struct{ text, typ string }{
+		{"1/2", "number"}, {"1/2", "decimal"}, {"1e5", "decimal"}, {"+1", "number"}, {"0x10", "integer"},
+	} {
+		if v, err := pkg.ConvertValue(tt.text, tt.typ); err == nil {
+			t.Errorf("expected converting %q to %s to fail, got %v", tt.text, tt.typ, v)
+		}
+	}
+	if v, err := pkg.ConvertValue("1e3", "integer"); err != nil || v != json.Number("1000") {
+		t.Errorf("expected 1e3 to convert to integer 1000, got %v, %v", v, err)
+	}
+
+	steps := mustParse(t, `@migrations { steps: [{ from: "1", to: "2", ops: [{ op: "convert", path: "user.rate", type: "decimal" }] }] }`)
+	migrations, err := pkg.MigrationsFromDocument(steps)
+	if err != nil {
+		t.Fatalf("MigrationsFromDocument failed: %v", err)
+	}
+	_, err = pkg.MigrateSource([]byte(`$version: "1"
+@user { rate: "1e5" }`), migrations)
+	if err == nil || !strings.Contains(err.Error(), "user.rate: ") {
+		t.Errorf("expected an error naming user.rate, got %v", err)
+	}
+}
+
+func TestValidateVersionMismatch(t *testing.T) {
+	s, err := schema.Parse([]byte(migrationSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	doc := mustParse(t, `$version: "2"
+@user { fullName: "Ada", balance: $decimal("1.00"), active: true }`)
+	diags := s.Validate(doc)
+	if len(diags) != 1 || diags[0].Severity != pkg.SeverityWarning || !strings.Contains(diags[0].Message, "shon migrate") {
+		t.Errorf("expected a version warning, got %v", diags)
+	}
+}
+
+func TestSourceMapEdits(t *testing.T) {
+	src := []byte("@a {\n\tx: 1, // one\n\ty: [1, 2]\n}\n")
+	_, m, err := pkg.ParseWithSourceMap(src)
+	if err != nil {
+		t.Fatalf("ParseWithSourceMap failed: %v", err)
+	}
+	e := m.Entries["a.y[1]"]
+	if got := string(src[e.Value.Start:e.Value.End]); got != "2" {
+		t.Errorf("unexpected span text %q", got)
+	}
+	if line, _ := m.Position(e.Value.Start); line != 3 {
+		t.Errorf("expected line 3, got %d", line)
+	}
+
+	out, err := pkg.InsertSource(src, "a", "z", true)
+	if err != nil {
+		t.Fatalf("InsertSource failed: %v", err)
+	}
+	if want := "\ty: [1, 2],\n\tz: true\n}"; !strings.Contains(string(out), want) {
+		t.Errorf("expected %q in:\n%s", want, out)
+	}
+	out, err = pkg.RemoveSource(out, "a.x")
+	if err != nil {
+		t.Fatalf("RemoveSource failed: %v", err)
+	}
+	if strings.Contains(string(out), "x:") || !strings.Contains(string(out), "// one") {
+		t.Errorf("unexpected removal result:\n%s", out)
+	}
+}
@@ -0,0 +1,175 @@
+package pkg_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+const patchBase = `$schema: "./example.shos"
+
+@user {
+	id: "001",
+	// comments are skipped
+	balance: $decimal("1042.75"),
+	tags: ["dev", "golang"],
+	location: {
+		city: "Palm Springs",
+		state: "CA"
+	}
+}`
+
+func TestParseRoundTrip(t *testing.T) {
+	doc, err := pkg.Parse([]byte(patchBase))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if doc.Schema() != "./example.shos" {
+		t.Errorf("unexpected schema %q", doc.Schema())
+	}
+
+	again, err := pkg.Parse([]byte(pkg.Encode(doc, pkg.EncodeOptions{})))
+	if err != nil {
+		t.Fatalf("re-parse failed: %v", err)
+	}
+	if !pkg.Equal(doc.Namespaces[0].Body, again.Namespaces[0].Body) {
+		t.Error("document changed after encode/parse round trip")
+	}
+}
+
+func TestParseUnicodeEscapes(t *testing.T) {
+	tests := map[string]string{
+		`"\u00e9"`:             "é",
+		`"\ud83d\ude00"`:       "😀",
+		`"a\uD83D\uDE00b"`:     "a😀b",
+		`"\ud83d"`:             "\ufffd",
+		`"\ud83d\u0041"`:       "\ufffdA",
+		`"\ude00\ud83d\ude00"`: "\ufffd😀",
+	}
+	for lit, want := range tests {
+		doc, err := pkg.Parse([]byte("@a { s: " + lit + " }"))
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", lit, err)
+			continue
+		}
+		var fromJSON string
+		if err := json.Unmarshal([]byte(lit), &fromJSON); err != nil || fromJSON != want {
+			t.Fatalf("%s: encoding/json gives %q, the test expects %q", lit, fromJSON, want)
+		}
+		if v, _ := doc.Lookup("a.s"); v != want {
+			t.Errorf("%s: got %q, want %q", lit, v, want)
+		}
+	}
+	for _, bad := range []string{`"\u12"`, `"\uzzzz"`, `"\u+123"`} {
+		if _, err := pkg.Parse([]byte("@a { s: " + bad + " }")); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := pkg.Parse([]byte("@user {\n  name: \"Sean\",\n  age: ?\n}"))
+	var syntaxErr *pkg.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected SyntaxError, got %v", err)
+	}
+	if syntaxErr.Line != 3 {
+		t.Errorf("expected error on line 3, got %d", syntaxErr.Line)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		src       string
+		line, col int
+		msg       string
+	}{
+		{"@user { name: \"Sean\" }\n/* never closed", 2, 1, "unterminated block comment"},
+		{"@user {\n  name: 1 /* never closed\n  age: 2\n}", 2, 11, "unterminated block comment"},
+		{"@user {\n  name: \"Sean\",\n  name: \"Bob\"\n}", 3, 3, `duplicate key "name"`},
+		{"@user { $tags: [], $tags: [] }", 1, 20, `duplicate key "$tags"`},
+		{"$schema: \"a\"\n$schema: \"b\"", 2, 1, "duplicate metadata $schema"},
+	}
+	for _, tt := range tests {
+		_, err := pkg.Parse([]byte(tt.src))
+		var syntaxErr *pkg.SyntaxError
+		if !errors.As(err, &syntaxErr) || syntaxErr.Msg != tt.msg || syntaxErr.Line != tt.line || syntaxErr.Col != tt.col {
+			t.Errorf("%q: expected %d:%d %s, got %v", tt.src, tt.line, tt.col, tt.msg, err)
+		}
+	}
+
+	// Tools that report duplicate keys themselves still see the document.
+	doc, m, err := pkg.ParseWithSourceMap([]byte("@user { name: 1, name: 2 }"))
+	if err != nil || len(m.Duplicates["user.name"]) != 1 {
+		t.Fatalf("ParseWithSourceMap should record the duplicate: %v", err)
+	}
+	if v, _ := doc.Lookup("user.name"); v != json.Number("2") {
+		t.Errorf("expected the last value to win, got %v", v)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	doc, err := pkg.Parse([]byte(patchBase))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	patch, err := pkg.ParsePatch([]byte(`@patch {
+	ops: [
+		{ op: "test", path: "user.balance", value: $decimal("1042.750") },
+		{ op: "replace", path: "user.balance", value: $decimal("99.00") },
+		{ op: "add", path: "user.tags[-]", value: "shon" },
+		{ op: "add", path: "user.tags[0]", value: "first" },
+		{ op: "remove", path: "user.location.state" },
+		{ op: "move", from: "user.location", path: "user.address" }
+	]
+}`))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+
+	out, err := pkg.ApplyPatch(doc, patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	result := pkg.Encode(out, pkg.EncodeOptions{})
+	for _, want := range []string{
+		`balance: $decimal("99.00")`,
+		`tags: ["first", "dev", "golang", "shon"]`,
+		"address: {",
+	} {
+		if !strings.Contains(result, want) {
+			t.Errorf("patched output missing %q:\n%s", want, result)
+		}
+	}
+	if strings.Contains(result, "location") || strings.Contains(result, "state") {
+		t.Errorf("removed fields still present:\n%s", result)
+	}
+}
+
+func TestApplyPatchIsAtomic(t *testing.T) {
+	doc, err := pkg.Parse([]byte(patchBase))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	patch, err := pkg.ParsePatch([]byte(`@patch {
+	ops: [
+		{ op: "replace", path: "user.id", value: "002" },
+		{ op: "test", path: "user.location.city", value: "Indio" }
+	]
+}`))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+
+	_, err = pkg.ApplyPatch(doc, patch)
+	if !errors.Is(err, pkg.ErrPatchTestFailed) {
+		t.Fatalf("expected test failure, got %v", err)
+	}
+	if id, _ := doc.Lookup("user.id"); id != "001" {
+		t.Errorf("original document modified: id = %v", id)
+	}
+}
@@ -0,0 +1,664 @@
+package pkg_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+const userSchema = `$schema: "0.6"
+
+@user {
+	type: "struct",
+	properties: {
+		id: { type: "string" },
+		age: { type: "integer" },
+		role: { type: "string", enum: ["admin", "dev"] },
+		created: { type: "timestamp" },
+		balance: { type: "decimal" },
+		tags: { type: "array", items: { type: "string" } },
+		origin: { type: "tuple", items: ["float", "float"] },
+		labels: { type: "map", values: "string" },
+		manager: { type: "ref" },
+		location: {
+			type: "struct",
+			properties: {
+				city: { type: "string" }
+			},
+			required: ["city"],
+			additionalProperties: false
+		}
+	},
+	required: ["id", "age"]
+}`
+
+func mustParse(t *testing.T, src string) *pkg.Document {
+	t.Helper()
+	doc, err := pkg.Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return doc
+}
+
+// expectDiagnostics checks that diags holds exactly one diagnostic per
+// expected path/message pair.
+func expectDiagnostics(t *testing.T, diags []pkg.Diagnostic, want map[string][]string) {
+	t.Helper()
+	count := 0
+	for path, msgs := range want {
+		for _, msg := range msgs {
+			count++
+			found := false
+			for _, d := range diags {
+				if d.Path == path && strings.Contains(d.Message, msg) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("missing diagnostic %s: %s in %v", path, msg, diags)
+			}
+		}
+	}
+	if len(diags) != count {
+		t.Errorf("expected %d diagnostics, got %d: %v", count, len(diags), diags)
+	}
+}
+
+func TestSchemaValidateOK(t *testing.T) {
+	s, err := schema.Parse([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	diags := s.Validate(mustParse(t, `@user {
+	id: "001",
+	age: 42,
+	role: "dev",
+	created: $timestamp("2025-03-22T14:45:00Z"),
+	balance: $decimal("1042.75"),
+	tags: ["dev", "golang"],
+	origin: $tuple(1.5, 2),
+	labels: { en: "Hello" },
+	manager: &people.ellie,
+	location: { city: "Palm Springs" }
+}`))
+	if len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestSchemaValidateErrors(t *testing.T) {
+	s, err := schema.Parse([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	diags := s.Validate(mustParse(t, `@user {
+	age: 4.5,
+	role: "ceo",
+	created: $timestamp("yesterday"),
+	tags: ["dev", 7],
+	origin: $tuple(1.5),
+	labels: { en: true },
+	location: { zip: "92264" }
+}`))
+
+	expectDiagnostics(t, diags, map[string][]string{
+		"user":              {`missing required field "id"`},
+		"user.age":          {"expected integer, found number"},
+		"user.role":         {"is not one of"},
+		"user.created":      {"invalid timestamp"},
+		"user.tags[1]":      {"expected string, found number"},
+		"user.origin":       {"expected 2 tuple items, found 1"},
+		"user.labels.en":    {"expected string, found boolean"},
+		"user.location":     {`missing required field "city"`},
+		"user.location.zip": {`unexpected field "zip"`},
+	})
+}
+
+func TestSchemaImplicitStructAndMissingNamespace(t *testing.T) {
+	s, err := schema.Parse([]byte(`@transaction {
+	amount: { type: "decimal" },
+	date: { type: "timestamp", format: "date" }
+}`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	if diags := s.Validate(mustParse(t, `@transaction { amount: $decimal("1.00"), date: $timestamp("2025-03-22") }`)); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	if diags := s.Validate(mustParse(t, `@other { x: 1 }`)); len(diags) != 1 || !strings.Contains(diags[0].Message, "missing namespace @transaction") {
+		t.Errorf("expected missing namespace diagnostic, got %v", diags)
+	}
+}
+
+func TestSchemaFieldNamedType(t *testing.T) {
+	s, err := schema.Parse([]byte(`@event {
+	type: "string",
+	at: "timestamp"
+}
+@kind { type: "string" }
+@price { type: "decimal", minimum: 0 }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	doc := mustParse(t, `@event { type: "click", at: $timestamp("2024-01-01T00:00:00Z") }
+@kind { type: "a" }
+@price { x: 1 }`)
+	expectDiagnostics(t, s.Validate(doc), map[string][]string{
+		"price": {"expected decimal, found object"},
+	})
+	doc = mustParse(t, `@event { type: 1, at: $timestamp("2024-01-01T00:00:00Z") }
+@kind { type: 2 }`)
+	expectDiagnostics(t, s.Validate(doc), map[string][]string{
+		"event.type": {"expected string, found number"},
+		"kind.type":  {"expected string, found number"},
+		"":           {"missing namespace @price"},
+	})
+}
+
+func TestSchemaCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`@a { type: "strng" }`,
+		`@a { type: "string", minimum: 3 }`,
+		`@a { x: { type: "array", items: 5 } }`,
+	} {
+		if _, err := schema.Parse([]byte(src)); err == nil {
+			t.Errorf("expected compile error for %s", src)
+		}
+	}
+}
+
+func TestSchemaConcurrentValidate(t *testing.T) {
+	s, err := schema.Parse([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	good := mustParse(t, `@user { id: "1", age: 1 }`)
+	bad := mustParse(t, `@user { id: 1 }`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if diags := s.Validate(good); len(diags) != 0 {
+					t.Errorf("unexpected diagnostics: %v", diags)
+				}
+			} else if diags := s.Validate(bad); len(diags) != 2 {
+				t.Errorf("expected 2 diagnostics, got %v", diags)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSchemaConstraints(t *testing.T) {
+	s, err := schema.Parse([]byte(`@const { US_PHONE_PATTERN: "^\d{3}-\d{3}-\d{4}$" }
+
+@order {
+	price: { type: "decimal", minimum: 0, precision: 6, scale: 2 },
+	quantity: { type: "integer", exclusiveMinimum: 0, maximum: 100 },
+	phone: { type: "string", pattern: &const.US_PHONE_PATTERN },
+	code: { type: "string", minLength: 2, maxLength: 4 },
+	tags: { type: "array", items: "string", minItems: 1, maxItems: 3, uniqueItems: true },
+	placed: { type: "timestamp", after: $timestamp("2020-01-01T00:00:00Z"), before: "2030-01-01T00:00:00Z" }
+}`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	ok := s.Validate(mustParse(t, `@order {
+	price: $decimal("1042.75"),
+	quantity: 3,
+	phone: "123-456-7890",
+	code: "US",
+	tags: ["a", "b"],
+	placed: $timestamp("2025-03-22T14:45:00Z")
+}`))
+	if len(ok) != 0 {
+		t.Errorf("unexpected diagnostics: %v", ok)
+	}
+
+	diags := s.Validate(mustParse(t, `@order {
+	price: $decimal("-10421.755"),
+	quantity: 0,
+	phone: "555-1234",
+	code: "USAXX",
+	tags: ["a", "a", "b", "c"],
+	placed: $timestamp("2019-03-22T14:45:00Z")
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"order.price":    {"less than minimum 0", "more than scale 2", "more than precision 6"},
+		"order.quantity": {"must be greater than 0"},
+		"order.phone":    {"does not match pattern"},
+		"order.code":     {"greater than maxLength 4"},
+		"order.tags":     {"more than maxItems 3"},
+		"order.tags[1]":  {"items must be unique"},
+		"order.placed":   {"is not after"},
+	})
+}
+
+func TestSchemaTimestampBounds(t *testing.T) {
+	s, err := schema.Parse([]byte(`@event {
+	day: { type: "timestamp", after: "2024-01-01", before: "2025-01-01", format: "date" }
+}`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if diags := s.Validate(mustParse(t, `@event { day: $timestamp("2024-06-01") }`)); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+	diags := s.Validate(mustParse(t, `@event { day: $timestamp("2023-12-31") }`))
+	expectDiagnostics(t, diags, map[string][]string{"event.day": {"is not after 2024-01-01"}})
+
+	for src, want := range map[string]string{
+		`@event { at: { type: "timestamp", after: "2024-01-01" } }`:                            "event.at.after: invalid timestamp",
+		`@event { at: { type: "timestamp", before: "2024-01-01T00:00:00Z", format: "date" } }`: "event.at.before: invalid timestamp",
+	} {
+		if _, err := schema.Parse([]byte(src)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q from %s, got %v", want, src, err)
+		}
+	}
+}
+
+const compositionSchema = `@definitions {
+	address: {
+		type: "struct",
+		properties: { city: "string", zip: { type: "string", pattern: "^\d{5}$" } },
+		required: ["city"]
+	},
+	geo: { type: "tuple", items: ["float", "float"] },
+	node: {
+		type: "struct",
+		properties: {
+			name: "string",
+			children: { type: "array", items: { $ref: "node" } }
+		}
+	}
+}
+
+@place {
+	home: { $ref: "address" },
+	work: { $ref: "address", nullable: true },
+	location: { oneOf: [{ $ref: "address" }, { $ref: "geo" }] },
+	id: { anyOf: ["string", { type: "integer", minimum: 1 }] },
+	code: { allOf: [{ type: "string", minLength: 2 }, { type: "string", maxLength: 3 }] },
+	tree: { $ref: "node" }
+}`
+
+func TestSchemaComposition(t *testing.T) {
+	s, err := schema.Parse([]byte(compositionSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if got := s.Namespaces(); len(got) != 1 || got[0] != "place" {
+		t.Errorf("expected only the place namespace, got %v", got)
+	}
+
+	ok := s.Validate(mustParse(t, `@place {
+	home: { city: "Palm Springs", zip: "92264" },
+	work: null,
+	location: $tuple(33.8, -116.5),
+	id: 7,
+	code: "US",
+	tree: { name: "root", children: [{ name: "leaf", children: [] }] }
+}`))
+	if len(ok) != 0 {
+		t.Errorf("unexpected diagnostics: %v", ok)
+	}
+
+	diags := s.Validate(mustParse(t, `@place {
+	home: null,
+	work: { zip: "9226" },
+	location: { zip: "92264" },
+	id: 0,
+	code: "USAX",
+	tree: { name: "root", children: [{ name: 5 }] }
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"place.home":                  {"expected struct, found null"},
+		"place.work":                  {`missing required field "city"`},
+		"place.work.zip":              {"does not match pattern"},
+		"place.location":              {"matches none of oneOf"},
+		"place.id":                    {"matches none of anyOf"},
+		"place.code":                  {"allOf[1]: length 4 is greater than maxLength 3"},
+		"place.tree.children[0].name": {"expected string, found number"},
+	})
+
+	for _, d := range diags {
+		switch d.Path {
+		case "place.location":
+			for _, want := range []string{`address: missing required field "city"`, "geo: expected tuple, found object"} {
+				if !strings.Contains(d.Message, want) {
+					t.Errorf("oneOf explanation missing %q: %s", want, d.Message)
+				}
+			}
+		case "place.id":
+			for _, want := range []string{"branch 0 (string): expected string", "branch 1 (integer): 0 is less than minimum 1"} {
+				if !strings.Contains(d.Message, want) {
+					t.Errorf("anyOf explanation missing %q: %s", want, d.Message)
+				}
+			}
+		}
+	}
+}
+
+func TestSchemaOneOfAmbiguous(t *testing.T) {
+	s, err := schema.Parse([]byte(`@a { v: { oneOf: ["number", { type: "integer" }] } }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	diags := s.Validate(mustParse(t, `@a { v: 3 }`))
+	expectDiagnostics(t, diags, map[string][]string{"a.v": {"matches 2 oneOf branches"}})
+}
+
+func TestSchemaExternalRef(t *testing.T) {
+	dir := t.TempDir()
+	writeFileIn(t, dir, "common.shos", `@definitions {
+	money: { type: "decimal", scale: 2 },
+	ledger: { type: "array", items: { $ref: "user.shos#entry" } }
+}`)
+	path := writeFileIn(t, dir, "user.shos", `@definitions {
+	entry: { type: "struct", properties: { amount: { $ref: "common.shos#money" } } }
+}
+
+@account {
+	balance: { $ref: "common.shos#money" },
+	entries: { $ref: "common.shos#ledger" }
+}`)
+
+	s, err := schema.LoadFile(path)
+	if err != nil {
+		t.Fatalf("schema.LoadFile failed: %v", err)
+	}
+	diags := s.Validate(mustParse(t, `@account {
+	balance: $decimal("10.125"),
+	entries: [{ amount: $decimal("1.5") }, { amount: 2 }]
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"account.balance":           {"more than scale 2"},
+		"account.entries[1].amount": {"expected decimal, found number"},
+	})
+}
+
+func TestSchemaCompositionCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`@a { x: { $ref: "missing" } }`,
+		`@a { x: { oneOf: [] } }`,
+		`@a { x: { nullable: true } }`,
+		`@a { x: { $ref: "nofile.shos#thing" } }`,
+	} {
+		if _, err := schema.Parse([]byte(src)); err == nil {
+			t.Errorf("expected compile error for %s", src)
+		}
+	}
+}
+
+func TestSchemaRefCycles(t *testing.T) {
+	for src, want := range map[string]string{
+		`@definitions { a: "a" }`:                                                 "a -> a",
+		`@definitions { a: { $ref: "b" }, b: { $ref: "a" } }`:                     "a -> b -> a",
+		`@definitions { a: { oneOf: ["string", { $ref: "a" }] } }`:                "a -> a",
+		`@definitions { a: { $ref: "b", nullable: true }, b: "a" } @x { y: "a" }`: "a -> b -> a",
+	} {
+		if _, err := schema.Parse([]byte(src)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected $ref cycle %s in %s, got %v", want, src, err)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFileIn(t, dir, "other.shos", `@definitions { b: { $ref: "main.shos#a" } }`)
+	path := writeFileIn(t, dir, "main.shos", `@definitions { a: { $ref: "other.shos#b" } }`)
+	if _, err := schema.LoadFile(path); err == nil || !strings.Contains(err.Error(), "$ref cycle") {
+		t.Errorf("expected a $ref cycle across files, got %v", err)
+	}
+
+	// Cycles through a container describe finite values.
+	s, err := schema.Parse([]byte(`@definitions {
+	list: { type: "struct", properties: { next: { $ref: "list", nullable: true } } },
+	tree: { oneOf: ["string", { type: "array", items: { $ref: "tree" } }] }
+}
+@x { l: "list", t: "tree" }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if diags := s.Validate(mustParse(t, `@x { l: { next: { next: null } }, t: ["a", ["b"]] }`)); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %v", diags)
+	}
+}
+
+func TestSchemaRefTarget(t *testing.T) {
+	s, err := schema.Parse([]byte(`@definitions {
+	person: { type: "struct", properties: { name: "string", age: "integer" }, required: ["name"] }
+}
+
+@team {
+	lead: { type: "ref", target: "people" },
+	owner: { type: "ref", target: { $ref: "person" } },
+	members: { type: "array", items: { type: "ref", target: "people" } }
+}`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+
+	ok := s.Validate(mustParse(t, `@people {
+	sean: { name: "Sean", age: 40, buddy: &people.ellie },
+	ellie: { name: "Ellie", buddy: &people.sean }
+}
+
+@team {
+	lead: &people.sean,
+	owner: &people.ellie,
+	members: [&people.sean, &people.ellie]
+}`))
+	if len(ok) != 0 {
+		t.Errorf("unexpected diagnostics: %v", ok)
+	}
+
+	diags := s.Validate(mustParse(t, `@people {
+	sean: { name: "Sean", age: "forty" }
+}
+
+@places { home: { city: "Palm Springs" } }
+
+@team {
+	lead: &places.home,
+	owner: &people.sean,
+	members: [&people.sean, &people.nobody]
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"team.lead":       {"must point into @people"},
+		"team.owner":      {"&people.sean does not match the target schema: age: expected integer, found string"},
+		"team.members[1]": {"dangling reference &people.nobody"},
+	})
+
+	if _, err := schema.Parse([]byte(`@a { x: { type: "string", target: "people" } }`)); err == nil {
+		t.Error("expected compile error for target on a string")
+	}
+}
+
+const defaultsSchema = `@definitions {
+	retry: { type: "struct", properties: { attempts: { type: "integer", default: 3 } } }
+}
+
+@service {
+	name: "string",
+	port: { type: "integer", default: 8080 },
+	rate: { type: "decimal", default: $decimal("0.25") },
+	since: { type: "timestamp", default: $timestamp("2025-01-01T00:00:00Z") },
+	tags: { type: "array", items: "string", default: ["web"] },
+	retry: { $ref: "retry" },
+	backends: { type: "array", items: { type: "struct", properties: { weight: { type: "integer", default: 1 } } } },
+	owner: { type: "string", default: "ops" }
+}`
+
+func TestSchemaFillDefaults(t *testing.T) {
+	s, err := schema.Parse([]byte(defaultsSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	doc := mustParse(t, `@service {
+	name: "api",
+	retry: {},
+	backends: [{ weight: 5 }, {}],
+	owner: "me"
+}`)
+
+	filled := s.FillDefaults(doc)
+	want := mustParse(t, `@service {
+	name: "api",
+	retry: { attempts: 3 },
+	backends: [{ weight: 5 }, { weight: 1 }],
+	owner: "me",
+	port: 8080,
+	rate: $decimal("0.25"),
+	since: $timestamp("2025-01-01T00:00:00Z"),
+	tags: ["web"]
+}`)
+	if !pkg.Equal(filled.Namespaces[0].Body, want.Namespaces[0].Body) {
+		t.Errorf("unexpected result:\n%s", pkg.Encode(filled, pkg.EncodeOptions{Indent: 2}))
+	}
+	if _, ok := doc.Namespaces[0].Body.Get("port"); ok {
+		t.Error("FillDefaults modified its input")
+	}
+}
+
+func TestSchemaInvalidDefault(t *testing.T) {
+	for _, src := range []string{
+		`@a { port: { type: "integer", default: "80" } }`,
+		`@a { rate: { type: "decimal", minimum: 1, default: $decimal("0.5") } }`,
+	} {
+		if _, err := schema.Parse([]byte(src)); err == nil || !strings.Contains(err.Error(), "invalid default") {
+			t.Errorf("expected invalid default error for %s, got %v", src, err)
+		}
+	}
+}
+
+func TestUnmarshalWithDefaults(t *testing.T) {
+	s, err := schema.Parse([]byte(defaultsSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	var cfg struct {
+		Name  string
+		Port  int
+		Rate  float64
+		Since time.Time
+		Tags  []string
+		Retry struct {
+			Attempts int `shon:"attempts"`
+		}
+		Backends []map[string]int `json:"backends"`
+	}
+	src := []byte(`@service { name: "api", retry: {}, backends: [{}] }`)
+	if err := pkg.UnmarshalWithOptions(src, &cfg, pkg.DecodeOptions{Defaults: s}); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if cfg.Name != "api" || cfg.Port != 8080 || cfg.Rate != 0.25 || cfg.Retry.Attempts != 3 ||
+		len(cfg.Tags) != 1 || cfg.Tags[0] != "web" || cfg.Backends[0]["weight"] != 1 ||
+		!cfg.Since.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+}
+
+const tupleSchema = `@Vec3 {
+	type: "tuple",
+	items: [
+		{ name: "x", type: "float" },
+		{ name: "y", type: "float" },
+		{ name: "z", type: "float" }
+	]
+}
+
+@definitions {
+	Pair: { type: "tuple", items: ["string", "integer"] }
+}
+
+@scene {
+	camera: "Vec3",
+	target: { $ref: "Vec3" },
+	label: "Pair"
+}`
+
+func TestSchemaNamedTuples(t *testing.T) {
+	s, err := schema.Parse([]byte(tupleSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if got := s.Namespaces(); len(got) != 1 || got[0] != "scene" {
+		t.Errorf("expected tuple definitions not to be namespaces, got %v", got)
+	}
+
+	ok := s.Validate(mustParse(t, `@scene {
+	camera: Vec3(1.0, 2.0, 3.0),
+	target: $tuple(0, 0, 0),
+	label: Pair("a", 1),
+	extra: { points: [Vec3(1, 1, 1)] }
+}`))
+	if len(ok) != 0 {
+		t.Errorf("unexpected diagnostics: %v", ok)
+	}
+
+	diags := s.Validate(mustParse(t, `@scene {
+	camera: Vec3(1.0, 2.0),
+	target: Pair("a", 1),
+	label: Pair(1, "a"),
+	extra: { points: [Vec3(1, "y", 1)], other: Vec4(1, 2, 3, 4) }
+}`))
+	expectDiagnostics(t, diags, map[string][]string{
+		"scene.camera":             {"expected 3 tuple items, found 2"},
+		"scene.target":             {"expected tuple Vec3, found tuple Pair"},
+		"scene.label[0]":           {"expected string, found number"},
+		"scene.label[1]":           {"expected integer, found string"},
+		"scene.extra.points[0][1]": {"expected number, found string"},
+		"scene.extra.other":        {"unknown tuple type Vec4"},
+	})
+
+	if fields, ok := s.TupleFields("Vec3"); !ok || strings.Join(fields, ",") != "x,y,z" {
+		t.Errorf("unexpected Vec3 fields: %v", fields)
+	}
+	if _, ok := s.TupleFields("Pair"); ok {
+		t.Error("Pair has unnamed positions and should have no fields")
+	}
+}
+
+func TestNamedTupleJSON(t *testing.T) {
+	s, err := schema.Parse([]byte(tupleSchema))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	doc := mustParse(t, `@scene { camera: Vec3(1, 2, 3), target: Vec3(0, 0, 1), label: Pair("a", 1) }`)
+
+	out, err := pkg.MarshalJSON(pkg.DocumentToJSON(doc, pkg.ConvertOptions{TupleObjects: s}), "")
+	if err != nil {
+		t.Fatalf("MarshalJSON failed: %v", err)
+	}
+	want := `{"camera":{"x":1,"y":2,"z":3},"target":{"x":0,"y":0,"z":1},"label":["a",1]}`
+	if string(out) != want {
+		t.Errorf("unexpected JSON: %s", out)
+	}
+
+	input, err := pkg.ParseJSON(out)
+	if err != nil {
+		t.Fatalf("ParseJSON failed: %v", err)
+	}
+	typed, diags := s.TypeJSON(input)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	if !pkg.Equal(typed.Namespaces[0].Body, doc.Namespaces[0].Body) {
+		t.Errorf("round trip differs:\n%s", pkg.Encode(typed, pkg.EncodeOptions{}))
+	}
+	if got := typed.Namespaces[0].Body.Values["camera"].(*pkg.Tuple).Name; got != "Vec3" {
+		t.Errorf("expected a Vec3 tuple, got %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package pkg_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+func TestSecrets(t *testing.T) {
+	text, err := pkg.GenerateSecretKey()
+	if err != nil {
+		t.Fatalf("GenerateSecretKey failed: %v", err)
+	}
+	key, err := pkg.ParseSecretKey(text)
+	if err != nil {
+		t.Fatalf("ParseSecretKey failed: %v", err)
+	}
+	other, _ := pkg.GenerateSecretKey()
+	wrongKey, _ := pkg.ParseSecretKey(other)
+	if _, err := pkg.ParseSecretKey("c2hvcnQ="); err == nil {
+		t.Error("expected a 5-byte key to be rejected")
+	}
+
+	src := []byte(`// credentials
+@db {
+    user: "admin",
+    password: "hunter2"  // rotate monthly
+}`)
+	encrypted, err := pkg.EncryptSource(src, []string{"db.password"}, key)
+	if err != nil {
+		t.Fatalf("EncryptSource failed: %v", err)
+	}
+	if strings.Contains(string(encrypted), "hunter2") || !strings.Contains(string(encrypted), "// rotate monthly") {
+		t.Errorf("unexpected encrypted source:\n%s", encrypted)
+	}
+	doc := mustParse(t, string(encrypted))
+	secret, ok := doc.Namespace("db").Body.Values["password"].(pkg.Secret)
+	if !ok {
+		t.Fatalf("password did not parse as a secret:\n%s", encrypted)
+	}
+	if _, err := pkg.DecryptSecret(secret, "db.password", wrongKey); !errors.Is(err, pkg.ErrSecretDecrypt) {
+		t.Errorf("expected ErrSecretDecrypt with the wrong key, got %v", err)
+	}
+	if _, err := pkg.DecryptSecret(secret, "db.user", key); !errors.Is(err, pkg.ErrSecretDecrypt) {
+		t.Errorf("expected ErrSecretDecrypt at another path, got %v", err)
+	}
+	if plaintext, err := pkg.DecryptSecret(secret, "&db.password", key); err != nil || plaintext != "hunter2" {
+		t.Errorf("DecryptSecret = %q, %v", plaintext, err)
+	}
+	moved := mustParse(t, strings.Replace(string(encrypted), `user: "admin"`, `user: `+pkg.EncodeValue(secret, 0, pkg.EncodeOptions{}), 1))
+	if _, err := pkg.DecryptSecrets(moved, key); !errors.Is(err, pkg.ErrSecretDecrypt) || !strings.HasPrefix(err.Error(), "db.user: ") {
+		t.Errorf("expected a secret copied to db.user to fail to decrypt, got %v", err)
+	}
+	if again := mustParse(t, pkg.Encode(doc, pkg.EncodeOptions{})); again.Namespace("db").Body.Values["password"] != secret {
+		t.Error("secret does not survive encoding")
+	}
+
+	if got := pkg.ToJSON(doc.Namespace("db").Body, pkg.ConvertOptions{}).(*pkg.Object).Values["password"]; got != pkg.RedactedSecret {
+		t.Errorf("secret not redacted in JSON, got %v", got)
+	}
+	revealed, err := pkg.DecryptSecrets(doc, key)
+	if err != nil || revealed.Namespace("db").Body.Values["password"] != "hunter2" {
+		t.Errorf("DecryptSecrets = %v, %v", revealed, err)
+	}
+
+	var cfg struct {
+		User     string
+		Password string
+	}
+	if err := pkg.Decode(doc, &cfg); err == nil || !strings.Contains(err.Error(), "without a key") {
+		t.Errorf("expected decoding a secret without a key to fail, got %v", err)
+	}
+	if err := pkg.DecodeWithOptions(doc, &cfg, pkg.DecodeOptions{SecretKey: key}); err != nil || cfg.Password != "hunter2" {
+		t.Errorf("decoded %+v, %v", cfg, err)
+	}
+	var raw struct{ Password pkg.Secret }
+	if err := pkg.Decode(doc, &raw); err != nil || raw.Password != secret {
+		t.Errorf("expected the ciphertext decoded into Secret, got %+v, %v", raw, err)
+	}
+
+	decrypted, err := pkg.DecryptSource(encrypted, nil, key)
+	if err != nil || string(decrypted) != string(src) {
+		t.Errorf("DecryptSource did not restore the source:\n%s\n%v", decrypted, err)
+	}
+
+	s, err := schema.Parse([]byte(`@db { user: "string", password: "secret" }`))
+	if err != nil {
+		t.Fatalf("schema.Parse failed: %v", err)
+	}
+	if diags := s.Validate(doc); pkg.HasErrors(diags) {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+	if diags := s.Validate(mustParse(t, string(src))); !pkg.HasErrors(diags) {
+		t.Error("a plaintext password should not satisfy the secret type")
+	}
+
+	for _, bad := range []string{`@a { s: $secret("not base64!") }`, `@a { s: $secret("c2hvcnQ=") }`, `@a { s: $secret(1) }`} {
+		if _, err := pkg.Parse([]byte(bad)); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package pkg_test
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func TestSignVerify(t *testing.T) {
+	private, public, err := pkg.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey failed: %v", err)
+	}
+	priv, err := pkg.ParsePrivateKey(private)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	pub, err := pkg.ParsePublicKey(public)
+	if err != nil {
+		t.Fatalf("ParsePublicKey failed: %v", err)
+	}
+	if _, err := pkg.ParsePublicKey(private); err == nil {
+		t.Error("a private key should not parse as a public key")
+	}
+
+	src := []byte(`// deploy config
+@db { host: "db.internal", port: 5432, timeout: $decimal("1.50") }`)
+	doc := mustParse(t, string(src))
+	signature := pkg.Sign(doc, priv)
+	if !strings.HasPrefix(signature, pkg.SignaturePrefix) {
+		t.Errorf("unexpected signature %q", signature)
+	}
+
+	reformatted := mustParse(t, `@db {
+    port: 5432,   // default port
+    timeout: $decimal("1.5"),
+    host: "db.internal"
+}`)
+	if err := pkg.Verify(reformatted, pub, signature); err != nil {
+		t.Errorf("reformatting should keep the signature valid: %v", err)
+	}
+	tampered := mustParse(t, `@db { host: "evil.example", port: 5432, timeout: $decimal("1.50") }`)
+	if err := pkg.Verify(tampered, pub, signature); !errors.Is(err, pkg.ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for tampered data, got %v", err)
+	}
+	if err := pkg.Verify(doc, pub, "ed25519:bm90IGEgc2lnbmF0dXJl"); err == nil || errors.Is(err, pkg.ErrBadSignature) {
+		t.Errorf("expected a malformed signature error, got %v", err)
+	}
+
+	embedded, err := pkg.SetMetaSource(src, pkg.SignatureMeta, signature)
+	if err != nil {
+		t.Fatalf("SetMetaSource failed: %v", err)
+	}
+	signed := mustParse(t, string(embedded))
+	if got := pkg.EmbeddedSignature(signed); got != signature {
+		t.Fatalf("embedded signature %q, want %q", got, signature)
+	}
+	if err := pkg.Verify(signed, pub, pkg.EmbeddedSignature(signed)); err != nil {
+		t.Errorf("$signature should not be covered by the signature: %v", err)
+	}
+	if pkg.Sign(signed, priv) != signature {
+		t.Error("re-signing a signed document should ignore its old signature")
+	}
+}
+
+func TestSignMetadataSwap(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	data := mustParse(t, `@db { "$x": "read-only", host: "db.internal" }`)
+	meta := mustParse(t, `@db { $x: "read-only", host: "db.internal" }`)
+
+	if err := pkg.Verify(meta, pub, pkg.Sign(data, priv)); !errors.Is(err, pkg.ErrBadSignature) {
+		t.Errorf("turning data key \"$x\" into metadata should break the signature, got %v", err)
+	}
+	if err := pkg.Verify(data, pub, pkg.Sign(meta, priv)); !errors.Is(err, pkg.ErrBadSignature) {
+		t.Errorf("turning metadata $x into a data key should break the signature, got %v", err)
+	}
+}
+
+func TestSignIncludes(t *testing.T) {
+	private, _, err := pkg.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey failed: %v", err)
+	}
+	priv, err := pkg.ParsePrivateKey(private)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+
+	dir := t.TempDir()
+	writeFileIn(t, dir, "db.shon", `@db { host: "db.internal" }`)
+	main := writeFileIn(t, dir, "main.shon", `@include "db.shon"
+@app { name: "api" }`)
+	load := func() *pkg.Document {
+		doc, err := pkg.LoadFile(main, "")
+		if err != nil {
+			t.Fatalf("LoadFile failed: %v", err)
+		}
+		return doc
+	}
+	signature := pkg.Sign(load(), priv)
+
+	writeFileIn(t, dir, "db.shon", `@db { host: "evil.example" }`)
+	if err := pkg.Verify(load(), pub, signature); !errors.Is(err, pkg.ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for a tampered include, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package pkg_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+const taggedDoc = `@features {
+	login: { enabled: true, $tags: ["public"] },
+	billing: { enabled: true, $tags: ["public", "internal"], $type: "feature" },
+	search: { enabled: false, $tags: ["beta"] },
+	plain: { enabled: true },
+	list: [
+		{ id: 1, $tags: ["public"] },
+		{ id: 2, $tags: ["internal"] }
+	]
+}`
+
+func TestTagsAreMetadata(t *testing.T) {
+	doc, err := pkg.Parse([]byte(taggedDoc))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	v, _ := doc.Lookup("features.billing")
+	billing := v.(*pkg.Object)
+	if _, ok := billing.Get("$tags"); ok {
+		t.Error("$tags stored as data")
+	}
+	if tags := billing.Tags(); len(tags) != 2 || tags[1] != "internal" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if typ, _ := billing.GetMeta("type"); typ != "feature" {
+		t.Errorf("unexpected $type %v", typ)
+	}
+}
+
+func TestFilterTags(t *testing.T) {
+	doc, err := pkg.Parse([]byte(taggedDoc))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expr, err := pkg.ParseTagExpr("public,!internal")
+	if err != nil {
+		t.Fatalf("ParseTagExpr failed: %v", err)
+	}
+
+	out := pkg.FilterTags(doc, expr)
+	body := out.Namespace("features").Body
+	for _, key := range []string{"login", "plain"} {
+		if _, ok := body.Get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+	for _, key := range []string{"billing", "search"} {
+		if _, ok := body.Get(key); ok {
+			t.Errorf("%s should be filtered out", key)
+		}
+	}
+	if list, _ := out.Lookup("features.list"); len(list.([]interface{})) != 1 {
+		t.Errorf("array items not filtered: %v", list)
+	}
+}
+
+func TestFilterTupleTags(t *testing.T) {
+	doc, err := pkg.Parse([]byte(`@shapes {
+	pair: Pair({ id: 1, $tags: ["public"] }, { id: 2, $tags: ["internal"] }),
+	nested: [Box({ inner: { id: 3, $tags: ["internal"] } })]
+}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expr, err := pkg.ParseTagExpr("!internal")
+	if err != nil {
+		t.Fatalf("ParseTagExpr failed: %v", err)
+	}
+
+	out := pkg.FilterTags(doc, expr)
+	pair, _ := out.Lookup("shapes.pair")
+	if items := pair.(*pkg.Tuple).Items; len(items) != 1 {
+		t.Errorf("expected the internal tuple argument to be filtered out, got %d items", len(items))
+	}
+	box, _ := out.Lookup("shapes.nested[0]")
+	if _, ok := box.(*pkg.Tuple).Items[0].(*pkg.Object).Get("inner"); ok {
+		t.Error("tagged objects inside tuple arguments should be filtered")
+	}
+	if original, _ := doc.Lookup("shapes.pair"); len(original.(*pkg.Tuple).Items) != 2 {
+		t.Error("FilterTags should not change its input")
+	}
+}
+
+func TestFilterNamespaceTags(t *testing.T) {
+	doc, err := pkg.Parse([]byte(`@internal { $tags: ["internal"], secret: 1 }
+@public { $tags: ["public"], name: "x" }
+@plain { y: 2 }`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expr, _ := pkg.ParseTagExpr("!internal")
+	out := pkg.FilterTags(doc, expr)
+	if out.Namespace("internal") != nil {
+		t.Error("namespace tagged internal should be filtered out")
+	}
+	if out.Namespace("public") == nil || out.Namespace("plain") == nil {
+		t.Error("other namespaces should be kept")
+	}
+	if doc.Namespace("internal") == nil {
+		t.Error("FilterTags changed its input")
+	}
+}
+
+func TestFilterFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFileIn(t, dir, "shared.shon", `@flags { beta: { on: true, $tags: ["beta"] }, ga: { on: true } }`)
+	in := writeFileIn(t, dir, "main.shon", `@include "shared.shon"
+@app { name: "x" }`)
+	out := filepath.Join(dir, "out.shon")
+
+	if err := pkg.FilterFile(in, "", out, "!beta", pkg.EncodeOptions{}); err != nil {
+		t.Fatalf("FilterFile failed: %v", err)
+	}
+	result := readFile(t, out)
+	if strings.Contains(result, "beta") || !strings.Contains(result, "ga") || !strings.Contains(result, "app") {
+		t.Errorf("included objects not filtered:\n%s", result)
+	}
+}
+
+func TestShonToJsonMeta(t *testing.T) {
+	in := writeTempFile(t, "input.shon", taggedDoc)
+	dropped := filepath.Join(t.TempDir(), "dropped.json")
+	kept := filepath.Join(t.TempDir(), "kept.json")
+
+	if err := pkg.ShonToJson(in, dropped); err != nil {
+		t.Fatalf("ShonToJson failed: %v", err)
+	}
+	if result := readFile(t, dropped); strings.Contains(result, "$tags") {
+		t.Errorf("metadata should be dropped by default:\n%s", result)
+	}
+
+	if err := pkg.ShonToJsonWithOptions(in, kept, pkg.ConvertOptions{KeepMeta: true}); err != nil {
+		t.Fatalf("ShonToJsonWithOptions failed: %v", err)
+	}
+	if result := readFile(t, kept); !strings.Contains(result, `"$type": "feature"`) {
+		t.Errorf("metadata should be kept:\n%s", result)
+	}
+}
+
+func TestEncodeDollarDataKeys(t *testing.T) {
+	doc, err := pkg.Parse([]byte(`@d { "$tags": ["data"], $tags: ["meta"], "$ref": 1 }`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	text := pkg.Encode(doc, pkg.EncodeOptions{})
+	if !strings.Contains(text, `"$tags": ["data"]`) || !strings.Contains(text, `"$ref": 1`) {
+		t.Errorf("data keys starting with $ should be quoted:\n%s", text)
+	}
+
+	again, err := pkg.Parse([]byte(text))
+	if err != nil {
+		t.Fatalf("Parse of encoded document failed: %v\n%s", err, text)
+	}
+	body := again.Namespace("d").Body
+	if tags, ok := body.Get("$tags"); !ok || len(tags.([]interface{})) != 1 {
+		t.Errorf("data key $tags lost in round trip: %v", tags)
+	}
+	if _, ok := body.Get("$ref"); !ok {
+		t.Error("data key $ref lost in round trip")
+	}
+	if tags := body.Tags(); len(tags) != 1 || tags[0] != "meta" {
+		t.Errorf("unexpected metadata tags %v", tags)
+	}
+	if !pkg.Equal(doc.Namespace("d").Body, body) {
+		t.Error("round trip should give an equal document")
+	}
+}
+
+func TestJsonToShonDollarKeys(t *testing.T) {
+	in := writeTempFile(t, "input.json", `{"d": {"$tags": ["x"], "name": "a"}}`)
+	out := filepath.Join(t.TempDir(), "out.shon")
+	if err := pkg.JsonToShon(in, out, false); err != nil {
+		t.Fatalf("JsonToShon failed: %v", err)
+	}
+	doc, err := pkg.LoadFile(out, "")
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	v, _ := doc.Lookup("data.d")
+	body := v.(*pkg.Object)
+	if _, ok := body.Get("$tags"); !ok {
+		t.Errorf("JSON key $tags should stay data:\n%s", readFile(t, out))
+	}
+	if len(body.Tags()) != 0 {
+		t.Errorf("JSON key $tags became metadata:\n%s", readFile(t, out))
+	}
+}
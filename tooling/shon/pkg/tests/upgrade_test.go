@@ -0,0 +1,127 @@
+package pkg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+	"github.com/sottey/shon/tooling/shon/pkg/schema"
+)
+
+const legacyDoc = `$schema: "./person-v0.3.shon"
+
+// people
+@person {
+	sean: {
+		name: "Sean",
+		phone: "123-456-7890", // mobile
+		_comment.phone: "US format",
+		bio: '''
+			Developer.
+			  Comic.
+		''',
+		friends: &person.list[role=admin],
+	},
+}
+
+@config {
+	app.settings.theme: "dark",
+	app.settings.mode: "dev",
+}
+`
+
+func TestDetectVersion(t *testing.T) {
+	tests := []struct {
+		src      string
+		version  string
+		declared bool
+	}{
+		{legacyDoc, "0.3", true},
+		{"@a { b: '''x''' }", "0.2", false},
+		{"@const { X: 1 }\n@a { b: 1, $type: \"t\" }", "0.4", false},
+		{"@a { b: $decimal(\"1.0\") }", "0.6", false},
+		{"$schema: \"0.6\"\n@a { b: \"string\" }", "0.6", true},
+		{"@a { b: 1 }", "0.6", false},
+	}
+	for _, tt := range tests {
+		info := pkg.DetectVersion([]byte(tt.src))
+		if info.Version != tt.version || info.Declared != tt.declared {
+			t.Errorf("DetectVersion(%q) = %s (declared %v), want %s (declared %v)", tt.src, info.Version, info.Declared, tt.version, tt.declared)
+		}
+	}
+}
+
+func TestUpgradeDocument(t *testing.T) {
+	out, report, err := pkg.Upgrade([]byte(legacyDoc))
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	text := string(out)
+	for _, want := range []string{`$schema: "./person-v0.3.shos"`, "// people", "// mobile", "// phone: US format", `"&person.list[role=admin]"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	doc := mustParse(t, text)
+	if bio, _ := doc.Lookup("person.sean.bio"); bio != "Developer.\n  Comic." {
+		t.Errorf("unexpected bio %q", bio)
+	}
+	for path, want := range map[string]string{"config.app.settings.theme": "dark", "config.app.settings.mode": "dev"} {
+		if v, err := doc.Lookup(path); err != nil || v != want {
+			t.Errorf("%s = %v (%v), want %q", path, v, err, want)
+		}
+	}
+	if len(report.Diagnostics) != 1 || !strings.Contains(report.Diagnostics[0].Message, "filtered reference") {
+		t.Errorf("expected one filtered reference warning, got %v", report.Diagnostics)
+	}
+}
+
+const legacySchema = `@schema.person {
+	type: "object",
+	keys: {
+		name: { type: "string", required: true, comment: "Full name" },
+		home: { type: "ref", namespace: "address" },
+		$type: { type: "string" },
+		settings: {
+			type: "object",
+			keys: {
+				"ui.theme": { type: "string", namespacedKey: true }
+			}
+		}
+	}
+}
+
+@schema.address {
+	type: "object",
+	keys: { city: { type: "string" } }
+}
+`
+
+func TestUpgradeSchema(t *testing.T) {
+	out, report, err := pkg.Upgrade([]byte(legacySchema))
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if !report.Schema {
+		t.Error("expected the input to be recognised as a schema")
+	}
+	if len(report.Diagnostics) != 1 || !strings.Contains(report.Diagnostics[0].Message, "$type") {
+		t.Errorf("expected a warning about $type, got %v", report.Diagnostics)
+	}
+
+	s, err := schema.Parse(out)
+	if err != nil {
+		t.Fatalf("upgraded schema does not compile: %v\n%s", err, out)
+	}
+	doc := mustParse(t, `@address { hq: { city: "Palm Springs" } }
+@person { name: "Sean", home: &address.hq, settings: { ui: { theme: 1 } } }`)
+	expectDiagnostics(t, s.Validate(doc), map[string][]string{
+		"person.settings.ui.theme": {"expected string"},
+	})
+	expectDiagnostics(t, s.Validate(mustParse(t, `@address { }
+@person { home: &nowhere.x }`)), map[string][]string{
+		"person":      {"name"},
+		"person.home": {"@address"},
+	})
+}
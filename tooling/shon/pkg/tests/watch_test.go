@@ -0,0 +1,92 @@
+package pkg_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sottey/shon/tooling/shon/pkg"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.shon", `@a { b: 1 }`)
+	write("notes.txt", "ignored")
+
+	ticks := make(chan time.Time)
+	w := &pkg.Watcher{
+		Inputs:   []string{dir},
+		Match:    func(f string) bool { return strings.HasSuffix(f, ".shon") },
+		Debounce: 100 * time.Millisecond,
+		Ticks:    ticks,
+	}
+	files, err := w.Files()
+	if err != nil || !reflect.DeepEqual(files, []string{filepath.Join(dir, "a.shon")}) {
+		t.Fatalf("Files = %v, %v", files, err)
+	}
+
+	stop := make(chan struct{})
+	changes := make(chan []string, 10)
+	done := make(chan error)
+	go func() { done <- w.Run(stop, func(files []string) { changes <- files }) }()
+
+	// Run handles one tick before it receives the next, so each step sends
+	// its tick twice to know the first has been handled. A write made after
+	// a step may still be seen by the step's second tick, so it counts as
+	// made at that step or the next, and the times below allow for either.
+	start := time.Now()
+	step := func(after time.Duration) {
+		ticks <- start.Add(after)
+		ticks <- start.Add(after)
+	}
+	expectNone := func(when string) {
+		select {
+		case got := <-changes:
+			t.Errorf("%s: unexpected changes %v", when, got)
+		default:
+		}
+	}
+	expect := func(when string, want ...string) {
+		select {
+		case got := <-changes:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got changes %v, want %v", when, got, want)
+			}
+		default:
+			t.Errorf("%s: no change reported, want %v", when, want)
+		}
+	}
+
+	step(0)
+	write("a.shon", `@a { b: 2, c: 3 }`)
+	step(10 * time.Millisecond)
+	write("b.shon", `@b { c: 1 }`)
+	write("notes.txt", "still ignored")
+	step(50 * time.Millisecond)
+	step(105 * time.Millisecond)
+	expectNone("before the second write settled")
+	step(200 * time.Millisecond)
+	expect("once settled", filepath.Join(dir, "a.shon"), filepath.Join(dir, "b.shon"))
+	step(time.Second)
+	expectNone("after the report")
+
+	if err := os.Remove(filepath.Join(dir, "b.shon")); err != nil {
+		t.Fatal(err)
+	}
+	step(2 * time.Second)
+	step(3 * time.Second)
+	expect("after the removal", filepath.Join(dir, "b.shon"))
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+}
@@ -0,0 +1,696 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CurrentVersion is the SHON spec version this package reads and writes.
+const CurrentVersion = "0.6"
+
+// legacyFeature is a syntax feature that identifies the spec version a
+// file was written against. Removed features do not parse in 0.6 and are
+// rewritten by Upgrade.
+type legacyFeature struct {
+	name    string
+	since   string
+	removed bool
+}
+
+var (
+	featureTripleQuoted   = legacyFeature{"triple-quoted strings", "0.2", true}
+	featureSchemaNS       = legacyFeature{"@schema.<name> namespaces", "0.2", true}
+	featureFilteredRef    = legacyFeature{"filtered references", "0.2", true}
+	featureNamespacedKeys = legacyFeature{"namespaced keys", "0.3", true}
+	featureCommentKeys    = legacyFeature{"_comment keys", "0.3", true}
+	featureInclude        = legacyFeature{"@include", "0.3", false}
+	featureConst          = legacyFeature{"@const", "0.4", false}
+	featureAlias          = legacyFeature{"@alias", "0.4", false}
+	featureTypeMeta       = legacyFeature{"$type annotations", "0.4", false}
+	featureSchemaMeta     = legacyFeature{"$schema", "0.5", false}
+	featureTypedValues    = legacyFeature{"$decimal, $timestamp and tuples", "0.6", false}
+)
+
+// VersionInfo is the result of DetectVersion.
+type VersionInfo struct {
+	// Version is the spec version the file appears to be written against.
+	Version string
+	// Declared is true when Version comes from $schema rather than from
+	// the syntax the file uses.
+	Declared bool
+	// Features lists the version-specific syntax found in the file.
+	Features []string
+}
+
+var versionInName = regexp.MustCompile(`(?:^|[^0-9.])v?(0\.[2-6])(?:[^0-9]|$)`)
+
+// DetectVersion guesses the SHON spec version of a document or schema. A
+// $schema naming a version ("0.5"), or a schema file whose name contains
+// one, wins; otherwise the newest feature the file uses decides. Files
+// that use nothing version-specific are reported as the current version.
+func DetectVersion(src []byte) VersionInfo {
+	_, scan := scanLegacy(src)
+	info := VersionInfo{Version: CurrentVersion}
+	newest, removed := "", false
+	for _, f := range scan.features {
+		info.Features = append(info.Features, f.name)
+		if f.since > newest {
+			newest = f.since
+		}
+		removed = removed || f.removed
+	}
+	if removed && newest > "0.5" {
+		newest = "0.5"
+	}
+	if newest != "" {
+		info.Version = newest
+	}
+
+	if scan.schemaRef != "" {
+		if m := versionInName.FindStringSubmatch(scan.schemaRef); m != nil {
+			info.Version, info.Declared = m[1], true
+		} else if strings.HasSuffix(scan.schemaRef, ".shos") {
+			info.Version, info.Declared = CurrentVersion, true
+		}
+	}
+	return info
+}
+
+// UpgradeReport describes what Upgrade did.
+type UpgradeReport struct {
+	// From is the version DetectVersion found.
+	From string
+	// Schema is true when the input was a pre-0.6 schema, written with
+	// @schema.<name> namespaces.
+	Schema bool
+	// Changes lists every rewrite made.
+	Changes []string
+	// Diagnostics reports constructs that could not be translated.
+	Diagnostics []Diagnostic
+}
+
+// Upgrade rewrites a document or schema written against SHON 0.2–0.5 in
+// current syntax:
+//
+//   - triple-quoted strings become ordinary strings, with the common
+//     indentation removed
+//   - namespaced keys (app.settings.theme: "dark") become nested objects
+//   - _comment.<field> keys become comments
+//   - @schema.<name> schemas become .shos namespaces: object types become
+//     structs, keys become properties, per-field required flags become
+//     required lists, comment becomes description and a ref's namespace
+//     becomes target
+//   - a $schema pointing at a .shon schema is pointed at the .shos file
+//     the schema upgrades to
+//
+// @const, @alias, $type and $tags are still valid and are kept. Filtered
+// references (&ns.list[key=value]) have no 0.6 form; they are kept as
+// strings and reported. The source is edited in place, so comments and
+// formatting are kept.
+func Upgrade(src []byte) ([]byte, *UpgradeReport, error) {
+	out, scan := scanLegacy(src)
+	report := &UpgradeReport{
+		From:        DetectVersion(src).Version,
+		Schema:      scan.schema,
+		Changes:     scan.changes,
+		Diagnostics: scan.diags,
+	}
+	if _, err := Parse(out); err != nil {
+		return nil, report, fmt.Errorf("cannot upgrade: %w", err)
+	}
+
+	u := &upgrader{src: out, report: report, namespaced: scan.namespacedKeys}
+	if err := u.commentKeys(); err != nil {
+		return nil, report, err
+	}
+	if report.Schema {
+		if err := u.schema(); err != nil {
+			return nil, report, err
+		}
+	} else {
+		if err := u.namespacedKeys(); err != nil {
+			return nil, report, err
+		}
+		if err := u.schemaRef(); err != nil {
+			return nil, report, err
+		}
+	}
+	return u.src, report, nil
+}
+
+// legacyScan is what scanLegacy found.
+type legacyScan struct {
+	features       []legacyFeature
+	schema         bool
+	schemaRef      string
+	namespacedKeys map[string]bool
+	changes        []string
+	diags          []Diagnostic
+}
+
+func (s *legacyScan) found(f legacyFeature) {
+	for _, seen := range s.features {
+		if seen == f {
+			return
+		}
+	}
+	s.features = append(s.features, f)
+}
+
+// scanLegacy tokenizes source that may use pre-0.6 syntax, noting the
+// version-specific features it meets and rewriting the ones the 0.6 parser
+// cannot read: triple-quoted strings, @schema.<name> namespace names,
+// filtered references and unquoted dotted keys, which it quotes.
+func scanLegacy(src []byte) ([]byte, *legacyScan) {
+	s := &legacyScan{namespacedKeys: map[string]bool{}}
+	var out bytes.Buffer
+	var last byte // last significant character written
+	schemaRefNext := false
+	line := func(i int) int { return bytes.Count(src[:i], []byte("\n")) + 1 }
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case bytes.HasPrefix(src[i:], []byte("//")):
+			end := bytes.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			out.Write(src[i : i+end])
+			i += end
+		case bytes.HasPrefix(src[i:], []byte("/*")):
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				out.Write(src[i:])
+				i = len(src)
+				break
+			}
+			out.Write(src[i : i+end+4])
+			i += end + 4
+		case bytes.HasPrefix(src[i:], []byte("'''")):
+			end := bytes.Index(src[i+3:], []byte("'''"))
+			if end < 0 {
+				out.Write(src[i:])
+				i = len(src)
+				break
+			}
+			s.found(featureTripleQuoted)
+			s.changes = append(s.changes, fmt.Sprintf("line %d: triple-quoted string rewritten", line(i)))
+			out.WriteString(quote(dedent(string(src[i+3 : i+3+end]))))
+			i += end + 6
+			last = '"'
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(src))
+			if schemaRefNext {
+				s.schemaRef = strings.Trim(string(src[i:j]), `"`)
+				schemaRefNext = false
+			}
+			out.Write(src[i:j])
+			i = j
+			last = '"'
+		case c == '@':
+			j := i + 1
+			for j < len(src) && (isWordByte(src[j]) || src[j] == '.') {
+				j++
+			}
+			name := string(src[i+1 : j])
+			switch {
+			case strings.HasPrefix(name, "schema."):
+				s.found(featureSchemaNS)
+				s.schema = true
+				s.changes = append(s.changes, fmt.Sprintf("line %d: @%s renamed to @%s", line(i), name, name[len("schema."):]))
+				name = name[len("schema."):]
+			case name == "include":
+				s.found(featureInclude)
+			case name == ConstNamespace:
+				s.found(featureConst)
+			case name == AliasNamespace:
+				s.found(featureAlias)
+			}
+			out.WriteString("@" + name)
+			i = j
+			last = '@'
+		case c == '&':
+			j := i + 1
+			for j < len(src) {
+				if src[j] == '[' {
+					end := bytes.IndexByte(src[j:], ']')
+					if end < 0 {
+						break
+					}
+					j += end + 1
+					continue
+				}
+				if !isWordByte(src[j]) && src[j] != '.' {
+					break
+				}
+				j++
+			}
+			ref := string(src[i:j])
+			if strings.Contains(ref, "=") {
+				s.found(featureFilteredRef)
+				s.diags = append(s.diags, Diagnostic{Severity: SeverityWarning, Message: fmt.Sprintf("line %d: filtered reference %s has no 0.6 equivalent; kept as a string", line(i), ref)})
+				ref = quote(ref)
+			}
+			out.WriteString(ref)
+			i = j
+			last = '&'
+		case c == '$':
+			j := i + 1
+			for j < len(src) && isWordByte(src[j]) {
+				j++
+			}
+			switch string(src[i+1 : j]) {
+			case "type":
+				s.found(featureTypeMeta)
+			case "schema":
+				s.found(featureSchemaMeta)
+				schemaRefNext = last != '{' && last != ','
+
+			case "decimal", "timestamp", "tuple":
+				s.found(featureTypedValues)
+			}
+			out.Write(src[i:j])
+			i = j
+			last = '$'
+		case isWordByte(c) && (last == '{' || last == ','):
+			j := i
+			for j < len(src) && (isWordByte(src[j]) || src[j] == '.') {
+				j++
+			}
+			word := string(src[i:j])
+			k := j
+			for k < len(src) && (src[k] == ' ' || src[k] == '\t') {
+				k++
+			}
+			if strings.Contains(word, ".") && k < len(src) && src[k] == ':' {
+				if strings.HasPrefix(word, commentKeyPrefix) {
+					s.found(featureCommentKeys)
+				} else {
+					s.found(featureNamespacedKeys)
+					s.namespacedKeys[word] = true
+				}
+				word = quote(word)
+			}
+			out.WriteString(word)
+			i = j
+			last = 'a'
+		default:
+			out.WriteByte(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				last = c
+			}
+			i++
+		}
+	}
+	return out.Bytes(), s
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// dedent removes the line break after the opening triple quote and before
+// the closing one, and the indentation common to every non-blank line.
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, l := range lines {
+		if len(l) >= indent && indent > 0 {
+			lines[i] = l[indent:]
+		} else {
+			lines[i] = strings.TrimLeft(l, " \t")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+const commentKeyPrefix = "_comment."
+
+// upgrader applies the rewrites that need the parsed document. Every edit
+// re-parses the source.
+type upgrader struct {
+	src        []byte
+	report     *UpgradeReport
+	namespaced map[string]bool
+}
+
+func (u *upgrader) changef(path, format string, args ...interface{}) {
+	u.report.Changes = append(u.report.Changes, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (u *upgrader) warnf(path, format string, args ...interface{}) {
+	u.report.Diagnostics = append(u.report.Diagnostics, Diagnostic{Severity: SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (u *upgrader) doc() (*Document, error) {
+	return Parse(u.src)
+}
+
+// object returns the object at path, or nil.
+func (u *upgrader) object(path string) (*Object, error) {
+	doc, err := u.doc()
+	if err != nil {
+		return nil, err
+	}
+	v, err := doc.Lookup(path)
+	if err != nil {
+		return nil, nil
+	}
+	obj, _ := v.(*Object)
+	return obj, nil
+}
+
+// find returns the path of the first object with a key matching match,
+// and that key.
+func (u *upgrader) find(match func(key string) bool) (string, string, error) {
+	doc, err := u.doc()
+	if err != nil {
+		return "", "", err
+	}
+	var path, key string
+	WalkDocument(doc, func(v interface{}, p string) error {
+		if obj, ok := v.(*Object); ok && key == "" {
+			for _, k := range obj.Keys {
+				if match(k) {
+					path, key = p, k
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return path, key, nil
+}
+
+func (u *upgrader) edit(src []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	u.src = src
+	return nil
+}
+
+// commentKeys turns _comment.<field>: "text" entries into comments.
+func (u *upgrader) commentKeys() error {
+	for {
+		path, key, err := u.find(func(k string) bool { return strings.HasPrefix(k, commentKeyPrefix) })
+		if err != nil || key == "" {
+			return err
+		}
+		field := strings.TrimPrefix(key, commentKeyPrefix)
+		err = u.edit(editSource(u.src, func(doc *Document, m *SourceMap) (Span, string, error) {
+			obj, _ := doc.Lookup(path)
+			e, err := m.entry(JoinPath(path, key))
+			if err != nil {
+				return Span{}, "", err
+			}
+			text, _ := obj.(*Object).Values[key].(string)
+			comment := strings.ReplaceAll(field+": "+text, "\n", " ")
+
+			end := skipBlanks(m.src, e.Value.End)
+			if end < len(m.src) && m.src[end] == ',' {
+				end++
+			}
+			if rest := skipBlanks(m.src, end); rest == len(m.src) || m.src[rest] == '\n' {
+				return Span{e.Key.Start, end}, "// " + comment, nil
+			}
+			return Span{e.Key.Start, end}, "/* " + strings.ReplaceAll(comment, "*/", "* /") + " */", nil
+		}))
+		if err != nil {
+			return err
+		}
+		u.changef(path, "%s became a comment", key)
+	}
+}
+
+// namespacedKeys nests the values of dotted keys the scan quoted.
+func (u *upgrader) namespacedKeys() error {
+	for {
+		path, key, err := u.find(func(k string) bool { return u.namespaced[k] })
+		if err != nil || key == "" {
+			return err
+		}
+		delete(u.namespaced, key)
+		if err := u.nest(path, key, func(p string) string { return p }, NewObject); err != nil {
+			return err
+		}
+	}
+}
+
+// nest moves the value of the dotted key at path into nested containers:
+// app.settings.theme: "dark" becomes app: { settings: { theme: "dark" } }.
+// inner gives the path fields of a container live under, and container
+// makes a new, empty one.
+func (u *upgrader) nest(path, key string, inner func(string) string, container func() *Object) error {
+	parts := strings.Split(key, ".")
+	parent := path
+	for _, part := range parts[:len(parts)-1] {
+		obj, err := u.object(parent)
+		if err != nil {
+			return err
+		}
+		child, ok := obj.Get(part)
+		if !ok {
+			if err := u.edit(InsertSource(u.src, parent, part, container())); err != nil {
+				return err
+			}
+		} else if _, isObj := child.(*Object); !isObj {
+			u.warnf(JoinPath(path, key), "cannot nest %s: %s is already a %s", key, JoinPath(parent, part), TypeName(child))
+			return nil
+		}
+		parent = inner(JoinPath(parent, part))
+	}
+	leaf := parts[len(parts)-1]
+	if obj, err := u.object(parent); err != nil {
+		return err
+	} else if _, exists := obj.Get(leaf); exists {
+		u.warnf(JoinPath(path, key), "cannot nest %s: %s already exists", key, JoinPath(parent, leaf))
+		return nil
+	}
+
+	_, m, err := ParseWithSourceMap(u.src)
+	if err != nil {
+		return err
+	}
+	e, err := m.entry(JoinPath(path, key))
+	if err != nil {
+		return err
+	}
+	raw := string(u.src[e.Value.Start:e.Value.End])
+	fromLevel := m.level(e.Key.Start)
+	if err := u.edit(RemoveSource(u.src, JoinPath(path, key))); err != nil {
+		return err
+	}
+	if err := u.edit(insertSource(u.src, parent, leaf, func(level, unit int) string {
+		return reindent(raw, (level-fromLevel)*unit)
+	})); err != nil {
+		return err
+	}
+	u.changef(path, "namespaced key %s nested", key)
+	return nil
+}
+
+// schemaRef points a document's $schema at the .shos file its .shon
+// schema upgrades to.
+func (u *upgrader) schemaRef() error {
+	doc, err := u.doc()
+	if err != nil {
+		return err
+	}
+	ref := doc.Schema()
+	if !strings.HasSuffix(ref, ".shon") {
+		return nil
+	}
+	shos := strings.TrimSuffix(ref, ".shon") + ".shos"
+	if err := u.edit(SetMetaSource(u.src, "schema", shos)); err != nil {
+		return err
+	}
+	u.changef("$schema", "now %s; upgrade %s to it with shon upgrade", shos, ref)
+	return nil
+}
+
+// schema rewrites @schema.<name> definitions, already renamed to @<name>
+// by the scan, as .shos namespaces.
+func (u *upgrader) schema() error {
+	doc, err := u.doc()
+	if err != nil {
+		return err
+	}
+	for _, ns := range doc.Namespaces {
+		switch ns.Name {
+		case ConstNamespace, AliasNamespace:
+			continue
+		}
+		if err := u.schemaNode(ns.Name); err != nil {
+			return err
+		}
+	}
+	if doc, err = u.doc(); err != nil {
+		return err
+	}
+	WalkDocument(doc, func(v interface{}, path string) error {
+		if ref, ok := v.(Ref); ok && strings.HasPrefix(string(ref), ConstNamespace+".") {
+			if _, err := doc.Lookup(string(ref)); err != nil {
+				u.warnf(path, "constant &%s is not defined in the schema; copy it into an @const block", ref)
+			}
+		}
+		return nil
+	})
+	if v, _ := doc.Meta.Get("schema"); v != CurrentVersion {
+		if err := u.edit(SetMetaSource(u.src, "schema", CurrentVersion)); err != nil {
+			return err
+		}
+		u.changef("$schema", "set to %q", CurrentVersion)
+	}
+	return nil
+}
+
+// legacyRenames maps pre-0.6 schema keywords to their .shos names.
+var legacyRenames = map[string]string{
+	"keys":    "properties",
+	"comment": "description",
+}
+
+func (u *upgrader) schemaNode(path string) error {
+	obj, err := u.object(path)
+	if err != nil || obj == nil {
+		return err
+	}
+
+	switch obj.Values["type"] {
+	case "object":
+		if err := u.edit(SetSource(u.src, JoinPath(path, "type"), "struct")); err != nil {
+			return err
+		}
+		u.changef(path, "type \"object\" is now \"struct\"")
+	case "null":
+		u.warnf(path, "type \"null\" has no .shos equivalent; mark the field nullable: true instead")
+	case "ref":
+		if _, ok := obj.Get("namespace"); ok {
+			if err := u.edit(RenameSource(u.src, JoinPath(path, "namespace"), "target")); err != nil {
+				return err
+			}
+			u.changef(path, "namespace is now target")
+		}
+	}
+	for _, old := range []string{"keys", "comment"} {
+		if _, ok := obj.Get(old); ok {
+			if _, clash := obj.Get(legacyRenames[old]); clash {
+				u.warnf(path, "cannot rename %s: %s is already set", old, legacyRenames[old])
+				continue
+			}
+			if err := u.edit(RenameSource(u.src, JoinPath(path, old), legacyRenames[old])); err != nil {
+				return err
+			}
+			u.changef(path, "%s is now %s", old, legacyRenames[old])
+		}
+	}
+	if _, ok := obj.Get("namespacedKey"); ok {
+		if err := u.edit(RemoveSource(u.src, JoinPath(path, "namespacedKey"))); err != nil {
+			return err
+		}
+	}
+
+	if err := u.schemaProperties(path); err != nil {
+		return err
+	}
+	if items, ok := obj.Values["items"].(*Object); ok && items != nil {
+		return u.schemaNode(JoinPath(path, "items"))
+	}
+	return nil
+}
+
+func (u *upgrader) schemaProperties(path string) error {
+	propsPath := JoinPath(path, "properties")
+	props, err := u.object(propsPath)
+	if err != nil || props == nil {
+		return err
+	}
+
+	if props.Meta != nil {
+		for _, k := range props.Meta.Keys {
+			if err := u.edit(RemoveSource(u.src, JoinPath(propsPath, "$"+k))); err != nil {
+				return err
+			}
+			u.warnf(JoinPath(propsPath, "$"+k), "schema for metadata $%s dropped; .shos schemas do not describe metadata", k)
+		}
+	}
+
+	for _, name := range props.Keys {
+		if !strings.Contains(name, ".") {
+			continue
+		}
+		if err := u.nest(propsPath, name, func(p string) string { return JoinPath(p, "properties") }, newStructSchema); err != nil {
+			return err
+		}
+	}
+
+	if props, err = u.object(propsPath); err != nil {
+		return err
+	}
+	var required []interface{}
+	for _, name := range props.Keys {
+		field, ok := props.Values[name].(*Object)
+		if !ok {
+			continue
+		}
+		flag, ok := field.Values["required"].(bool)
+		if !ok {
+			continue
+		}
+		if flag {
+			required = append(required, name)
+		}
+		if err := u.edit(RemoveSource(u.src, JoinPath(JoinPath(propsPath, name), "required"))); err != nil {
+			return err
+		}
+	}
+	if len(required) > 0 {
+		if err := u.edit(InsertSource(u.src, path, "required", required)); err != nil {
+			return err
+		}
+		u.changef(path, "required flags collected into required")
+	}
+
+	for _, name := range props.Keys {
+		if strings.Contains(name, ".") {
+			continue
+		}
+		if err := u.schemaNode(JoinPath(propsPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newStructSchema() *Object {
+	obj := NewObject()
+	obj.Set("type", "struct")
+	obj.Set("properties", NewObject())
+	return obj
+}
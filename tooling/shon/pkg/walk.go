@@ -0,0 +1,96 @@
+package pkg
+
+import "fmt"
+
+// Walk calls fn for v and every value nested inside it, parents before
+// children. path is the SHON path of v; nested paths are derived from it.
+// Returning an error from fn stops the walk.
+func Walk(v interface{}, path string, fn func(v interface{}, path string) error) error {
+	if err := fn(v, path); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case *Object:
+		for _, k := range val.Keys {
+			if err := Walk(val.Values[k], JoinPath(path, k), fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if err := Walk(item, IndexPath(path, i), fn); err != nil {
+				return err
+			}
+		}
+	case *Tuple:
+		for i, item := range val.Items {
+			if err := Walk(item, IndexPath(path, i), fn); err != nil {
+				return err
+			}
+		}
+	case *Env:
+		if val.HasDefault {
+			return Walk(val.Default, path, fn)
+		}
+	case *Expr:
+		// Operands have no path of their own and are walked with the
+		// expression's.
+		for _, arg := range val.Args {
+			if err := Walk(arg, path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WalkDocument walks every namespace body in doc.
+func WalkDocument(doc *Document, fn func(v interface{}, path string) error) error {
+	for _, ns := range doc.Namespaces {
+		if err := Walk(ns.Body, ns.Name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite replaces every value nested inside v, children first, with the
+// result of fn. Containers are updated in place.
+func Rewrite(v interface{}, path string, fn func(v interface{}, path string) interface{}) interface{} {
+	switch val := v.(type) {
+	case *Object:
+		for _, k := range val.Keys {
+			val.Values[k] = Rewrite(val.Values[k], JoinPath(path, k), fn)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = Rewrite(item, IndexPath(path, i), fn)
+		}
+	case *Tuple:
+		for i, item := range val.Items {
+			val.Items[i] = Rewrite(item, IndexPath(path, i), fn)
+		}
+	case *Env:
+		if val.HasDefault {
+			val.Default = Rewrite(val.Default, path, fn)
+		}
+	case *Expr:
+		for i, arg := range val.Args {
+			val.Args[i] = Rewrite(arg, path, fn)
+		}
+	}
+	return fn(v, path)
+}
+
+// JoinPath appends an object key to a SHON path.
+func JoinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// IndexPath appends an array index to a SHON path.
+func IndexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Watcher polls files for changes. It needs nothing beyond os.Stat, so it
+// behaves the same on every platform and file system.
+type Watcher struct {
+	// Inputs are files, directories and glob patterns, as for PlanBatch.
+	Inputs []string
+	// Match selects the files of directories and patterns to watch; named
+	// files are always watched.
+	Match func(file string) bool
+	// Interval is how often files are polled.
+	Interval time.Duration
+	// Debounce is how long files must stay unchanged before a change is
+	// reported, so that an editor saving in several writes causes one report.
+	Debounce time.Duration
+	// Ticks, if set, replaces the Interval ticker: files are polled on
+	// each receive and the time received is taken as the current time.
+	// Tests use it to step through time.
+	Ticks <-chan time.Time
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Files lists the files the watcher watches. Named files that do not exist
+// are left out.
+func (w *Watcher) Files() ([]string, error) {
+	states, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(states))
+	for f := range states {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (w *Watcher) scan() (map[string]fileState, error) {
+	states := map[string]fileState{}
+	for _, input := range w.Inputs {
+		files, _, err := expandInput(input)
+		if os.IsNotExist(err) && !hasMeta(input) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if files == nil {
+			files = []string{input}
+		} else if w.Match != nil {
+			matched := files[:0]
+			for _, f := range files {
+				if w.Match(f) {
+					matched = append(matched, f)
+				}
+			}
+			files = matched
+		}
+		for _, f := range files {
+			info, err := os.Stat(f)
+			if err != nil {
+				continue
+			}
+			states[filepath.Clean(f)] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states, nil
+}
+
+// Run polls until stop is closed, calling changed with the files that were
+// created, modified or removed once they have settled. Changes made while
+// changed runs are reported by the next call. Run returns an error only if
+// the inputs cannot be listed.
+func (w *Watcher) Run(stop <-chan struct{}, changed func(files []string)) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 250 * time.Millisecond
+	}
+	last, err := w.scan()
+	if err != nil {
+		return err
+	}
+	pending := map[string]bool{}
+	var settled time.Time
+
+	ticks := w.Ticks
+	if ticks == nil {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		case now := <-ticks:
+			current, err := w.scan()
+			if err != nil {
+				return err
+			}
+			for f, s := range current {
+				if prev, ok := last[f]; !ok || prev != s {
+					pending[f] = true
+					settled = now.Add(w.Debounce)
+				}
+			}
+			for f := range last {
+				if _, ok := current[f]; !ok {
+					pending[f] = true
+					settled = now.Add(w.Debounce)
+				}
+			}
+			last = current
+
+			if len(pending) == 0 || now.Before(settled) {
+				continue
+			}
+			files := make([]string, 0, len(pending))
+			for f := range pending {
+				files = append(files, f)
+			}
+			sort.Strings(files)
+			pending = map[string]bool{}
+			changed(files)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// EncodeOptions controls how documents are written back out as SHON.
+type EncodeOptions struct {
+	Indent   int
+	SortKeys bool
+}
+
+var bareKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Encode writes a document as SHON text.
+func Encode(doc *Document, opts EncodeOptions) string {
+	if opts.Indent <= 0 {
+		opts.Indent = 4
+	}
+	var sb strings.Builder
+	if doc.Meta != nil {
+		for _, k := range doc.Meta.Keys {
+			sb.WriteString(fmt.Sprintf("$%s: %s\n", k, EncodeValue(doc.Meta.Values[k], 0, opts)))
+		}
+		if doc.Meta.Len() > 0 && len(doc.Namespaces)+len(doc.Includes) > 0 {
+			sb.WriteString("\n")
+		}
+	}
+	for _, inc := range doc.Includes {
+		sb.WriteString(fmt.Sprintf("@include %s\n", quote(inc)))
+	}
+	if len(doc.Includes) > 0 && len(doc.Namespaces) > 0 {
+		sb.WriteString("\n")
+	}
+	for i, ns := range doc.Namespaces {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		body := EncodeValue(ns.Body, 0, opts)
+		if ns.Name == AliasNamespace {
+			body = encodeAliases(ns.Body, opts)
+		}
+		sb.WriteString(fmt.Sprintf("@%s %s\n", ns.Name, body))
+	}
+	return sb.String()
+}
+
+// WriteFile encodes a document and writes it to path, or to stdout when path
+// is empty.
+func WriteFile(doc *Document, path string, opts EncodeOptions) error {
+	out := Encode(doc, opts)
+	if path == "" {
+		fmt.Print(out)
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+		return fmt.Errorf("failed to write SHON file: %w", err)
+	}
+	return nil
+}
+
+// EncodeValue writes a single SHON value. level is the indentation level of
+// the line the value starts on.
+func EncodeValue(v interface{}, level int, opts EncodeOptions) string {
+	if opts.Indent <= 0 {
+		opts.Indent = 4
+	}
+	switch val := v.(type) {
+	case *Object:
+		var entries []string
+		if val.Meta != nil {
+			for _, k := range val.Meta.Keys {
+				entries = append(entries, "$"+k+": "+EncodeValue(val.Meta.Values[k], level+1, opts))
+			}
+		}
+		keys := val.Keys
+		if opts.SortKeys {
+			keys = append([]string(nil), keys...)
+			sort.Strings(keys)
+		}
+		for _, k := range keys {
+			entries = append(entries, encodeKey(k)+": "+EncodeValue(val.Values[k], level+1, opts))
+		}
+		if len(entries) == 0 {
+			return "{}"
+		}
+		var sb strings.Builder
+		sb.WriteString("{")
+		for i, entry := range entries {
+			sb.WriteString("\n" + IndentLine(level+1, opts.Indent, entry))
+			if i < len(entries)-1 {
+				sb.WriteString(",")
+			}
+		}
+		sb.WriteString("\n" + IndentLine(level, opts.Indent, "}"))
+		return sb.String()
+	case []interface{}:
+		if isFlat(val) {
+			return "[" + encodeList(val, level, opts) + "]"
+		}
+		var sb strings.Builder
+		sb.WriteString("[")
+		for i, item := range val {
+			sb.WriteString("\n" + IndentLine(level+1, opts.Indent, EncodeValue(item, level+1, opts)))
+			if i < len(val)-1 {
+				sb.WriteString(",")
+			}
+		}
+		sb.WriteString("\n" + IndentLine(level, opts.Indent, "]"))
+		return sb.String()
+	case *Tuple:
+		name := val.Name
+		if name == "" {
+			name = "$tuple"
+		}
+		return name + "(" + encodeList(val.Items, level, opts) + ")"
+	case string:
+		return quote(val)
+	case json.Number:
+		return string(val)
+	case Decimal:
+		return fmt.Sprintf("$decimal(%s)", quote(string(val)))
+	case Timestamp:
+		return fmt.Sprintf("$timestamp(%s)", quote(string(val)))
+	case Secret:
+		return fmt.Sprintf("$secret(%s)", quote(string(val)))
+	case *Env:
+		if val.HasDefault {
+			return fmt.Sprintf("$env(%s, %s)", quote(val.Name), EncodeValue(val.Default, level, opts))
+		}
+		return fmt.Sprintf("$env(%s)", quote(val.Name))
+	case *Expr:
+		return fmt.Sprintf("$expr(%s)", quote(FormatExpr(val)))
+	case Ref:
+		return "&" + string(val)
+	case bool:
+		return fmt.Sprintf("%v", val)
+	case nil:
+		return "null"
+	default:
+		return quote(fmt.Sprintf("%v", val))
+	}
+}
+
+// encodeAliases writes an @alias body with bare namespace names.
+func encodeAliases(body *Object, opts EncodeOptions) string {
+	if body.Len() == 0 {
+		return "{}"
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range body.Keys {
+		v := EncodeValue(body.Values[k], 1, opts)
+		if s, ok := body.Values[k].(string); ok && bareKeyPattern.MatchString(s) {
+			v = s
+		}
+		sb.WriteString("\n" + IndentLine(1, opts.Indent, encodeKey(k)+": "+v))
+		if i < body.Len()-1 {
+			sb.WriteString(",")
+		}
+	}
+	sb.WriteString("\n}")
+	return sb.String()
+}
+
+func encodeList(items []interface{}, level int, opts EncodeOptions) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = EncodeValue(item, level, opts)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isFlat reports whether every item can be written on a single line.
+func isFlat(items []interface{}) bool {
+	for _, item := range items {
+		switch val := item.(type) {
+		case *Object:
+			if val.Len() > 0 || (val.Meta != nil && val.Meta.Len() > 0) {
+				return false
+			}
+		case []interface{}:
+			if !isFlat(val) {
+				return false
+			}
+		case *Tuple:
+			if !isFlat(val.Items) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func encodeKey(k string) string {
+	if bareKeyPattern.MatchString(k) {
+		return k
+	}
+	return quote(k)
+}
+
+// quote writes s as a double-quoted SHON string.
+func quote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}